@@ -12,8 +12,13 @@ import (
 
 	"github.com/jefflinse/potato-nice-thelma/internal/cataas"
 	"github.com/jefflinse/potato-nice-thelma/internal/config"
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+	"github.com/jefflinse/potato-nice-thelma/internal/fetch"
+	"github.com/jefflinse/potato-nice-thelma/internal/imagedir"
 	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+	"github.com/jefflinse/potato-nice-thelma/internal/pool"
 	"github.com/jefflinse/potato-nice-thelma/internal/potato"
+	"github.com/jefflinse/potato-nice-thelma/internal/resilient"
 	"github.com/jefflinse/potato-nice-thelma/internal/server"
 )
 
@@ -30,12 +35,100 @@ func main() {
 		os.Exit(1)
 	}
 
-	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resilience := resilient.Config{
+		MaxRetries:       cfg.HTTPMaxRetries,
+		FailureThreshold: cfg.HTTPBreakerThreshold,
+		Cooldown:         cfg.HTTPBreakerCooldown,
+	}
+	if cfg.HTTPMaxRetries == 0 {
+		resilience.MaxRetries = -1
+	}
+	httpClient := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: resilient.New(http.DefaultTransport, resilience),
+	}
+
+	limits := decode.Limits{MaxPixels: cfg.MaxImagePixels}
+
+	dirOpts := []imagedir.Option{
+		imagedir.WithRecursive(cfg.ImageDirRecursive),
+		imagedir.WithFormats(cfg.ImageDirFormats...),
+	}
+	var dirs []*imagedir.Dir
+
+	reddit := potato.NewRedditClient(httpClient,
+		potato.WithListingTTL(cfg.RedditCacheTTL),
+		potato.WithListing(potato.ListingOptions{
+			Subreddits: cfg.RedditSubreddits,
+			Sort:       cfg.RedditSort,
+			Time:       cfg.RedditTime,
+			Limit:      cfg.RedditLimit,
+		}),
+		// Among several sources, failures have to show.
+		potato.WithFallback(len(cfg.PotatoSources) == 0),
+	)
+	var potatoDir *imagedir.Dir
+	if cfg.PotatoDir != "" {
+		dir, err := imagedir.New(cfg.PotatoDir, dirOpts...)
+		if err != nil {
+			slog.Error("failed to load potato directory", "error", err)
+			os.Exit(1)
+		}
+		potatoDir = dir
+		dirs = append(dirs, dir)
+	}
+
+	var potatoClient potato.Searcher = reddit
+	switch {
+	case len(cfg.PotatoSources) > 0:
+		agg, err := potatoAggregator(cfg, reddit, potatoDir, httpClient)
+		if err != nil {
+			slog.Error("failed to set up potato sources", "error", err)
+			os.Exit(1)
+		}
+		potatoClient = agg
+	case potatoDir != nil:
+		slog.Info("serving potatoes from local directory", "dir", potatoDir.Root(), "images", potatoDir.Len())
+		potatoClient = potato.NewLocalSearcher(potatoDir)
+	}
+
+	var cataasClient cataas.Fetcher = cataas.NewClient(httpClient,
+		cataas.WithDecodeLimits(limits),
+		cataas.WithBaseURL(cfg.CataasURL),
+	)
+	if cfg.CatDir != "" {
+		dir, err := imagedir.New(cfg.CatDir, dirOpts...)
+		if err != nil {
+			slog.Error("failed to load cat directory", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("serving cats from local directory", "dir", dir.Root(), "images", dir.Len())
+		cataasClient = cataas.NewLocalClient(dir, limits)
+		dirs = append(dirs, dir)
+	}
+	go reloadOnHangup(dirs)
+
+	opts := []server.Option{
+		server.WithDecodeLimits(limits),
+		// Potato images get retries and circuit breakers too, around the
+		// fetcher's own address-checking transport.
+		server.WithImageFetcher(fetch.New(httpClient, fetch.WithTransportWrapper(func(rt http.RoundTripper) http.RoundTripper {
+			return resilient.New(rt, resilience)
+		}))),
+	}
+	if cfg.PoolDepth > 0 {
+		opts = append(opts, server.WithPool(pool.Config{
+			Depth:          cfg.PoolDepth,
+			Workers:        cfg.PoolWorkers,
+			RefillInterval: cfg.PoolRefillInterval,
+		}))
+	}
 
-	potatoClient := potato.NewRedditClient(httpClient)
-	cataasClient := cataas.NewClient(httpClient)
+	srv := server.NewServer(potatoClient, cataasClient, memeGen, httpClient, opts...)
 
-	srv := server.NewServer(potatoClient, cataasClient, memeGen, httpClient)
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	srv.Start(bgCtx)
 
 	httpServer := &http.Server{
 		Addr:    net.JoinHostPort("", cfg.Port),
@@ -55,6 +148,7 @@ func main() {
 	<-quit
 
 	slog.Info("shutting down")
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -66,3 +160,48 @@ func main() {
 
 	slog.Info("server stopped")
 }
+
+// potatoAggregator spreads potato searches over the sources named in
+// cfg.PotatoSources.
+func potatoAggregator(cfg *config.Config, reddit *potato.RedditClient, dir *imagedir.Dir, httpClient *http.Client) (*potato.Aggregator, error) {
+	var sources []potato.WeightedSource
+	for _, src := range cfg.PotatoSources {
+		var searcher potato.Searcher
+		switch src.Name {
+		case "reddit":
+			searcher = reddit
+		case "local":
+			searcher = potato.NewLocalSearcher(dir)
+			slog.Info("serving potatoes from local directory", "dir", dir.Root(), "images", dir.Len(), "weight", src.Weight)
+		case "feed":
+			searcher = potato.NewFeedSearcher(httpClient, cfg.PotatoFeedURL, 0)
+		case "fallback":
+			searcher = potato.FallbackSearcher{}
+		}
+		sources = append(sources, potato.WeightedSource{Name: src.Name, Searcher: searcher, Weight: src.Weight})
+	}
+	slog.Info("aggregating potato sources", "sources", cfg.PotatoSources)
+	return potato.NewAggregator(sources, potato.HealthConfig{
+		EjectAfter: cfg.SourceEjectAfter,
+		EjectFor:   cfg.SourceEjectFor,
+	})
+}
+
+// reloadOnHangup rescans the image directories whenever the process receives
+// SIGHUP. A directory that fails to rescan keeps serving its previous images.
+func reloadOnHangup(dirs []*imagedir.Dir) {
+	if len(dirs) == 0 {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		for _, dir := range dirs {
+			if err := dir.Reload(); err != nil {
+				slog.Error("failed to reload image directory", "dir", dir.Root(), "error", err)
+				continue
+			}
+			slog.Info("reloaded image directory", "dir", dir.Root(), "images", dir.Len())
+		}
+	}
+}
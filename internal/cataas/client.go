@@ -2,40 +2,76 @@ package cataas
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
 )
 
-const baseURL = "https://cataas.com/cat"
+// DefaultBaseURL is where CATAAS is served.
+const DefaultBaseURL = "https://cataas.com"
 
-// Fetcher retrieves cat images from CATAAS.
+// maxMetadataBytes caps the size of a cat's JSON metadata.
+const maxMetadataBytes = 64 << 10
+
+// ErrNoCat is returned when CATAAS has no cat with the requested ID or tags.
+var ErrNoCat = errors.New("no matching cat")
+
+// Fetcher retrieves cat images from CATAAS. Fetchers that choose the cat
+// themselves do so with rng; a nil rng uses a randomly seeded source.
 type Fetcher interface {
-	FetchRandomCat(ctx context.Context) (image.Image, error)
+	FetchRandomCat(ctx context.Context, rng *rand.Rand) (image.Image, error)
+}
+
+// OptionsFetcher is a Fetcher that can also choose and transform the cat.
+type OptionsFetcher interface {
+	Fetcher
+	FetchCat(ctx context.Context, o CatOptions) (Cat, error)
 }
 
 // Client is an HTTP client for the CATAAS API.
 type Client struct {
 	httpClient *http.Client
+	baseURL    string
+	limits     decode.Limits
 }
 
-// NewClient returns a new CATAAS client that uses the provided HTTP client.
-func NewClient(httpClient *http.Client) *Client {
-	return &Client{httpClient: httpClient}
+// Option configures a Client.
+type Option func(*Client)
+
+// WithDecodeLimits bounds the cat images the client will decode.
+func WithDecodeLimits(l decode.Limits) Option {
+	return func(c *Client) { c.limits = l }
 }
 
-// FetchRandomCat fetches a random cat image from CATAAS.
-func (c *Client) FetchRandomCat(ctx context.Context) (image.Image, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
+// WithBaseURL points the client at another CATAAS instance, such as a local
+// mock. The default is DefaultBaseURL.
+func WithBaseURL(u string) Option {
+	return func(c *Client) { c.baseURL = strings.TrimSuffix(u, "/") }
+}
+
+// NewClient returns a new CATAAS client that uses the provided HTTP client.
+func NewClient(httpClient *http.Client, opts ...Option) *Client {
+	c := &Client{httpClient: httpClient, baseURL: DefaultBaseURL}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
+}
 
-	resp, err := c.httpClient.Do(req)
+// FetchRandomCat fetches a random cat image from CATAAS. An animated GIF cat
+// is returned as a *decode.Animation. CATAAS picks the cat, so rng is
+// unused.
+func (c *Client) FetchRandomCat(ctx context.Context, _ *rand.Rand) (image.Image, error) {
+	resp, err := c.get(ctx, c.baseURL+"/cat")
 	if err != nil {
 		return nil, fmt.Errorf("fetching cat image: %w", err)
 	}
@@ -45,10 +81,129 @@ func (c *Client) FetchRandomCat(ctx context.Context) (image.Image, error) {
 		return nil, fmt.Errorf("cataas returned status %d", resp.StatusCode)
 	}
 
-	img, _, err := image.Decode(resp.Body)
+	img, _, err := c.limits.DecodeAnimated(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode cat image: %w", err)
 	}
 
 	return img, nil
 }
+
+// FetchCat fetches a cat chosen and transformed by o, along with its ID and
+// tags. Unless o names a cat by ID, CATAAS is first asked which cat matches,
+// so that the cat's metadata is known; the image is then fetched by ID. It
+// returns ErrNoCat if no cat matches.
+func (c *Client) FetchCat(ctx context.Context, o CatOptions) (Cat, error) {
+	if err := o.Validate(); err != nil {
+		return Cat{}, err
+	}
+
+	cat := Cat{ID: o.ID}
+	if cat.ID == "" {
+		var err error
+		if cat, err = c.metadata(ctx, o.Tags); err != nil {
+			return Cat{}, err
+		}
+	}
+
+	resp, err := c.get(ctx, c.imageURL(cat.ID, o))
+	if err != nil {
+		return Cat{}, fmt.Errorf("fetching cat image: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := statusError(resp.StatusCode); err != nil {
+		return Cat{}, err
+	}
+
+	cat.Image, _, err = c.limits.DecodeAnimated(resp.Body)
+	if err != nil {
+		return Cat{}, fmt.Errorf("failed to decode cat image: %w", err)
+	}
+	return cat, nil
+}
+
+// catMetadata is the JSON CATAAS describes a cat with. Older versions of
+// CATAAS call the ID "_id".
+type catMetadata struct {
+	ID       string   `json:"id"`
+	LegacyID string   `json:"_id"`
+	Tags     []string `json:"tags"`
+}
+
+// metadata asks CATAAS for a random cat with all of tags.
+func (c *Client) metadata(ctx context.Context, tags []string) (Cat, error) {
+	endpoint := c.baseURL + "/cat"
+	if len(tags) > 0 {
+		endpoint += "/" + strings.Join(tags, ",")
+	}
+	resp, err := c.get(ctx, endpoint+"?json=true")
+	if err != nil {
+		return Cat{}, fmt.Errorf("fetching cat metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := statusError(resp.StatusCode); err != nil {
+		return Cat{}, err
+	}
+
+	var meta catMetadata
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxMetadataBytes)).Decode(&meta); err != nil {
+		return Cat{}, fmt.Errorf("decoding cat metadata: %w", err)
+	}
+	if meta.ID == "" {
+		meta.ID = meta.LegacyID
+	}
+	if !catID.MatchString(meta.ID) {
+		return Cat{}, fmt.Errorf("cataas returned invalid cat ID %q", meta.ID)
+	}
+	return Cat{ID: meta.ID, Tags: meta.Tags}, nil
+}
+
+// imageURL returns the URL of the image of the cat with id, transformed by o.
+func (c *Client) imageURL(id string, o CatOptions) string {
+	endpoint := c.baseURL + "/cat/" + id
+	if o.Says != "" {
+		endpoint += "/says/" + url.PathEscape(o.Says)
+	}
+
+	q := url.Values{}
+	if o.Filter != "" {
+		q.Set("filter", o.Filter)
+	}
+	if o.Filter == "custom" {
+		q.Set("r", strconv.Itoa(int(o.Tint[0])))
+		q.Set("g", strconv.Itoa(int(o.Tint[1])))
+		q.Set("b", strconv.Itoa(int(o.Tint[2])))
+	}
+	if o.Width > 0 {
+		q.Set("width", strconv.Itoa(o.Width))
+	}
+	if o.Height > 0 {
+		q.Set("height", strconv.Itoa(o.Height))
+	}
+	if len(q) > 0 {
+		endpoint += "?" + q.Encode()
+	}
+	return endpoint
+}
+
+// get sends a GET request for endpoint.
+func (c *Client) get(ctx context.Context, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	return c.httpClient.Do(req)
+}
+
+// statusError returns the error for a CATAAS response with status, or nil
+// for 200.
+func statusError(status int) error {
+	switch status {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrNoCat
+	default:
+		return fmt.Errorf("cataas returned status %d", status)
+	}
+}
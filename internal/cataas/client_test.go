@@ -3,15 +3,21 @@ package cataas
 import (
 	"bytes"
 	"context"
+	"errors"
 	"image"
 	"image/color"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
 )
 
 // redirectTransport rewrites outgoing requests to point at a local test server
@@ -107,7 +113,7 @@ func TestClient_FetchRandomCat(t *testing.T) {
 		t.Cleanup(srv.Close)
 
 		client := newTestClient(srv.URL)
-		img, err := client.FetchRandomCat(context.Background())
+		img, err := client.FetchRandomCat(context.Background(), nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -137,7 +143,7 @@ func TestClient_FetchRandomCat(t *testing.T) {
 		t.Cleanup(srv.Close)
 
 		client := newTestClient(srv.URL)
-		img, err := client.FetchRandomCat(context.Background())
+		img, err := client.FetchRandomCat(context.Background(), nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -153,6 +159,32 @@ func TestClient_FetchRandomCat(t *testing.T) {
 		}
 	})
 
+	t.Run("animated GIF", func(t *testing.T) {
+		t.Parallel()
+
+		g := &gif.GIF{Delay: []int{10, 10}}
+		for _, c := range []color.Color{color.White, color.Black} {
+			g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 8, 6), color.Palette{c}))
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			t.Fatalf("encoding test GIF: %v", err)
+		}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/gif")
+			w.Write(buf.Bytes())
+		}))
+		t.Cleanup(srv.Close)
+
+		img, err := newTestClient(srv.URL).FetchRandomCat(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a, ok := img.(*decode.Animation); !ok || a.Len() != 2 {
+			t.Errorf("FetchRandomCat() = %T, want a 2-frame *decode.Animation", img)
+		}
+	})
+
 	t.Run("non-200 status code", func(t *testing.T) {
 		t.Parallel()
 
@@ -188,7 +220,7 @@ func TestClient_FetchRandomCat(t *testing.T) {
 				t.Cleanup(srv.Close)
 
 				client := newTestClient(srv.URL)
-				img, err := client.FetchRandomCat(context.Background())
+				img, err := client.FetchRandomCat(context.Background(), nil)
 				if err == nil {
 					t.Fatal("expected error, got nil")
 				}
@@ -213,7 +245,7 @@ func TestClient_FetchRandomCat(t *testing.T) {
 		t.Cleanup(srv.Close)
 
 		client := newTestClient(srv.URL)
-		img, err := client.FetchRandomCat(context.Background())
+		img, err := client.FetchRandomCat(context.Background(), nil)
 		if err == nil {
 			t.Fatal("expected error for invalid image data, got nil")
 		}
@@ -225,6 +257,29 @@ func TestClient_FetchRandomCat(t *testing.T) {
 		}
 	})
 
+	t.Run("image over pixel limit", func(t *testing.T) {
+		t.Parallel()
+
+		pngData := makePNG(t, 20, 20)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngData)
+		}))
+		t.Cleanup(srv.Close)
+
+		client := NewClient(
+			&http.Client{Transport: &redirectTransport{testServerURL: srv.URL}},
+			WithDecodeLimits(decode.Limits{MaxPixels: 399}),
+		)
+		img, err := client.FetchRandomCat(context.Background(), nil)
+		if !errors.Is(err, decode.ErrTooManyPixels) {
+			t.Fatalf("expected ErrTooManyPixels, got %v", err)
+		}
+		if img != nil {
+			t.Errorf("expected nil image on decode error, got %v", img)
+		}
+	})
+
 	t.Run("empty response body", func(t *testing.T) {
 		t.Parallel()
 
@@ -235,7 +290,7 @@ func TestClient_FetchRandomCat(t *testing.T) {
 		t.Cleanup(srv.Close)
 
 		client := newTestClient(srv.URL)
-		img, err := client.FetchRandomCat(context.Background())
+		img, err := client.FetchRandomCat(context.Background(), nil)
 		if err == nil {
 			t.Fatal("expected error for empty body, got nil")
 		}
@@ -259,7 +314,7 @@ func TestClient_FetchRandomCat(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		cancel() // Cancel immediately.
 
-		img, err := client.FetchRandomCat(ctx)
+		img, err := client.FetchRandomCat(ctx, nil)
 		if err == nil {
 			t.Fatal("expected error for cancelled context, got nil")
 		}
@@ -290,7 +345,7 @@ func TestClient_FetchRandomCat(t *testing.T) {
 		t.Cleanup(srv.Close)
 
 		client := newTestClient(srv.URL)
-		_, err := client.FetchRandomCat(context.Background())
+		_, err := client.FetchRandomCat(context.Background(), nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -300,3 +355,120 @@ func TestClient_FetchRandomCat(t *testing.T) {
 // TestFetcherInterface verifies that *Client satisfies the Fetcher interface
 // at compile time.
 var _ Fetcher = (*Client)(nil)
+
+func TestClient_FetchCat(t *testing.T) {
+	t.Parallel()
+
+	pngData := makePNG(t, 30, 20)
+	var imageRequests []*url.URL
+	var mu sync.Mutex
+	metadata := map[string]string{
+		"orange,cute": `{"id":"abc123","tags":["orange","cute","fluffy"],"mimetype":"image/png"}`,
+		"legacy":      `{"_id":"old456","tags":["legacy"]}`,
+		"sneaky":      `{"id":"../../etc/passwd"}`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /cat/{rest...}", func(w http.ResponseWriter, r *http.Request) {
+		rest := r.PathValue("rest")
+		if r.URL.Query().Get("json") == "true" {
+			if body, ok := metadata[rest]; ok {
+				w.Write([]byte(body))
+			} else {
+				http.NotFound(w, r)
+			}
+			return
+		}
+		mu.Lock()
+		imageRequests = append(imageRequests, r.URL)
+		mu.Unlock()
+		if rest == "gone" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngData)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.Client(), WithBaseURL(srv.URL+"/"))
+
+	tests := []struct {
+		name     string
+		opts     CatOptions
+		wantID   string
+		wantTags []string
+		wantPath string
+		wantErr  error
+	}{
+		{
+			name:     "tags",
+			opts:     CatOptions{Tags: []string{"orange", "cute"}},
+			wantID:   "abc123",
+			wantTags: []string{"orange", "cute", "fluffy"},
+			wantPath: "/cat/abc123",
+		},
+		{
+			name:     "legacy metadata",
+			opts:     CatOptions{Tags: []string{"legacy"}, Width: 300},
+			wantID:   "old456",
+			wantTags: []string{"legacy"},
+			wantPath: "/cat/old456?width=300",
+		},
+		{
+			name:     "id, says and custom filter",
+			opts:     CatOptions{ID: "xyz789", Says: "hello/world?", Filter: "custom", Tint: [3]uint8{255, 0, 10}, Height: 200},
+			wantID:   "xyz789",
+			wantPath: "/cat/xyz789/says/hello%2Fworld%3F?b=10&filter=custom&g=0&height=200&r=255",
+		},
+		{
+			name:    "unknown tag",
+			opts:    CatOptions{Tags: []string{"purple"}},
+			wantErr: ErrNoCat,
+		},
+		{
+			name:    "unknown id",
+			opts:    CatOptions{ID: "gone"},
+			wantErr: ErrNoCat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cat, err := client.FetchCat(context.Background(), tt.opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("FetchCat() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FetchCat() error: %v", err)
+			}
+			if cat.ID != tt.wantID || !slices.Equal(cat.Tags, tt.wantTags) {
+				t.Errorf("FetchCat() = %q %v, want %q %v", cat.ID, cat.Tags, tt.wantID, tt.wantTags)
+			}
+			if cat.Image == nil || cat.Image.Bounds().Dx() != 30 {
+				t.Errorf("FetchCat() image = %v, want the 30x20 PNG", cat.Image)
+			}
+			mu.Lock()
+			last := imageRequests[len(imageRequests)-1]
+			mu.Unlock()
+			if got := last.EscapedPath() + "?" + last.RawQuery; strings.TrimSuffix(got, "?") != tt.wantPath {
+				t.Errorf("image request = %s, want %s", got, tt.wantPath)
+			}
+		})
+	}
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		if _, err := client.FetchCat(context.Background(), CatOptions{Tags: []string{"sneaky"}}); err == nil || errors.Is(err, ErrNoCat) {
+			t.Errorf("FetchCat() error = %v, want an invalid ID error", err)
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		if _, err := client.FetchCat(context.Background(), CatOptions{Filter: "sparkle"}); err == nil {
+			t.Error("FetchCat() with an unknown filter should fail")
+		}
+	})
+}
+
+var _ OptionsFetcher = (*Client)(nil)
@@ -0,0 +1,46 @@
+package cataas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"math/rand/v2"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+	"github.com/jefflinse/potato-nice-thelma/internal/imagedir"
+)
+
+// LocalClient serves random cats from a local image directory instead of
+// CATAAS.
+type LocalClient struct {
+	dir    *imagedir.Dir
+	limits decode.Limits
+}
+
+// NewLocalClient returns a LocalClient that picks from dir, decoding within
+// limits.
+func NewLocalClient(dir *imagedir.Dir, limits decode.Limits) *LocalClient {
+	return &LocalClient{dir: dir, limits: limits}
+}
+
+// FetchRandomCat decodes an image from the directory chosen with rng; a nil
+// rng uses a randomly seeded source. An animated GIF cat is returned as a
+// *decode.Animation.
+func (c *LocalClient) FetchRandomCat(ctx context.Context, rng *rand.Rand) (image.Image, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	data, err := c.dir.Read(c.dir.Pick(rng))
+	if err != nil {
+		return nil, fmt.Errorf("reading cat image: %w", err)
+	}
+	img, _, err := c.limits.DecodeAnimated(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cat image: %w", err)
+	}
+	return img, nil
+}
@@ -0,0 +1,81 @@
+package cataas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+	"github.com/jefflinse/potato-nice-thelma/internal/imagedir"
+)
+
+// Compile-time check: LocalClient must implement Fetcher.
+var _ Fetcher = (*LocalClient)(nil)
+
+func TestLocalClient_FetchRandomCat(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "tabby.png"), makePNG(t, 6, 4), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := imagedir.New(root)
+	if err != nil {
+		t.Fatalf("imagedir.New() error: %v", err)
+	}
+
+	img, err := NewLocalClient(dir, decode.Limits{}).FetchRandomCat(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("FetchRandomCat() error: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 6 || b.Dy() != 4 {
+		t.Errorf("image size = %dx%d, want 6x4", b.Dx(), b.Dy())
+	}
+
+	_, err = NewLocalClient(dir, decode.Limits{MaxPixels: 10}).FetchRandomCat(context.Background(), nil)
+	if !errors.Is(err, decode.ErrTooManyPixels) {
+		t.Errorf("FetchRandomCat() over pixel limit error = %v, want ErrTooManyPixels", err)
+	}
+}
+
+func TestLocalClient_FetchRandomCatIsSeeded(t *testing.T) {
+	t.Parallel()
+
+	// Cats of different widths tell them apart.
+	root := t.TempDir()
+	for w := 1; w <= 8; w++ {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("cat%d.png", w)), makePNG(t, w, 1), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir, err := imagedir.New(root)
+	if err != nil {
+		t.Fatalf("imagedir.New() error: %v", err)
+	}
+	c := NewLocalClient(dir, decode.Limits{})
+
+	widths := map[int]bool{}
+	for seed := range uint64(8) {
+		var first int
+		for i := range 3 {
+			img, err := c.FetchRandomCat(context.Background(), rand.New(rand.NewPCG(seed, 0)))
+			if err != nil {
+				t.Fatalf("FetchRandomCat() error: %v", err)
+			}
+			w := img.Bounds().Dx()
+			if i == 0 {
+				first = w
+			} else if w != first {
+				t.Fatalf("seed %d picked cat%d.png, then cat%d.png", seed, first, w)
+			}
+		}
+		widths[first] = true
+	}
+	if len(widths) < 2 {
+		t.Error("every seed picked the same cat")
+	}
+}
@@ -0,0 +1,103 @@
+package cataas
+
+import (
+	"fmt"
+	"image"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Filters are the image filters CATAAS can apply.
+var Filters = []string{"blur", "mono", "negate", "sepia", "paint", "pixel", "custom"}
+
+const (
+	// MaxSaysLength is the longest caption, in characters, CATAAS is asked to
+	// draw.
+	MaxSaysLength = 100
+	// MaxSize is the largest width or height a cat can be asked for.
+	MaxSize = 2000
+	// maxTags is how many tags a cat can be asked to match.
+	maxTags = 5
+)
+
+var (
+	// catTag matches the tags CATAAS uses, which also keeps them safe to put
+	// in a URL path.
+	catTag = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,49}$`)
+	// catID matches the IDs CATAAS gives its cats.
+	catID = regexp.MustCompile(`^[A-Za-z0-9]{1,64}$`)
+)
+
+// CatOptions selects and transforms the cat CATAAS returns. The zero value
+// asks for any cat, as it is.
+type CatOptions struct {
+	// ID picks one specific cat. Tags are ignored when it is set.
+	ID string
+	// Tags picks a cat that has all of them, such as "orange".
+	Tags []string
+	// Says is a caption CATAAS draws on the cat.
+	Says string
+	// Filter is one of Filters.
+	Filter string
+	// Tint is the red, green and blue adjustment of the custom filter.
+	Tint [3]uint8
+	// Width and Height resize the cat. Either may be zero to keep the aspect
+	// ratio.
+	Width, Height int
+}
+
+// Cat is a cat image with what CATAAS said about it.
+type Cat struct {
+	Image image.Image
+	ID    string
+	Tags  []string
+}
+
+// ParseTags parses a comma-separated list of tags, as in "orange,cute".
+func ParseTags(s string) ([]string, error) {
+	var tags []string
+	for tag := range strings.SplitSeq(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if !catTag.MatchString(tag) {
+			return nil, fmt.Errorf("invalid cat tag %q", tag)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// IsZero reports whether o asks for any cat, as it is.
+func (o CatOptions) IsZero() bool {
+	return o.ID == "" && len(o.Tags) == 0 && o.Says == "" && o.Filter == "" &&
+		o.Tint == [3]uint8{} && o.Width == 0 && o.Height == 0
+}
+
+// Validate returns an error describing the first invalid field of o.
+func (o CatOptions) Validate() error {
+	if o.ID != "" && !catID.MatchString(o.ID) {
+		return fmt.Errorf("invalid cat ID %q", o.ID)
+	}
+	if len(o.Tags) > maxTags {
+		return fmt.Errorf("at most %d cat tags are allowed, got %d", maxTags, len(o.Tags))
+	}
+	for _, tag := range o.Tags {
+		if !catTag.MatchString(tag) {
+			return fmt.Errorf("invalid cat tag %q", tag)
+		}
+	}
+	if n := utf8.RuneCountInString(o.Says); n > MaxSaysLength {
+		return fmt.Errorf("cat caption must be at most %d characters, got %d", MaxSaysLength, n)
+	}
+	if o.Filter != "" && !slices.Contains(Filters, o.Filter) {
+		return fmt.Errorf("cat filter must be one of %s, got %q", strings.Join(Filters, ", "), o.Filter)
+	}
+	if o.Tint != [3]uint8{} && o.Filter != "custom" {
+		return fmt.Errorf("cat tint needs the custom filter")
+	}
+	if o.Width < 0 || o.Width > MaxSize || o.Height < 0 || o.Height > MaxSize {
+		return fmt.Errorf("cat width and height must be between 1 and %d, got %dx%d", MaxSize, o.Width, o.Height)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package cataas
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	t.Parallel()
+
+	tags, err := ParseTags(" orange, cute ,black_and-white")
+	if err != nil {
+		t.Fatalf("ParseTags() error: %v", err)
+	}
+	if want := []string{"orange", "cute", "black_and-white"}; !slices.Equal(tags, want) {
+		t.Errorf("ParseTags() = %v, want %v", tags, want)
+	}
+
+	for _, s := range []string{"", "orange,", "../cat", "tag with spaces", "-lead", strings.Repeat("a", 51)} {
+		if _, err := ParseTags(s); err == nil {
+			t.Errorf("ParseTags(%q): expected error", s)
+		}
+	}
+}
+
+func TestCatOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	valid := []CatOptions{
+		{},
+		{ID: "5f1ab3C"},
+		{Tags: []string{"orange"}, Says: "hello", Filter: "mono", Width: 400},
+		{Filter: "custom", Tint: [3]uint8{255, 0, 0}, Height: MaxSize},
+		{Says: strings.Repeat("é", MaxSaysLength)},
+	}
+	for _, o := range valid {
+		if err := o.Validate(); err != nil {
+			t.Errorf("%+v: unexpected error %v", o, err)
+		}
+	}
+
+	invalid := []CatOptions{
+		{ID: "../cat"},
+		{Tags: []string{"a", "b", "c", "d", "e", "f"}},
+		{Tags: []string{"or/ange"}},
+		{Says: strings.Repeat("x", MaxSaysLength+1)},
+		{Filter: "sparkle"},
+		{Filter: "mono", Tint: [3]uint8{1, 2, 3}},
+		{Width: -1},
+		{Height: MaxSize + 1},
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("%+v: expected error", o)
+		}
+	}
+}
+
+func TestCatOptions_IsZero(t *testing.T) {
+	t.Parallel()
+
+	if !(CatOptions{}).IsZero() {
+		t.Error("zero CatOptions should be zero")
+	}
+	for _, o := range []CatOptions{{ID: "a1"}, {Tags: []string{"x"}}, {Says: "hi"}, {Filter: "blur"}, {Tint: [3]uint8{0, 0, 1}}, {Width: 1}, {Height: 1}} {
+		if o.IsZero() {
+			t.Errorf("%+v should not be zero", o)
+		}
+	}
+}
@@ -1,12 +1,90 @@
 package config
 
-import "os"
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/cataas"
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+	"github.com/jefflinse/potato-nice-thelma/internal/potato"
+)
 
 // Config holds the application configuration.
 type Config struct {
 	Port string
+	// MaxImagePixels is the largest potato or cat image, in pixels, that will
+	// be decoded. Zero means the decoder's default.
+	MaxImagePixels int64
+	// PoolDepth is how many potatoes and how many cats are kept ready ahead
+	// of requests. Zero disables the pools.
+	PoolDepth int
+	// PoolWorkers is how many fetches each pool runs at once.
+	PoolWorkers int
+	// PoolRefillInterval is the minimum time between two fetches by a pool.
+	PoolRefillInterval time.Duration
+	// RedditCacheTTL is how long a subreddit listing is cached before it is
+	// revalidated with Reddit.
+	RedditCacheTTL time.Duration
+	// RedditSubreddits are the subreddits potatoes are drawn from, weighted.
+	RedditSubreddits []potato.Subreddit
+	// RedditSort is the listing sort: hot, top, new or rising.
+	RedditSort string
+	// RedditTime is the time window of the top sort. Empty means Reddit's
+	// default.
+	RedditTime string
+	// RedditLimit is how many posts each listing asks for.
+	RedditLimit int
+	// PotatoDir, when set, replaces Reddit with a local directory of potato
+	// images.
+	PotatoDir string
+	// CataasURL is the base URL of the CATAAS instance cats come from.
+	CataasURL string
+	// CatDir, when set, replaces CATAAS with a local directory of cat images.
+	CatDir string
+	// ImageDirRecursive makes the image directories include subdirectories.
+	ImageDirRecursive bool
+	// ImageDirFormats are the formats picked from the image directories.
+	ImageDirFormats []string
+	// PotatoSources, when set, spreads potato searches over several
+	// sources by weight, failing over between them.
+	PotatoSources []PotatoSource
+	// PotatoFeedURL is the JSON feed of the "feed" potato source.
+	PotatoFeedURL string
+	// SourceEjectAfter is how many consecutive failures take a potato source
+	// out of rotation.
+	SourceEjectAfter int
+	// SourceEjectFor is how long a failing potato source stays out of
+	// rotation.
+	SourceEjectFor time.Duration
+	// HTTPMaxRetries is how many times an upstream GET answered with 5xx or
+	// 429 is retried. Zero disables retries.
+	HTTPMaxRetries int
+	// HTTPBreakerThreshold is how many consecutive failures open the circuit
+	// breaker of an upstream host.
+	HTTPBreakerThreshold int
+	// HTTPBreakerCooldown is how long an open circuit fails requests to its
+	// host before letting one through to check on it.
+	HTTPBreakerCooldown time.Duration
+}
+
+// PotatoSource is one entry of POTATO_SOURCES.
+type PotatoSource struct {
+	// Name is one of PotatoSourceNames.
+	Name string
+	// Weight is the source's share of searches. Zero makes it a spare that
+	// is only searched once the others have failed.
+	Weight float64
 }
 
+// PotatoSourceNames are the sources POTATO_SOURCES can name.
+var PotatoSourceNames = []string{"reddit", "local", "feed", "fallback"}
+
 // Load reads configuration from environment variables and returns a populated
 // Config. It returns an error if any required variables are missing.
 func Load() (*Config, error) {
@@ -15,7 +93,228 @@ func Load() (*Config, error) {
 		port = "8080"
 	}
 
+	var maxPixels int64
+	if v := os.Getenv("MAX_IMAGE_PIXELS"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("MAX_IMAGE_PIXELS must be a positive integer, got %q", v)
+		}
+		maxPixels = parsed
+	}
+
+	poolDepth, err := intEnv("POOL_DEPTH", 4)
+	if err != nil {
+		return nil, err
+	}
+	poolWorkers, err := intEnv("POOL_WORKERS", 2)
+	if err != nil {
+		return nil, err
+	}
+	if poolWorkers == 0 {
+		return nil, fmt.Errorf("POOL_WORKERS must be at least 1")
+	}
+
+	refill, err := durationEnv("POOL_REFILL_INTERVAL", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	redditTTL, err := durationEnv("REDDIT_CACHE_TTL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if redditTTL == 0 {
+		return nil, fmt.Errorf("REDDIT_CACHE_TTL must be positive")
+	}
+
+	subs := potato.DefaultSubreddits
+	if v := os.Getenv("REDDIT_SUBREDDITS"); v != "" {
+		subs, err = potato.ParseSubreddits(v)
+		if err != nil {
+			return nil, fmt.Errorf("REDDIT_SUBREDDITS: %w", err)
+		}
+	}
+	sort := os.Getenv("REDDIT_SORT")
+	if sort == "" {
+		sort = potato.DefaultSort
+	}
+	redditLimit, err := intEnv("REDDIT_LIMIT", potato.DefaultLimit)
+	if err != nil {
+		return nil, err
+	}
+	if redditLimit == 0 {
+		return nil, fmt.Errorf("REDDIT_LIMIT must be at least 1")
+	}
+	listing := potato.ListingOptions{Subreddits: subs, Sort: sort, Time: os.Getenv("REDDIT_TIME"), Limit: redditLimit}
+	if err := listing.Validate(); err != nil {
+		return nil, fmt.Errorf("reddit listing: %w", err)
+	}
+
+	recursive := false
+	if v := os.Getenv("IMAGE_DIR_RECURSIVE"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("IMAGE_DIR_RECURSIVE must be a boolean, got %q", v)
+		}
+		recursive = parsed
+	}
+
+	formats := decode.DefaultFormats
+	if v := os.Getenv("IMAGE_DIR_FORMATS"); v != "" {
+		formats = nil
+		for f := range strings.SplitSeq(v, ",") {
+			f = strings.ToLower(strings.TrimSpace(f))
+			if !slices.Contains(decode.DefaultFormats, f) {
+				return nil, fmt.Errorf("IMAGE_DIR_FORMATS: unsupported format %q (supported: %s)", f, strings.Join(decode.DefaultFormats, ", "))
+			}
+			formats = append(formats, f)
+		}
+	}
+
+	cataasURL := os.Getenv("CATAAS_URL")
+	if cataasURL == "" {
+		cataasURL = cataas.DefaultBaseURL
+	}
+	if u, err := url.Parse(cataasURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("CATAAS_URL must be an http or https URL, got %q", cataasURL)
+	}
+
+	feedURL := os.Getenv("POTATO_FEED_URL")
+	if feedURL != "" {
+		if u, err := url.Parse(feedURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("POTATO_FEED_URL must be an http or https URL, got %q", feedURL)
+		}
+	}
+	var sources []PotatoSource
+	if v := os.Getenv("POTATO_SOURCES"); v != "" {
+		sources, err = parsePotatoSources(v)
+		if err != nil {
+			return nil, fmt.Errorf("POTATO_SOURCES: %w", err)
+		}
+		for _, src := range sources {
+			if src.Name == "local" && os.Getenv("POTATO_DIR") == "" {
+				return nil, fmt.Errorf("POTATO_SOURCES: the local source needs POTATO_DIR")
+			}
+			if src.Name == "feed" && feedURL == "" {
+				return nil, fmt.Errorf("POTATO_SOURCES: the feed source needs POTATO_FEED_URL")
+			}
+		}
+	}
+	ejectAfter, err := intEnv("SOURCE_EJECT_AFTER", 3)
+	if err != nil {
+		return nil, err
+	}
+	if ejectAfter == 0 {
+		return nil, fmt.Errorf("SOURCE_EJECT_AFTER must be at least 1")
+	}
+	ejectFor, err := durationEnv("SOURCE_EJECT_FOR", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if ejectFor == 0 {
+		return nil, fmt.Errorf("SOURCE_EJECT_FOR must be positive")
+	}
+
+	maxRetries, err := intEnv("HTTP_MAX_RETRIES", 2)
+	if err != nil {
+		return nil, err
+	}
+	breakerThreshold, err := intEnv("HTTP_BREAKER_THRESHOLD", 5)
+	if err != nil {
+		return nil, err
+	}
+	if breakerThreshold == 0 {
+		return nil, fmt.Errorf("HTTP_BREAKER_THRESHOLD must be at least 1")
+	}
+	breakerCooldown, err := durationEnv("HTTP_BREAKER_COOLDOWN", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if breakerCooldown == 0 {
+		return nil, fmt.Errorf("HTTP_BREAKER_COOLDOWN must be positive")
+	}
+
 	return &Config{
-		Port: port,
+		Port:                 port,
+		MaxImagePixels:       maxPixels,
+		PoolDepth:            poolDepth,
+		PoolWorkers:          poolWorkers,
+		PoolRefillInterval:   refill,
+		RedditCacheTTL:       redditTTL,
+		RedditSubreddits:     listing.Subreddits,
+		RedditSort:           listing.Sort,
+		RedditTime:           listing.Time,
+		RedditLimit:          listing.Limit,
+		PotatoDir:            os.Getenv("POTATO_DIR"),
+		CataasURL:            cataasURL,
+		CatDir:               os.Getenv("CAT_DIR"),
+		ImageDirRecursive:    recursive,
+		ImageDirFormats:      formats,
+		PotatoSources:        sources,
+		PotatoFeedURL:        feedURL,
+		SourceEjectAfter:     ejectAfter,
+		SourceEjectFor:       ejectFor,
+		HTTPMaxRetries:       maxRetries,
+		HTTPBreakerThreshold: breakerThreshold,
+		HTTPBreakerCooldown:  breakerCooldown,
 	}, nil
 }
+
+// parsePotatoSources parses a comma-separated list of source names, each
+// optionally followed by ":weight", as in "reddit:3,local,fallback:0". The
+// weight defaults to 1, and at least one source needs a positive weight.
+func parsePotatoSources(v string) ([]PotatoSource, error) {
+	var sources []PotatoSource
+	positive := false
+	for field := range strings.SplitSeq(v, ",") {
+		name, weight, hasWeight := strings.Cut(strings.TrimSpace(field), ":")
+		name = strings.ToLower(name)
+		if !slices.Contains(PotatoSourceNames, name) {
+			return nil, fmt.Errorf("unknown source %q (supported: %s)", name, strings.Join(PotatoSourceNames, ", "))
+		}
+		if slices.ContainsFunc(sources, func(s PotatoSource) bool { return s.Name == name }) {
+			return nil, fmt.Errorf("source %q listed twice", name)
+		}
+		src := PotatoSource{Name: name, Weight: 1}
+		if hasWeight {
+			w, err := strconv.ParseFloat(weight, 64)
+			if err != nil || w < 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+				return nil, fmt.Errorf("source %q: weight must be a non-negative finite number, got %q", name, weight)
+			}
+			src.Weight = w
+		}
+		positive = positive || src.Weight > 0
+		sources = append(sources, src)
+	}
+	if !positive {
+		return nil, fmt.Errorf("at least one source needs a positive weight")
+	}
+	return sources, nil
+}
+
+// intEnv reads a non-negative integer from the environment variable key,
+// returning def if it is unset.
+func intEnv(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %q", key, v)
+	}
+	return n, nil
+}
+
+// durationEnv reads a non-negative duration from the environment variable key,
+// returning def if it is unset.
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration, got %q", key, v)
+	}
+	return d, nil
+}
@@ -2,7 +2,12 @@ package config
 
 import (
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/potato"
 )
 
 // setEnv is a test helper that sets an environment variable and registers
@@ -35,6 +40,27 @@ func unsetEnv(t *testing.T, key string) {
 
 func TestLoad_NoEnvVars(t *testing.T) {
 	unsetEnv(t, "PORT")
+	unsetEnv(t, "MAX_IMAGE_PIXELS")
+	unsetEnv(t, "POOL_DEPTH")
+	unsetEnv(t, "POOL_WORKERS")
+	unsetEnv(t, "POOL_REFILL_INTERVAL")
+	unsetEnv(t, "REDDIT_CACHE_TTL")
+	unsetEnv(t, "REDDIT_SUBREDDITS")
+	unsetEnv(t, "REDDIT_SORT")
+	unsetEnv(t, "REDDIT_TIME")
+	unsetEnv(t, "REDDIT_LIMIT")
+	unsetEnv(t, "POTATO_DIR")
+	unsetEnv(t, "CATAAS_URL")
+	unsetEnv(t, "CAT_DIR")
+	unsetEnv(t, "IMAGE_DIR_RECURSIVE")
+	unsetEnv(t, "IMAGE_DIR_FORMATS")
+	unsetEnv(t, "POTATO_SOURCES")
+	unsetEnv(t, "POTATO_FEED_URL")
+	unsetEnv(t, "SOURCE_EJECT_AFTER")
+	unsetEnv(t, "SOURCE_EJECT_FOR")
+	unsetEnv(t, "HTTP_MAX_RETRIES")
+	unsetEnv(t, "HTTP_BREAKER_THRESHOLD")
+	unsetEnv(t, "HTTP_BREAKER_COOLDOWN")
 
 	cfg, err := Load()
 	if err != nil {
@@ -44,6 +70,36 @@ func TestLoad_NoEnvVars(t *testing.T) {
 	if cfg.Port != "8080" {
 		t.Errorf("Port = %q, want %q", cfg.Port, "8080")
 	}
+	if cfg.MaxImagePixels != 0 {
+		t.Errorf("MaxImagePixels = %d, want 0", cfg.MaxImagePixels)
+	}
+	if cfg.PoolDepth != 4 || cfg.PoolWorkers != 2 || cfg.PoolRefillInterval != time.Second {
+		t.Errorf("pool = %d/%d/%v, want 4/2/1s", cfg.PoolDepth, cfg.PoolWorkers, cfg.PoolRefillInterval)
+	}
+	if cfg.RedditCacheTTL != 5*time.Minute {
+		t.Errorf("RedditCacheTTL = %v, want 5m", cfg.RedditCacheTTL)
+	}
+	if len(cfg.RedditSubreddits) != 3 || cfg.RedditSort != "hot" || cfg.RedditTime != "" || cfg.RedditLimit != 50 {
+		t.Errorf("reddit listing = %v/%q/%q/%d, want the defaults", cfg.RedditSubreddits, cfg.RedditSort, cfg.RedditTime, cfg.RedditLimit)
+	}
+	if cfg.PotatoDir != "" || cfg.CatDir != "" || cfg.ImageDirRecursive {
+		t.Errorf("image dirs = %q/%q/%v, want unset", cfg.PotatoDir, cfg.CatDir, cfg.ImageDirRecursive)
+	}
+	if len(cfg.ImageDirFormats) != 4 {
+		t.Errorf("ImageDirFormats = %v, want all four formats", cfg.ImageDirFormats)
+	}
+	if cfg.PotatoSources != nil || cfg.PotatoFeedURL != "" {
+		t.Errorf("potato sources = %v/%q, want unset", cfg.PotatoSources, cfg.PotatoFeedURL)
+	}
+	if cfg.SourceEjectAfter != 3 || cfg.SourceEjectFor != 30*time.Second {
+		t.Errorf("ejection = %d/%v, want 3/30s", cfg.SourceEjectAfter, cfg.SourceEjectFor)
+	}
+	if cfg.CataasURL != "https://cataas.com" {
+		t.Errorf("CataasURL = %q, want %q", cfg.CataasURL, "https://cataas.com")
+	}
+	if cfg.HTTPMaxRetries != 2 || cfg.HTTPBreakerThreshold != 5 || cfg.HTTPBreakerCooldown != 30*time.Second {
+		t.Errorf("resilience = %d/%d/%v, want 2/5/30s", cfg.HTTPMaxRetries, cfg.HTTPBreakerThreshold, cfg.HTTPBreakerCooldown)
+	}
 }
 
 func TestLoad_CustomPort(t *testing.T) {
@@ -58,3 +114,261 @@ func TestLoad_CustomPort(t *testing.T) {
 		t.Errorf("Port = %q, want %q", cfg.Port, "3000")
 	}
 }
+
+func TestLoad_MaxImagePixels(t *testing.T) {
+	setEnv(t, "MAX_IMAGE_PIXELS", "1000000")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.MaxImagePixels != 1000000 {
+		t.Errorf("MaxImagePixels = %d, want %d", cfg.MaxImagePixels, 1000000)
+	}
+}
+
+func TestLoad_InvalidMaxImagePixels(t *testing.T) {
+	for _, v := range []string{"lots", "0", "-5"} {
+		setEnv(t, "MAX_IMAGE_PIXELS", v)
+
+		if _, err := Load(); err == nil {
+			t.Errorf("MAX_IMAGE_PIXELS=%q: expected error", v)
+		}
+	}
+}
+
+func TestLoad_Pool(t *testing.T) {
+	setEnv(t, "POOL_DEPTH", "0")
+	setEnv(t, "POOL_WORKERS", "5")
+	setEnv(t, "POOL_REFILL_INTERVAL", "250ms")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.PoolDepth != 0 {
+		t.Errorf("PoolDepth = %d, want 0", cfg.PoolDepth)
+	}
+	if cfg.PoolWorkers != 5 {
+		t.Errorf("PoolWorkers = %d, want 5", cfg.PoolWorkers)
+	}
+	if cfg.PoolRefillInterval != 250*time.Millisecond {
+		t.Errorf("PoolRefillInterval = %v, want 250ms", cfg.PoolRefillInterval)
+	}
+}
+
+func TestLoad_InvalidPool(t *testing.T) {
+	for key, v := range map[string]string{
+		"POOL_DEPTH":           "-1",
+		"POOL_WORKERS":         "0",
+		"POOL_REFILL_INTERVAL": "soon",
+	} {
+		t.Run(key, func(t *testing.T) {
+			setEnv(t, key, v)
+
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=%q: expected error", key, v)
+			}
+		})
+	}
+}
+
+func TestLoad_RedditCacheTTL(t *testing.T) {
+	setEnv(t, "REDDIT_CACHE_TTL", "90s")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RedditCacheTTL != 90*time.Second {
+		t.Errorf("RedditCacheTTL = %v, want 90s", cfg.RedditCacheTTL)
+	}
+}
+
+func TestLoad_InvalidRedditCacheTTL(t *testing.T) {
+	for _, v := range []string{"0s", "-1m", "forever"} {
+		t.Run(v, func(t *testing.T) {
+			setEnv(t, "REDDIT_CACHE_TTL", v)
+
+			if _, err := Load(); err == nil {
+				t.Errorf("REDDIT_CACHE_TTL=%q: expected error", v)
+			}
+		})
+	}
+}
+
+func TestLoad_RedditListing(t *testing.T) {
+	setEnv(t, "REDDIT_SUBREDDITS", "cats:3,potato")
+	setEnv(t, "REDDIT_SORT", "top")
+	setEnv(t, "REDDIT_TIME", "week")
+	setEnv(t, "REDDIT_LIMIT", "25")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []potato.Subreddit{{Name: "cats", Weight: 3}, {Name: "potato", Weight: 1}}
+	if !slices.Equal(cfg.RedditSubreddits, want) {
+		t.Errorf("RedditSubreddits = %v, want %v", cfg.RedditSubreddits, want)
+	}
+	if cfg.RedditSort != "top" || cfg.RedditTime != "week" || cfg.RedditLimit != 25 {
+		t.Errorf("reddit listing = %q/%q/%d, want top/week/25", cfg.RedditSort, cfg.RedditTime, cfg.RedditLimit)
+	}
+}
+
+func TestLoad_InvalidRedditListing(t *testing.T) {
+	for key, v := range map[string]string{
+		"REDDIT_SUBREDDITS": "cats:none",
+		"REDDIT_SORT":       "best",
+		"REDDIT_TIME":       "decade",
+		"REDDIT_LIMIT":      "0",
+	} {
+		t.Run(key, func(t *testing.T) {
+			setEnv(t, key, v)
+
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=%q: expected error", key, v)
+			}
+		})
+	}
+}
+
+func TestLoad_ImageDirs(t *testing.T) {
+	setEnv(t, "POTATO_DIR", "/srv/potatoes")
+	setEnv(t, "CAT_DIR", "/srv/cats")
+	setEnv(t, "IMAGE_DIR_RECURSIVE", "true")
+	setEnv(t, "IMAGE_DIR_FORMATS", "PNG, jpeg")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.PotatoDir != "/srv/potatoes" || cfg.CatDir != "/srv/cats" {
+		t.Errorf("dirs = %q/%q, want /srv/potatoes and /srv/cats", cfg.PotatoDir, cfg.CatDir)
+	}
+	if !cfg.ImageDirRecursive {
+		t.Error("ImageDirRecursive = false, want true")
+	}
+	if got := strings.Join(cfg.ImageDirFormats, ","); got != "png,jpeg" {
+		t.Errorf("ImageDirFormats = %q, want png,jpeg", got)
+	}
+}
+
+func TestLoad_InvalidImageDirs(t *testing.T) {
+	for key, v := range map[string]string{
+		"IMAGE_DIR_RECURSIVE": "sometimes",
+		"IMAGE_DIR_FORMATS":   "png,bmp",
+	} {
+		t.Run(key, func(t *testing.T) {
+			setEnv(t, key, v)
+
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=%q: expected error", key, v)
+			}
+		})
+	}
+}
+
+func TestLoad_PotatoSources(t *testing.T) {
+	setEnv(t, "POTATO_SOURCES", "Reddit:3, local, feed:0.5, fallback:0")
+	setEnv(t, "POTATO_DIR", "/srv/potatoes")
+	setEnv(t, "POTATO_FEED_URL", "https://example.com/potatoes.json")
+	setEnv(t, "SOURCE_EJECT_AFTER", "5")
+	setEnv(t, "SOURCE_EJECT_FOR", "1m")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []PotatoSource{{"reddit", 3}, {"local", 1}, {"feed", 0.5}, {"fallback", 0}}
+	if !slices.Equal(cfg.PotatoSources, want) {
+		t.Errorf("PotatoSources = %v, want %v", cfg.PotatoSources, want)
+	}
+	if cfg.PotatoFeedURL != "https://example.com/potatoes.json" {
+		t.Errorf("PotatoFeedURL = %q", cfg.PotatoFeedURL)
+	}
+	if cfg.SourceEjectAfter != 5 || cfg.SourceEjectFor != time.Minute {
+		t.Errorf("ejection = %d/%v, want 5/1m", cfg.SourceEjectAfter, cfg.SourceEjectFor)
+	}
+}
+
+func TestLoad_InvalidPotatoSources(t *testing.T) {
+	tests := map[string]map[string]string{
+		"unknown source":    {"POTATO_SOURCES": "reddit,bing"},
+		"duplicate":         {"POTATO_SOURCES": "reddit,reddit:2"},
+		"negative weight":   {"POTATO_SOURCES": "reddit:-1"},
+		"NaN weight":        {"POTATO_SOURCES": "reddit:NaN"},
+		"infinite weight":   {"POTATO_SOURCES": "reddit:+Inf,fallback"},
+		"no weight":         {"POTATO_SOURCES": "reddit:0,fallback:0"},
+		"local without dir": {"POTATO_SOURCES": "local"},
+		"feed without url":  {"POTATO_SOURCES": "feed"},
+		"bad feed url":      {"POTATO_FEED_URL": "file:///etc/potatoes.json"},
+		"eject after zero":  {"SOURCE_EJECT_AFTER": "0"},
+		"eject for zero":    {"SOURCE_EJECT_FOR": "0s"},
+	}
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			unsetEnv(t, "POTATO_DIR")
+			unsetEnv(t, "POTATO_FEED_URL")
+			for k, v := range env {
+				setEnv(t, k, v)
+			}
+
+			if _, err := Load(); err == nil {
+				t.Errorf("%v: expected error", env)
+			}
+		})
+	}
+}
+
+func TestLoad_HTTPResilience(t *testing.T) {
+	setEnv(t, "HTTP_MAX_RETRIES", "0")
+	setEnv(t, "HTTP_BREAKER_THRESHOLD", "10")
+	setEnv(t, "HTTP_BREAKER_COOLDOWN", "1m")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.HTTPMaxRetries != 0 || cfg.HTTPBreakerThreshold != 10 || cfg.HTTPBreakerCooldown != time.Minute {
+		t.Errorf("resilience = %d/%d/%v, want 0/10/1m", cfg.HTTPMaxRetries, cfg.HTTPBreakerThreshold, cfg.HTTPBreakerCooldown)
+	}
+}
+
+func TestLoad_InvalidHTTPResilience(t *testing.T) {
+	tests := map[string]string{
+		"HTTP_MAX_RETRIES":       "-1",
+		"HTTP_BREAKER_THRESHOLD": "0",
+		"HTTP_BREAKER_COOLDOWN":  "0s",
+	}
+	for key, v := range tests {
+		t.Run(key, func(t *testing.T) {
+			setEnv(t, key, v)
+
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=%q: expected error", key, v)
+			}
+		})
+	}
+}
+
+func TestLoad_CataasURL(t *testing.T) {
+	setEnv(t, "CATAAS_URL", "http://localhost:8081")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.CataasURL != "http://localhost:8081" {
+		t.Errorf("CataasURL = %q, want %q", cfg.CataasURL, "http://localhost:8081")
+	}
+
+	for _, v := range []string{"cataas.com", "ftp://cataas.com", "http://"} {
+		setEnv(t, "CATAAS_URL", v)
+		if _, err := Load(); err == nil {
+			t.Errorf("CATAAS_URL=%q: expected error", v)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package decode decodes untrusted images without letting a hostile input
+// exhaust memory.
+package decode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"slices"
+
+	// Register the decoders for every format in DefaultFormats.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	// ErrTooLarge is returned when the encoded image exceeds MaxBytes.
+	ErrTooLarge = errors.New("encoded image too large")
+	// ErrTooManyPixels is returned when the image header declares more than
+	// MaxPixels pixels.
+	ErrTooManyPixels = errors.New("image dimensions too large")
+	// ErrFormat is returned for formats that are not in Formats.
+	ErrFormat = errors.New("image format not allowed")
+)
+
+const (
+	// DefaultMaxPixels allows images up to about 25 megapixels, comfortably
+	// above what phone cameras produce.
+	DefaultMaxPixels = 25_000_000
+	// DefaultMaxBytes is the default limit on the encoded size.
+	DefaultMaxBytes = 20 << 20
+)
+
+// DefaultFormats are the formats accepted by default, as named by the image
+// package.
+var DefaultFormats = []string{"png", "jpeg", "gif", "webp"}
+
+// Limits bounds what Decode accepts. Zero fields take their defaults.
+type Limits struct {
+	// MaxPixels is the largest width × height accepted.
+	MaxPixels int64
+	// MaxBytes is the most encoded data read from the input.
+	MaxBytes int64
+	// Formats lists the accepted formats.
+	Formats []string
+	// MaxFrames is the most frames DecodeAnimated keeps.
+	MaxFrames int
+}
+
+// withDefaults fills in zero fields.
+func (l Limits) withDefaults() Limits {
+	if l.MaxPixels <= 0 {
+		l.MaxPixels = DefaultMaxPixels
+	}
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultMaxBytes
+	}
+	if len(l.Formats) == 0 {
+		l.Formats = DefaultFormats
+	}
+	if l.MaxFrames <= 0 {
+		l.MaxFrames = DefaultMaxFrames
+	}
+	return l
+}
+
+// Decode decodes an image from r within the limits. The dimensions and
+// format are checked from the header, before any pixel memory is allocated.
+// It returns the format name like image.Decode.
+func (l Limits) Decode(r io.Reader) (image.Image, string, error) {
+	l = l.withDefaults()
+
+	data, err := io.ReadAll(io.LimitReader(r, l.MaxBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading image: %w", err)
+	}
+	if int64(len(data)) > l.MaxBytes {
+		return nil, "", fmt.Errorf("%w: exceeds %d bytes", ErrTooLarge, l.MaxBytes)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading image header: %w", err)
+	}
+	if !slices.Contains(l.Formats, format) {
+		return nil, "", fmt.Errorf("%w: %s", ErrFormat, format)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, "", fmt.Errorf("invalid image dimensions %dx%d", cfg.Width, cfg.Height)
+	}
+	if err := l.checkSize(cfg.Width, cfg.Height); err != nil {
+		return nil, "", err
+	}
+	if format == "webp" {
+		w, h, err := webpBitstreamSize(data)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := l.checkSize(w, h); err != nil {
+			return nil, "", err
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding %s image: %w", format, err)
+	}
+	return img, format, nil
+}
+
+// checkSize rejects dimensions above MaxPixels.
+func (l Limits) checkSize(width, height int) error {
+	if pixels := int64(width) * int64(height); pixels > l.MaxPixels {
+		return fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrTooManyPixels, width, height, l.MaxPixels)
+	}
+	return nil
+}
+
+// Decode decodes an image from r within the default limits.
+func Decode(r io.Reader) (image.Image, string, error) {
+	return Limits{}.Decode(r)
+}
@@ -0,0 +1,185 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encoded returns img encoded with enc.
+func encoded(t *testing.T, enc func(*bytes.Buffer, image.Image) error, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := enc(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("encoding test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(b *bytes.Buffer, img image.Image) error  { return png.Encode(b, img) }
+func encodeJPEG(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) }
+func encodeGIF(b *bytes.Buffer, img image.Image) error  { return gif.Encode(b, img, nil) }
+
+// pngBomb is a PNG whose header declares width x height but which carries no
+// pixel data at all.
+func pngBomb(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // RGBA
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	for _, c := range []struct {
+		typ  string
+		data []byte
+	}{{"IHDR", ihdr}, {"IEND", nil}} {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(c.data)))
+		body := append([]byte(c.typ), c.data...)
+		data = append(data, body...)
+		data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(body))
+	}
+	return data
+}
+
+// gifBomb is a GIF whose logical screen is width x height.
+func gifBomb(width, height uint16) []byte {
+	data := []byte("GIF89a")
+	data = binary.LittleEndian.AppendUint16(data, width)
+	data = binary.LittleEndian.AppendUint16(data, height)
+	return append(data, 0, 0, 0, ';')
+}
+
+// jpegBomb is a real JPEG with the frame header patched to claim
+// width x height.
+func jpegBomb(t *testing.T, width, height uint16) []byte {
+	t.Helper()
+	data := encoded(t, encodeJPEG, 8, 8)
+	sof := bytes.Index(data, []byte{0xff, 0xc0})
+	if sof < 0 {
+		t.Fatal("test JPEG has no SOF0 marker")
+	}
+	// Marker, length, precision, then height and width.
+	binary.BigEndian.PutUint16(data[sof+5:], height)
+	binary.BigEndian.PutUint16(data[sof+7:], width)
+	return data
+}
+
+// webpChunk returns a RIFF chunk.
+func webpChunk(fourCC string, payload []byte) []byte {
+	c := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	c = append(c, payload...)
+	if len(payload)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+// webpFile wraps chunks in a RIFF WEBP container.
+func webpFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+// vp8lHeader is the start of a VP8L bitstream declaring width x height.
+func vp8lHeader(width, height uint32) []byte {
+	bits := (width - 1) | (height-1)<<14
+	return append([]byte{0x2f}, binary.LittleEndian.AppendUint32(nil, bits)...)
+}
+
+func TestLimits_Decode(t *testing.T) {
+	t.Parallel()
+
+	// An extended WebP whose canvas claims 1x1 while its bitstream claims the
+	// largest size the format allows.
+	vp8x := make([]byte, 10)
+	hiddenWebP := webpFile(webpChunk("VP8X", vp8x), webpChunk("VP8L", vp8lHeader(16384, 16384)))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		limits  Limits
+		wantErr error
+		wantAny bool // any error will do
+	}{
+		{name: "png", data: encoded(t, encodePNG, 10, 10)},
+		{name: "jpeg", data: encoded(t, encodeJPEG, 10, 10)},
+		{name: "gif", data: encoded(t, encodeGIF, 10, 10)},
+		{name: "at pixel limit", data: encoded(t, encodePNG, 10, 10), limits: Limits{MaxPixels: 100}},
+		{name: "over pixel limit", data: encoded(t, encodePNG, 10, 10), limits: Limits{MaxPixels: 99}, wantErr: ErrTooManyPixels},
+		{name: "png header bomb", data: pngBomb(100_000, 100_000), wantErr: ErrTooManyPixels},
+		{name: "png overflowing dimensions", data: pngBomb(1<<31-1, 1<<31-1), wantAny: true},
+		{name: "gif header bomb", data: gifBomb(65535, 65535), wantErr: ErrTooManyPixels},
+		{name: "jpeg header bomb", data: jpegBomb(t, 60000, 60000), wantErr: ErrTooManyPixels},
+		{name: "webp bitstream bomb", data: webpFile(webpChunk("VP8L", vp8lHeader(16384, 16384))), wantErr: ErrTooManyPixels},
+		{name: "webp bomb behind small canvas", data: hiddenWebP, wantErr: ErrTooManyPixels},
+		{name: "over byte limit", data: encoded(t, encodePNG, 10, 10), limits: Limits{MaxBytes: 16}, wantErr: ErrTooLarge},
+		{name: "format not allowed", data: encoded(t, encodeGIF, 10, 10), limits: Limits{Formats: []string{"png", "jpeg"}}, wantErr: ErrFormat},
+		{name: "garbage", data: []byte("this is not an image at all"), wantAny: true},
+		{name: "empty", data: nil, wantAny: true},
+		{name: "truncated png", data: encoded(t, encodePNG, 64, 64)[:60], wantAny: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			img, _, err := tt.limits.Decode(bytes.NewReader(tt.data))
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantAny:
+				if err == nil {
+					t.Fatal("Decode() should fail")
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Decode() error: %v", err)
+				}
+				if img.Bounds().Dx() != 10 {
+					t.Errorf("decoded width = %d, want 10", img.Bounds().Dx())
+				}
+			}
+		})
+	}
+}
+
+func TestWebPBitstreamSize(t *testing.T) {
+	t.Parallel()
+
+	vp8 := []byte{0, 0, 0, 0x9d, 0x01, 0x2a, 0x40, 0x01, 0xf0, 0x00} // 320x240
+	tests := []struct {
+		name         string
+		data         []byte
+		wantW, wantH int
+		wantErr      bool
+	}{
+		{"vp8l", webpFile(webpChunk("VP8L", vp8lHeader(37, 29))), 37, 29, false},
+		{"vp8", webpFile(webpChunk("VP8 ", vp8)), 320, 240, false},
+		{"after other chunks", webpFile(webpChunk("VP8X", make([]byte, 10)), webpChunk("ICCP", []byte{1, 2, 3}), webpChunk("VP8L", vp8lHeader(5, 6))), 5, 6, false},
+		{"no bitstream", webpFile(webpChunk("VP8X", make([]byte, 10))), 0, 0, true},
+		{"chunk overruns file", webpFile([]byte("VP8L\xff\xff\xff\x00\x2f")), 0, 0, true},
+		{"bad signature", webpFile(webpChunk("VP8L", []byte{0, 0, 0, 0, 0})), 0, 0, true},
+		{"not riff", []byte("GIF89a"), 0, 0, true},
+	}
+	for _, tt := range tests {
+		w, h, err := webpBitstreamSize(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("%s: size = %dx%d, want %dx%d", tt.name, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
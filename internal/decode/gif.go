@@ -0,0 +1,176 @@
+package decode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"iter"
+)
+
+const (
+	// DefaultMaxFrames is the default limit on the frames of an animation.
+	DefaultMaxFrames = 200
+	// minDelay is the shortest frame delay, in hundredths of a second, taken
+	// at face value. Browsers show frames with shorter delays for 10, and
+	// animated GIFs are made to look right in browsers.
+	minDelay = 2
+	// browserDelay is the delay browsers use for frames faster than
+	// minDelay.
+	browserDelay = 10
+)
+
+// Animation is a decoded animated GIF. As an image.Image it is its first
+// frame, so code that doesn't know about animation sees a still image.
+type Animation struct {
+	*image.RGBA
+	g *gif.GIF
+}
+
+// Len returns the number of frames.
+func (a *Animation) Len() int { return len(a.g.Image) }
+
+// Delays returns how long each frame is shown, in hundredths of a second,
+// with delays too short for browsers to honor replaced by what they show
+// instead.
+func (a *Animation) Delays() []int {
+	delays := make([]int, len(a.g.Image))
+	for i := range delays {
+		delays[i] = browserDelay
+		if i < len(a.g.Delay) && a.g.Delay[i] >= minDelay {
+			delays[i] = a.g.Delay[i]
+		}
+	}
+	return delays
+}
+
+// Frames yields each frame composited onto the previous ones as its disposal
+// method requires, with its index. The yielded image is reused between
+// frames; copy it to keep it.
+func (a *Animation) Frames() iter.Seq2[int, *image.RGBA] {
+	return func(yield func(int, *image.RGBA) bool) {
+		canvas := image.NewRGBA(a.Bounds())
+		var previous *image.RGBA
+		for i, frame := range a.g.Image {
+			disposal := byte(gif.DisposalNone)
+			if i < len(a.g.Disposal) {
+				disposal = a.g.Disposal[i]
+			}
+			if disposal == gif.DisposalPrevious {
+				if previous == nil {
+					previous = image.NewRGBA(canvas.Bounds())
+				}
+				copy(previous.Pix, canvas.Pix)
+			}
+
+			draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+			if !yield(i, canvas) {
+				return
+			}
+
+			switch disposal {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				copy(canvas.Pix, previous.Pix)
+			}
+		}
+	}
+}
+
+// DecodeAnimated is Decode, except that an animated GIF is returned as an
+// *Animation. An animation with more than MaxFrames frames, or whose frames
+// add up to more than MaxPixels pixels, is returned as its first frame
+// instead.
+func (l Limits) DecodeAnimated(r io.Reader) (image.Image, string, error) {
+	l = l.withDefaults()
+
+	data, err := io.ReadAll(io.LimitReader(r, l.MaxBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading image: %w", err)
+	}
+	img, format, err := l.Decode(bytes.NewReader(data))
+	if err != nil || format != "gif" {
+		return img, format, err
+	}
+
+	frames, err := gifFrameCount(data)
+	if err != nil || frames < 2 || frames > l.MaxFrames {
+		return img, format, nil
+	}
+	b := img.Bounds()
+	if int64(frames)*int64(b.Dx())*int64(b.Dy()) > l.MaxPixels {
+		return img, format, nil
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		// The first frame decoded; a broken later frame only costs the
+		// motion.
+		return img, format, nil
+	}
+	a := &Animation{g: g}
+	a.RGBA = image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	for _, first := range a.Frames() {
+		copy(a.Pix, first.Pix)
+		break
+	}
+	return a, format, nil
+}
+
+// gifFrameCount counts the frames of a GIF by walking its blocks, without
+// decompressing any of them.
+func gifFrameCount(data []byte) (int, error) {
+	errInvalid := errors.New("gif: malformed stream")
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return 0, errInvalid
+	}
+	p := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		p += 3 << (flags&0x07 + 1)
+	}
+
+	// skipSubBlocks returns the position after the sub-blocks at p.
+	skipSubBlocks := func(p int) (int, bool) {
+		for p < len(data) {
+			n := int(data[p])
+			p++
+			if n == 0 {
+				return p, true
+			}
+			p += n
+		}
+		return p, false
+	}
+
+	frames := 0
+	for p < len(data) {
+		var ok bool
+		switch data[p] {
+		case 0x21: // extension: label, then sub-blocks
+			p, ok = skipSubBlocks(p + 2)
+		case 0x2c: // image descriptor, color table, LZW code size, sub-blocks
+			if p+10 > len(data) {
+				return 0, errInvalid
+			}
+			frames++
+			flags := data[p+9]
+			p += 10
+			if flags&0x80 != 0 {
+				p += 3 << (flags&0x07 + 1)
+			}
+			p, ok = skipSubBlocks(p + 1)
+		case 0x3b: // trailer
+			return frames, nil
+		default:
+			return 0, errInvalid
+		}
+		if !ok {
+			return 0, errInvalid
+		}
+	}
+	return 0, errInvalid
+}
@@ -0,0 +1,133 @@
+package decode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"slices"
+	"testing"
+)
+
+var (
+	red   = color.RGBA{R: 255, A: 255}
+	green = color.RGBA{G: 255, A: 255}
+	blue  = color.RGBA{B: 255, A: 255}
+	none  = color.RGBA{}
+)
+
+// animatedGIF encodes a 4x4 animation that exercises every disposal method:
+// a red background, a green square disposed to the background, a blue square
+// disposed to the previous frame, and a green dot.
+func animatedGIF(t *testing.T) []byte {
+	t.Helper()
+	palette := color.Palette{color.Transparent, red, green, blue}
+	frame := func(r image.Rectangle, index uint8) *image.Paletted {
+		p := image.NewPaletted(r, palette)
+		for i := range p.Pix {
+			p.Pix[i] = index
+		}
+		return p
+	}
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 4, 4), 1),
+			frame(image.Rect(0, 0, 2, 2), 2),
+			frame(image.Rect(2, 2, 4, 4), 3),
+			frame(image.Rect(3, 0, 4, 1), 2),
+		},
+		Delay:    []int{5, 0, 1, 20},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatalf("encoding test GIF: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeAnimated(t *testing.T) {
+	t.Parallel()
+
+	img, format, err := Limits{}.DecodeAnimated(bytes.NewReader(animatedGIF(t)))
+	if err != nil || format != "gif" {
+		t.Fatalf("DecodeAnimated() = %v, %v", format, err)
+	}
+	a, ok := img.(*Animation)
+	if !ok {
+		t.Fatalf("DecodeAnimated() returned %T, want *Animation", img)
+	}
+	if a.Len() != 4 || a.Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Errorf("animation has %d frames of %v, want 4 of 4x4", a.Len(), a.Bounds())
+	}
+	if got, want := a.Delays(), []int{5, 10, 10, 20}; !slices.Equal(got, want) {
+		t.Errorf("Delays() = %v, want %v", got, want)
+	}
+	if a.At(1, 1) != red {
+		t.Errorf("first frame at (1,1) = %v, want red", a.At(1, 1))
+	}
+
+	// Expected pixels at the top-left square, the bottom-right square and
+	// the top-right corner, after each frame.
+	want := [][3]color.RGBA{
+		{red, red, red},
+		{green, red, red},
+		{none, blue, red},
+		{none, red, green},
+	}
+	n := 0
+	for i, frame := range a.Frames() {
+		got := [3]color.RGBA{frame.RGBAAt(1, 1), frame.RGBAAt(3, 3), frame.RGBAAt(3, 0)}
+		if got != want[i] {
+			t.Errorf("frame %d = %v, want %v", i, got, want[i])
+		}
+		n++
+	}
+	if n != 4 {
+		t.Errorf("Frames() yielded %d frames, want 4", n)
+	}
+}
+
+func TestDecodeAnimated_Stills(t *testing.T) {
+	t.Parallel()
+
+	animated := animatedGIF(t)
+	tests := []struct {
+		name   string
+		data   []byte
+		limits Limits
+	}{
+		{"png", encoded(t, encodePNG, 4, 4), Limits{}},
+		{"single-frame gif", encoded(t, encodeGIF, 4, 4), Limits{}},
+		{"too many frames", animated, Limits{MaxFrames: 3}},
+		{"too many pixels in all", animated, Limits{MaxPixels: 63}},
+	}
+	for _, tt := range tests {
+		img, _, err := tt.limits.DecodeAnimated(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: DecodeAnimated() error: %v", tt.name, err)
+			continue
+		}
+		if _, ok := img.(*Animation); ok {
+			t.Errorf("%s: DecodeAnimated() returned an animation, want a still", tt.name)
+		}
+	}
+
+	if _, _, err := (Limits{MaxPixels: 15}).DecodeAnimated(bytes.NewReader(animated)); err == nil {
+		t.Error("DecodeAnimated() of a first frame over MaxPixels should fail")
+	}
+}
+
+func TestGIFFrameCount(t *testing.T) {
+	t.Parallel()
+
+	animated := animatedGIF(t)
+	if n, err := gifFrameCount(animated); err != nil || n != 4 {
+		t.Errorf("gifFrameCount() = %d, %v; want 4", n, err)
+	}
+	for _, data := range [][]byte{nil, []byte("GIF89a"), animated[:len(animated)-1], []byte("PNG89a0123456")} {
+		if _, err := gifFrameCount(data); err == nil {
+			t.Errorf("gifFrameCount(%q): expected error", data)
+		}
+	}
+}
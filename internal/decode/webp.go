@@ -0,0 +1,46 @@
+package decode
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// webpBitstreamSize returns the dimensions declared by the VP8 or VP8L
+// bitstream inside a WebP file. An extended (VP8X) file declares its canvas
+// size separately, and image.DecodeConfig reports only that, but the decoder
+// allocates for the bitstream, which can claim up to 16384x16384 pixels.
+func webpBitstreamSize(data []byte) (width, height int, err error) {
+	errInvalid := errors.New("webp: malformed container")
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, errInvalid
+	}
+
+	for p := 12; p+8 <= len(data); {
+		fourCC := string(data[p : p+4])
+		n := int(binary.LittleEndian.Uint32(data[p+4:]))
+		payload := data[p+8:]
+		if n < 0 || n > len(payload) {
+			return 0, 0, errInvalid
+		}
+		payload = payload[:n]
+
+		switch fourCC {
+		case "VP8L":
+			// Signature byte, then 14-bit width-1 and height-1.
+			if len(payload) < 5 || payload[0] != 0x2f {
+				return 0, 0, errInvalid
+			}
+			bits := binary.LittleEndian.Uint32(payload[1:])
+			return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+		case "VP8 ":
+			// Three-byte frame tag, start code, then 14-bit width and height
+			// each followed by two scaling bits.
+			if len(payload) < 10 || payload[3] != 0x9d || payload[4] != 0x01 || payload[5] != 0x2a {
+				return 0, 0, errInvalid
+			}
+			return int(binary.LittleEndian.Uint16(payload[6:]) & 0x3fff), int(binary.LittleEndian.Uint16(payload[8:]) & 0x3fff), nil
+		}
+		p += 8 + n + n%2
+	}
+	return 0, 0, errInvalid
+}
@@ -0,0 +1,241 @@
+// Package fetch downloads images from untrusted URLs without letting the
+// caller reach private networks.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrInvalidURL is returned for URLs that are not absolute http or
+	// https URLs.
+	ErrInvalidURL = errors.New("invalid URL")
+	// ErrBlocked is returned when a URL, or a redirect it leads to, points at
+	// a private, loopback, link-local or otherwise reserved address.
+	ErrBlocked = errors.New("destination address not allowed")
+	// ErrTooLarge is returned when a response body exceeds the size limit.
+	ErrTooLarge = errors.New("response body too large")
+	// ErrContentType is returned when a response is not an allowed type.
+	ErrContentType = errors.New("content type not allowed")
+)
+
+const (
+	// DefaultMaxBytes is the default response body size limit.
+	DefaultMaxBytes = 10 << 20
+	// maxRedirects is how many redirects a fetch follows before giving up.
+	maxRedirects = 5
+)
+
+// DefaultContentTypes are the image types accepted by default.
+var DefaultContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// reserved lists special-purpose ranges that the netip predicates used by
+// blocked do not cover.
+var reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, including broadcast
+	netip.MustParsePrefix("100::/64"),      // discard-only
+	// Tunnels and translators that reach IPv4 addresses embedded in the
+	// IPv6 one, including private ones.
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2002::/16"),      // 6to4
+	netip.MustParsePrefix("2001::/32"),      // Teredo
+}
+
+// nat64 is the well-known NAT64 prefix. Its addresses are translated to the
+// IPv4 address in their last 32 bits, which is checked in their place.
+var nat64 = netip.MustParsePrefix("64:ff9b::/96")
+
+// Fetcher downloads from untrusted URLs. The destination address is checked
+// when each connection is dialed, after DNS resolution, so neither redirects
+// nor DNS rebinding can reach a blocked address.
+type Fetcher struct {
+	client       *http.Client
+	maxBytes     int64
+	contentTypes []string
+	allowed      []netip.Prefix
+	wrap         func(http.RoundTripper) http.RoundTripper
+}
+
+// Option configures a Fetcher.
+type Option func(*Fetcher)
+
+// WithMaxBytes sets the response body size limit.
+func WithMaxBytes(n int64) Option {
+	return func(f *Fetcher) { f.maxBytes = n }
+}
+
+// WithContentTypes sets the media types a response may have.
+func WithContentTypes(types ...string) Option {
+	return func(f *Fetcher) { f.contentTypes = types }
+}
+
+// WithAllowedPrefixes exempts address ranges from blocking, for example to
+// reach an image host on an internal network.
+func WithAllowedPrefixes(prefixes ...netip.Prefix) Option {
+	return func(f *Fetcher) { f.allowed = append(f.allowed, prefixes...) }
+}
+
+// WithTransportWrapper wraps the fetcher's transport, for example with
+// retries. The wrapped transport still does the dialing, so the address
+// checks keep applying.
+func WithTransportWrapper(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return func(f *Fetcher) { f.wrap = wrap }
+}
+
+// New returns a Fetcher that behaves like base, with the same timeout and
+// TLS settings, but dials only public addresses and never uses a proxy. A
+// nil base means http.DefaultClient.
+func New(base *http.Client, opts ...Option) *Fetcher {
+	if base == nil {
+		base = http.DefaultClient
+	}
+	f := &Fetcher{
+		maxBytes:     DefaultMaxBytes,
+		contentTypes: DefaultContentTypes,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	var transport *http.Transport
+	if t, ok := base.Transport.(*http.Transport); ok {
+		transport = t.Clone()
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	// A proxy would make the dialed address meaningless.
+	transport.Proxy = nil
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   f.checkDial,
+	}
+	transport.DialContext = dialer.DialContext
+	transport.DialTLSContext = nil
+
+	var rt http.RoundTripper = transport
+	if f.wrap != nil {
+		rt = f.wrap(transport)
+	}
+	f.client = &http.Client{
+		Transport:     rt,
+		Timeout:       base.Timeout,
+		CheckRedirect: checkRedirect,
+	}
+	return f
+}
+
+// Fetch downloads rawURL and returns its body.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidURL, rawURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", u.Host, resp.StatusCode)
+	}
+	if resp.ContentLength > f.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d", ErrTooLarge, resp.ContentLength, f.maxBytes)
+	}
+
+	declared, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	generic := declared == "" || declared == "application/octet-stream" || declared == "binary/octet-stream"
+	if !generic && !slices.Contains(f.contentTypes, declared) {
+		return nil, fmt.Errorf("%w: %s", ErrContentType, declared)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+	if int64(len(body)) > f.maxBytes {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrTooLarge, f.maxBytes)
+	}
+
+	// Hosts that don't say what they serve get judged on the bytes.
+	if generic {
+		if sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(body)); !slices.Contains(f.contentTypes, sniffed) {
+			return nil, fmt.Errorf("%w: %s", ErrContentType, sniffed)
+		}
+	}
+	return body, nil
+}
+
+// checkDial is a net.Dialer Control function that refuses connections to
+// blocked addresses.
+func (f *Fetcher) checkDial(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: unparseable address %q", ErrBlocked, address)
+	}
+	addr := ap.Addr().Unmap()
+	for _, p := range f.allowed {
+		if p.Contains(addr) {
+			return nil
+		}
+	}
+	if blocked(addr) {
+		return fmt.Errorf("%w: %s", ErrBlocked, addr)
+	}
+	return nil
+}
+
+// blocked reports whether addr is anything other than a public unicast
+// address.
+func blocked(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if nat64.Contains(addr) {
+		a := addr.As16()
+		return blocked(netip.AddrFrom4([4]byte(a[12:])))
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return true
+	}
+	for _, p := range reserved {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRedirect limits redirect chains and keeps them on http and https.
+// Destination addresses are checked when the redirect is dialed.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: redirect to %q", ErrInvalidURL, req.URL)
+	}
+	return nil
+}
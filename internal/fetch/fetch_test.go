@@ -0,0 +1,205 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+)
+
+// loopback allows the fetcher to reach httptest servers, and nothing else
+// on the loopback network.
+var loopback = WithAllowedPrefixes(netip.MustParsePrefix("127.0.0.1/32"))
+
+// pngData is a small valid PNG.
+func pngData(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBlocked(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"127.8.9.10", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"255.255.255.255", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"64:ff9b::a9fe:a9fe", true},  // NAT64 of 169.254.169.254
+		{"64:ff9b::7f00:1", true},     // NAT64 of 127.0.0.1
+		{"64:ff9b:1::808:808", true},  // local-use NAT64
+		{"2002:7f00:1::1", true},      // 6to4 of 127.0.0.1
+		{"2002:808:808::1", true},     // 6to4 of 8.8.8.8
+		{"2001:0:4136:e378::1", true}, // Teredo
+		{"64:ff9b::808:808", false},   // NAT64 of 8.8.8.8
+		{"8.8.8.8", false},
+		{"151.101.1.140", false},
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if got := blocked(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("blocked(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	t.Parallel()
+
+	png := pngData(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	})
+	mux.HandleFunc("/untyped", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(png)
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/untyped-text", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("definitely not an image"))
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, 2048))
+	})
+	mux.HandleFunc("/huge-chunked", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		for range 4 {
+			w.Write(make([]byte, 512))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, _ *http.Request) {
+		http.NotFound(w, nil)
+	})
+	mux.HandleFunc("/to-image", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/image.png", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/to-loopback", func(w http.ResponseWriter, r *http.Request) {
+		// Same server, different loopback address: only 127.0.0.1 is allowed.
+		http.Redirect(w, r, "http://127.0.0.2:"+srv.URL[len("http://127.0.0.1:"):]+"/image.png", http.StatusFound)
+	})
+	mux.HandleFunc("/to-metadata", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	})
+	mux.HandleFunc("/to-file", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+
+	tests := []struct {
+		name    string
+		url     string
+		opts    []Option
+		wantErr error
+	}{
+		{name: "image", url: srv.URL + "/image.png", opts: []Option{loopback}},
+		{name: "untyped image is sniffed", url: srv.URL + "/untyped", opts: []Option{loopback}},
+		{name: "redirect to image", url: srv.URL + "/to-image", opts: []Option{loopback}},
+		{name: "loopback blocked by default", url: srv.URL + "/image.png", wantErr: ErrBlocked},
+		{name: "redirect to other loopback", url: srv.URL + "/to-loopback", opts: []Option{loopback}, wantErr: ErrBlocked},
+		{name: "redirect to metadata service", url: srv.URL + "/to-metadata", opts: []Option{loopback}, wantErr: ErrBlocked},
+		{name: "redirect to file", url: srv.URL + "/to-file", opts: []Option{loopback}, wantErr: ErrInvalidURL},
+		{name: "private literal", url: "http://10.0.0.1/image.png", wantErr: ErrBlocked},
+		{name: "localhost name", url: "http://localhost:1/image.png", wantErr: ErrBlocked},
+		{name: "file scheme", url: "file:///etc/passwd", wantErr: ErrInvalidURL},
+		{name: "relative", url: "/image.png", wantErr: ErrInvalidURL},
+		{name: "html", url: srv.URL + "/page.html", opts: []Option{loopback}, wantErr: ErrContentType},
+		{name: "untyped text", url: srv.URL + "/untyped-text", opts: []Option{loopback}, wantErr: ErrContentType},
+		{name: "content length over limit", url: srv.URL + "/huge", opts: []Option{loopback, WithMaxBytes(1024)}, wantErr: ErrTooLarge},
+		{name: "chunked body over limit", url: srv.URL + "/huge-chunked", opts: []Option{loopback, WithMaxBytes(1024)}, wantErr: ErrTooLarge},
+		{name: "type not in custom list", url: srv.URL + "/image.png", opts: []Option{loopback, WithContentTypes("image/jpeg")}, wantErr: ErrContentType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			body, err := New(srv.Client(), tt.opts...).Fetch(context.Background(), tt.url)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Fetch() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Fetch() error: %v", err)
+			}
+			if !bytes.Equal(body, png) {
+				t.Errorf("Fetch() returned %d bytes, want the %d-byte PNG", len(body), len(png))
+			}
+		})
+	}
+
+	t.Run("status error", func(t *testing.T) {
+		t.Parallel()
+		if _, err := New(srv.Client(), loopback).Fetch(context.Background(), srv.URL+"/missing"); err == nil {
+			t.Error("Fetch() of a 404 should fail")
+		}
+	})
+
+	t.Run("redirect loop", func(t *testing.T) {
+		t.Parallel()
+		if _, err := New(srv.Client(), loopback).Fetch(context.Background(), srv.URL+"/loop"); err == nil {
+			t.Error("Fetch() of a redirect loop should fail")
+		}
+	})
+
+	t.Run("wrapped transport", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int64
+		wrap := WithTransportWrapper(func(rt http.RoundTripper) http.RoundTripper {
+			return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls.Add(1)
+				return rt.RoundTrip(req)
+			})
+		})
+		if _, err := New(srv.Client(), loopback, wrap).Fetch(context.Background(), srv.URL+"/image.png"); err != nil {
+			t.Errorf("Fetch() error: %v", err)
+		}
+		if _, err := New(srv.Client(), wrap).Fetch(context.Background(), srv.URL+"/image.png"); !errors.Is(err, ErrBlocked) {
+			t.Errorf("Fetch() error = %v, want %v through the wrapper", err, ErrBlocked)
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("wrapper saw %d requests, want 2", n)
+		}
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
@@ -0,0 +1,243 @@
+package format
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"io"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+)
+
+// pngSignature is the eight-byte header that starts every PNG file.
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+// PNG color types used by the APNG encoder.
+const (
+	pngColorRGB  = 2
+	pngColorRGBA = 6
+)
+
+// apngEncoder writes an infinitely looping animated PNG. Unlike GIF it keeps
+// full 24-bit color, and browsers that don't understand APNG still show the
+// first frame.
+type apngEncoder struct{}
+
+func (apngEncoder) Name() string        { return "apng" }
+func (apngEncoder) ContentType() string { return "image/apng" }
+func (apngEncoder) Extension() string   { return "png" }
+func (apngEncoder) Animated() bool      { return true }
+
+func (apngEncoder) Encode(w io.Writer, anim *meme.Animation, _ Options) error {
+	if len(anim.Frames) == 0 {
+		return errors.New("apng: animation has no frames")
+	}
+
+	bounds := anim.Frames[0].Bounds()
+	opaque := true
+	for _, frame := range anim.Frames {
+		if frame.Bounds().Size() != bounds.Size() {
+			return errors.New("apng: frames differ in size")
+		}
+		opaque = opaque && frame.Opaque()
+	}
+	colorType := byte(pngColorRGBA)
+	if opaque {
+		colorType = pngColorRGB
+	}
+
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(bounds.Dy()))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = colorType
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(anim.Frames)))
+	binary.BigEndian.PutUint32(actl[4:], 0) // loop forever
+	if err := writePNGChunk(w, "acTL", actl); err != nil {
+		return err
+	}
+
+	var seq uint32
+	for i, frame := range anim.Frames {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(bounds.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(bounds.Dy()))
+		// x and y offsets stay zero: every frame covers the whole canvas.
+		binary.BigEndian.PutUint16(fctl[20:], uint16(anim.Delays[i]))
+		binary.BigEndian.PutUint16(fctl[22:], 100) // delays are in 1/100 s
+		// dispose_op and blend_op stay zero: APNG_DISPOSE_OP_NONE and
+		// APNG_BLEND_OP_SOURCE.
+		if err := writePNGChunk(w, "fcTL", fctl); err != nil {
+			return err
+		}
+		seq++
+
+		data, err := compressPNGFrame(frame, colorType)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			err = writePNGChunk(w, "IDAT", data)
+		} else {
+			fdat := make([]byte, 4, 4+len(data))
+			binary.BigEndian.PutUint32(fdat, seq)
+			err = writePNGChunk(w, "fdAT", append(fdat, data...))
+			seq++
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+// writePNGChunk writes a length-prefixed, CRC-terminated PNG chunk.
+func writePNGChunk(w io.Writer, typ string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:], uint32(len(data)))
+	copy(header[4:], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := binary.BigEndian.AppendUint32(nil, crc.Sum32())
+
+	for _, b := range [][]byte{header, data, footer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressPNGFrame filters and zlib-compresses a frame's scanlines as PNG
+// image data. Each row uses whichever of the five PNG filters gives the
+// smallest sum of absolute residuals, the same heuristic image/png uses.
+func compressPNGFrame(img *image.RGBA, colorType byte) ([]byte, error) {
+	bpp := 4
+	if colorType == pngColorRGB {
+		bpp = 3
+	}
+	b := img.Bounds()
+	rowLen := bpp * b.Dx()
+
+	prev := make([]byte, rowLen)
+	cur := make([]byte, rowLen)
+	var filtered [5][]byte
+	for f := range filtered {
+		filtered[f] = make([]byte, rowLen)
+	}
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, a := row[4*x], row[4*x+1], row[4*x+2], row[4*x+3]
+			if bpp == 3 {
+				cur[3*x], cur[3*x+1], cur[3*x+2] = r, g, bl
+				continue
+			}
+			// PNG stores straight alpha; image.RGBA is premultiplied.
+			if a != 0 && a != 0xff {
+				r = uint8(uint16(r) * 0xff / uint16(a))
+				g = uint8(uint16(g) * 0xff / uint16(a))
+				bl = uint8(uint16(bl) * 0xff / uint16(a))
+			}
+			cur[4*x], cur[4*x+1], cur[4*x+2], cur[4*x+3] = r, g, bl, a
+		}
+
+		best, bestScore := 0, -1
+		for f := range filtered {
+			score := applyPNGFilter(f, filtered[f], cur, prev, bpp)
+			if bestScore < 0 || score < bestScore {
+				best, bestScore = f, score
+			}
+		}
+
+		if _, err := zw.Write([]byte{byte(best)}); err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+		prev, cur = cur, prev
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// applyPNGFilter writes cur filtered with PNG filter type f into dst and
+// returns the sum of the absolute values of the residuals.
+func applyPNGFilter(f int, dst, cur, prev []byte, bpp int) int {
+	score := 0
+	for i := range cur {
+		var left, up, upLeft byte
+		if i >= bpp {
+			left = cur[i-bpp]
+			upLeft = prev[i-bpp]
+		}
+		up = prev[i]
+
+		var pred byte
+		switch f {
+		case 1:
+			pred = left
+		case 2:
+			pred = up
+		case 3:
+			pred = byte((int(left) + int(up)) / 2)
+		case 4:
+			pred = paeth(left, up, upLeft)
+		}
+		d := cur[i] - pred
+		dst[i] = d
+		score += absInt8(d)
+	}
+	return score
+}
+
+// paeth implements the PNG Paeth predictor.
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := absInt(p-int(a)), absInt(p-int(b)), absInt(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func absInt8(b byte) int {
+	return absInt(int(int8(b)))
+}
@@ -0,0 +1,172 @@
+// Package format encodes rendered meme animations into image file formats.
+package format
+
+import (
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+)
+
+// Encoder writes a meme animation in one image format.
+type Encoder interface {
+	// Name is the short name used to select the encoder, e.g. "gif".
+	Name() string
+	// ContentType is the MIME type of the encoded output.
+	ContentType() string
+	// Extension is the conventional file extension, without the dot.
+	Extension() string
+	// Animated reports whether the format keeps every frame. Still formats
+	// encode only Options.Frame.
+	Animated() bool
+	// Encode writes anim to w.
+	Encode(w io.Writer, anim *meme.Animation, opts Options) error
+}
+
+// Options controls how an animation is encoded.
+type Options struct {
+	// Frame is the index of the frame written by still formats.
+	Frame int
+	// Quality controls palette quantization for GIF output. Truecolor
+	// formats ignore it.
+	Quality Quality
+	// Comment, if set, is embedded in GIF output as a comment extension.
+	// Other formats ignore it.
+	Comment string
+}
+
+// encoders lists every supported encoder in order of server preference,
+// which breaks ties during content negotiation. The first entry is the
+// default.
+var encoders = []Encoder{
+	gifEncoder{},
+	webpEncoder{},
+	apngEncoder{},
+	pngEncoder{},
+	jpegEncoder{},
+}
+
+// aliases maps alternative format names to their canonical name.
+var aliases = map[string]string{
+	"jpg": "jpeg",
+}
+
+// Default returns the encoder used when the client expresses no preference.
+func Default() Encoder {
+	return encoders[0]
+}
+
+// Names returns the canonical names of every supported format.
+func Names() []string {
+	names := make([]string, len(encoders))
+	for i, e := range encoders {
+		names[i] = e.Name()
+	}
+	return names
+}
+
+// Lookup returns the encoder registered under name. Names are matched
+// case-insensitively.
+func Lookup(name string) (Encoder, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if canonical, ok := aliases[name]; ok {
+		name = canonical
+	}
+	for _, e := range encoders {
+		if e.Name() == name {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// mediaRange is one entry of an Accept header.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// matches reports whether the range covers contentType and how specifically:
+// 2 for an exact match, 1 for type/*, 0 for */*, and -1 for no match.
+func (m mediaRange) matches(contentType string) int {
+	typ, subtype, _ := strings.Cut(contentType, "/")
+	switch {
+	case m.typ == typ && m.subtype == subtype:
+		return 2
+	case m.typ == typ && m.subtype == "*":
+		return 1
+	case m.typ == "*" && m.subtype == "*":
+		return 0
+	}
+	return -1
+}
+
+// parseAccept parses an Accept header into its media ranges. Malformed
+// entries are skipped.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		typ, subtype, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+	return ranges
+}
+
+// Negotiate picks the encoder that best satisfies an Accept header. Each
+// encoder takes the quality of the most specific range matching its content
+// type; the highest quality wins and ties go to the server's preference
+// order. If nothing acceptable is supported, Default is returned so that
+// clients with unusual Accept headers still get a meme.
+func Negotiate(accept string) Encoder {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return Default()
+	}
+
+	type candidate struct {
+		enc  Encoder
+		q    float64
+		rank int
+	}
+	var candidates []candidate
+	for rank, e := range encoders {
+		best, bestSpecificity := 0.0, -1
+		for _, r := range ranges {
+			if s := r.matches(e.ContentType()); s > bestSpecificity {
+				best, bestSpecificity = r.q, s
+			}
+		}
+		if bestSpecificity >= 0 && best > 0 {
+			candidates = append(candidates, candidate{enc: e, q: best, rank: rank})
+		}
+	}
+	if len(candidates) == 0 {
+		return Default()
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].rank < candidates[j].rank
+	})
+	return candidates[0].enc
+}
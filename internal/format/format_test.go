@@ -0,0 +1,244 @@
+package format
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+)
+
+// testAnimation builds an animation of n frames, each a gradient with a
+// frame-dependent stripe so frames differ from each other.
+func testAnimation(n, w, h int) *meme.Animation {
+	anim := &meme.Animation{}
+	for i := range n {
+		frame := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := range h {
+			for x := range w {
+				c := color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255}
+				if x/4 == i {
+					c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+				}
+				frame.SetRGBA(x, y, c)
+			}
+		}
+		anim.Frames = append(anim.Frames, frame)
+		anim.Delays = append(anim.Delays, 8)
+	}
+	return anim
+}
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		wantName string
+		wantOK   bool
+	}{
+		{"gif", "gif", true},
+		{"GIF", "gif", true},
+		{"webp", "webp", true},
+		{"apng", "apng", true},
+		{"png", "png", true},
+		{"jpeg", "jpeg", true},
+		{"jpg", "jpeg", true},
+		{"bmp", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		enc, ok := Lookup(tt.name)
+		if ok != tt.wantOK {
+			t.Errorf("Lookup(%q) ok = %v, want %v", tt.name, ok, tt.wantOK)
+			continue
+		}
+		if ok && enc.Name() != tt.wantName {
+			t.Errorf("Lookup(%q) = %q, want %q", tt.name, enc.Name(), tt.wantName)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "gif"},
+		{"*/*", "gif"},
+		{"text/html", "gif"},
+		{"image/*", "gif"},
+		{"image/png", "png"},
+		{"image/jpeg", "jpeg"},
+		{"image/apng", "apng"},
+		{"image/webp", "webp"},
+		{"image/png;q=0.5, image/jpeg", "jpeg"},
+		{"image/webp;q=0, */*", "gif"},
+		{"image/gif;q=0.1, image/png;q=0.9", "png"},
+		// Typical browser navigation header.
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8", "webp"},
+		{"garbage;;;, image/jpeg", "jpeg"},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.accept).Name(); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestGIFEncoder(t *testing.T) {
+	t.Parallel()
+
+	anim := testAnimation(3, 32, 24)
+	var buf bytes.Buffer
+	if err := (gifEncoder{}).Encode(&buf, anim, Options{}); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+
+	decoded, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("gif.DecodeAll() error: %v", err)
+	}
+	if len(decoded.Image) != 3 {
+		t.Errorf("frame count = %d, want 3", len(decoded.Image))
+	}
+	for i, d := range decoded.Delay {
+		if d != 8 {
+			t.Errorf("frame %d delay = %d, want 8", i, d)
+		}
+	}
+	if decoded.LoopCount != 0 {
+		t.Errorf("LoopCount = %d, want 0 (infinite)", decoded.LoopCount)
+	}
+}
+
+func TestGIFEncoder_Comment(t *testing.T) {
+	t.Parallel()
+
+	anim := testAnimation(3, 32, 24)
+	var plain, commented bytes.Buffer
+	if err := (gifEncoder{}).Encode(&plain, anim, Options{}); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	comment := strings.Repeat("potato ", 86) // 602 bytes: three sub-blocks
+	if err := (gifEncoder{}).Encode(&commented, anim, Options{Comment: comment}); err != nil {
+		t.Fatalf("Encode() with comment error: %v", err)
+	}
+
+	// The comment extension goes right before the trailer.
+	want := append([]byte(nil), plain.Bytes()[:plain.Len()-1]...)
+	want = append(want, 0x21, 0xfe)
+	for _, block := range []string{comment[:255], comment[255:510], comment[510:]} {
+		want = append(want, byte(len(block)))
+		want = append(want, block...)
+	}
+	want = append(want, 0x00, 0x3b)
+	if !bytes.Equal(commented.Bytes(), want) {
+		t.Fatal("commented GIF is not the plain GIF with a comment extension before the trailer")
+	}
+
+	decoded, err := gif.DecodeAll(&commented)
+	if err != nil {
+		t.Fatalf("gif.DecodeAll() error: %v", err)
+	}
+	if len(decoded.Image) != 3 {
+		t.Errorf("frame count = %d, want 3", len(decoded.Image))
+	}
+}
+
+func TestStillEncoders(t *testing.T) {
+	t.Parallel()
+
+	anim := testAnimation(3, 32, 24)
+
+	var buf bytes.Buffer
+	if err := (pngEncoder{}).Encode(&buf, anim, Options{Frame: 2}); err != nil {
+		t.Fatalf("png Encode() error: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("png.Decode() error: %v", err)
+	}
+	if got, want := img.At(9, 5), color.Color(anim.Frames[2].At(9, 5)); !colorsEqual(got, want) {
+		t.Errorf("png pixel = %v, want frame 2 pixel %v", got, want)
+	}
+
+	buf.Reset()
+	if err := (jpegEncoder{}).Encode(&buf, anim, Options{}); err != nil {
+		t.Fatalf("jpeg Encode() error: %v", err)
+	}
+	if _, err := jpeg.Decode(&buf); err != nil {
+		t.Fatalf("jpeg.Decode() error: %v", err)
+	}
+
+	for _, frame := range []int{-1, 3} {
+		if err := (pngEncoder{}).Encode(&bytes.Buffer{}, anim, Options{Frame: frame}); err == nil {
+			t.Errorf("png Encode() with frame %d should fail", frame)
+		}
+	}
+}
+
+func TestAPNGEncoder(t *testing.T) {
+	t.Parallel()
+
+	anim := testAnimation(4, 32, 24)
+	var buf bytes.Buffer
+	if err := (apngEncoder{}).Encode(&buf, anim, Options{}); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	data := buf.Bytes()
+
+	// Decoders without APNG support must still see a valid PNG of frame 0.
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode() error: %v", err)
+	}
+	b := anim.Frames[0].Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !colorsEqual(img.At(x, y), anim.Frames[0].At(x, y)) {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, img.At(x, y), anim.Frames[0].At(x, y))
+			}
+		}
+	}
+
+	chunks := map[string]int{}
+	for p := len(pngSignature); p+8 <= len(data); {
+		n := int(uint32(data[p])<<24 | uint32(data[p+1])<<16 | uint32(data[p+2])<<8 | uint32(data[p+3]))
+		chunks[string(data[p+4:p+8])]++
+		p += 12 + n
+	}
+	if chunks["acTL"] != 1 {
+		t.Errorf("acTL chunks = %d, want 1", chunks["acTL"])
+	}
+	if chunks["fcTL"] != 4 {
+		t.Errorf("fcTL chunks = %d, want 4", chunks["fcTL"])
+	}
+	if chunks["fdAT"] != 3 {
+		t.Errorf("fdAT chunks = %d, want 3", chunks["fdAT"])
+	}
+}
+
+func TestEncoders_EmptyAnimation(t *testing.T) {
+	t.Parallel()
+
+	for _, enc := range encoders {
+		if err := enc.Encode(&bytes.Buffer{}, &meme.Animation{}, Options{}); err == nil {
+			t.Errorf("%s Encode() of empty animation should fail", enc.Name())
+		}
+	}
+}
+
+// colorsEqual compares two colors in 8-bit non-premultiplied RGBA.
+func colorsEqual(a, b color.Color) bool {
+	na := color.NRGBAModel.Convert(a).(color.NRGBA)
+	nb := color.NRGBAModel.Convert(b).(color.NRGBA)
+	return na == nb
+}
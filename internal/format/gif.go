@@ -0,0 +1,209 @@
+package format
+
+import (
+	"compress/lzw"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+)
+
+// gifEncoder writes an infinitely looping animated GIF whose palette is
+// built from the frames themselves, as controlled by Options.Quality.
+// Frames after the first only store what changed since the previous one.
+type gifEncoder struct{}
+
+func (gifEncoder) Name() string        { return "gif" }
+func (gifEncoder) ContentType() string { return "image/gif" }
+func (gifEncoder) Extension() string   { return "gif" }
+func (gifEncoder) Animated() bool      { return true }
+
+func (gifEncoder) Encode(w io.Writer, anim *meme.Animation, opts Options) error {
+	if len(anim.Frames) == 0 {
+		return errors.New("gif: animation has no frames")
+	}
+	quality := opts.Quality.orDefault()
+	// One palette entry is held back for the transparent pixels written by
+	// optimizeFrames.
+	colors := quality.Colors - 1
+
+	out := &gif.GIF{
+		LoopCount: 0, // infinite loop
+		Delay:     append([]int(nil), anim.Delays...),
+	}
+
+	var global *quantizer
+	if !quality.PerFrame {
+		global = newQuantizer(anim.Frames, colors)
+		global.reserveTransparent()
+		// Frames whose palette is this very slice are written without a
+		// local color table.
+		bounds := anim.Frames[0].Bounds()
+		out.Config = image.Config{
+			ColorModel: global.palette,
+			Width:      bounds.Dx(),
+			Height:     bounds.Dy(),
+		}
+	}
+
+	full := make([]*image.Paletted, len(anim.Frames))
+	for i, frame := range anim.Frames {
+		q := global
+		if q == nil {
+			q = newQuantizer([]*image.RGBA{frame}, colors)
+			q.reserveTransparent()
+		}
+		full[i] = q.paletted(frame, quality.Dither)
+	}
+	out.Image, out.Disposal = optimizeFrames(full)
+
+	if opts.Comment == "" {
+		return gif.EncodeAll(w, out)
+	}
+	cw := &trailerWriter{w: w}
+	if err := gif.EncodeAll(cw, out); err != nil {
+		return err
+	}
+	return cw.finish(commentExtension(opts.Comment))
+}
+
+// trailerWriter passes a GIF stream through but holds back its last byte, the
+// trailer, so that more blocks can be written before it.
+type trailerWriter struct {
+	w       io.Writer
+	trailer []byte
+}
+
+func (t *trailerWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := t.w.Write(t.trailer); err != nil {
+		return 0, err
+	}
+	if _, err := t.w.Write(p[:len(p)-1]); err != nil {
+		return 0, err
+	}
+	t.trailer = append(t.trailer[:0], p[len(p)-1])
+	return len(p), nil
+}
+
+// finish writes blocks and then the held-back trailer.
+func (t *trailerWriter) finish(blocks []byte) error {
+	if _, err := t.w.Write(blocks); err != nil {
+		return err
+	}
+	_, err := t.w.Write(t.trailer)
+	return err
+}
+
+// commentExtension encodes text as a GIF comment extension: an introducer and
+// label followed by data sub-blocks of at most 255 bytes and a terminator.
+func commentExtension(text string) []byte {
+	b := []byte{0x21, 0xfe}
+	for len(text) > 0 {
+		n := min(len(text), 255)
+		b = append(b, byte(n))
+		b = append(b, text[:n]...)
+		text = text[n:]
+	}
+	return append(b, 0)
+}
+
+// optimizeFrames crops every frame after the first to the bounding box of the
+// pixels that differ from the frame before it, and makes the unchanged pixels
+// inside that box transparent when that compresses better. Every frame is
+// left in place when the next is drawn, so the canvas always ends up holding
+// the full frame, which is what the next frame is compared against. Each
+// frame's palette must end with a transparent entry.
+func optimizeFrames(frames []*image.Paletted) ([]*image.Paletted, []byte) {
+	out := make([]*image.Paletted, len(frames))
+	disposal := make([]byte, len(frames))
+	for i := range disposal {
+		disposal[i] = gif.DisposalNone
+	}
+	out[0] = frames[0]
+
+	prevColors := paletteColors(frames[0].Palette)
+	for i := 1; i < len(frames); i++ {
+		prev, cur := frames[i-1], frames[i]
+		curColors := paletteColors(cur.Palette)
+		changed := func(x, y int) bool {
+			return prevColors[prev.ColorIndexAt(x, y)] != curColors[cur.ColorIndexAt(x, y)]
+		}
+
+		b := cur.Bounds()
+		minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X, b.Min.Y
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if changed(x, y) {
+					minX, minY = min(minX, x), min(minY, y)
+					maxX, maxY = max(maxX, x+1), max(maxY, y+1)
+				}
+			}
+		}
+
+		// A frame identical to the previous one still needs an image to
+		// carry its delay; a single transparent pixel does.
+		box := image.Rectangle{Min: image.Pt(minX, minY), Max: image.Pt(maxX, maxY)}
+		if box.Empty() {
+			box = image.Rect(b.Min.X, b.Min.Y, b.Min.X+1, b.Min.Y+1)
+		}
+
+		transparent := uint8(len(cur.Palette) - 1)
+		opaque := image.NewPaletted(box, cur.Palette)
+		masked := image.NewPaletted(box, cur.Palette)
+		for y := box.Min.Y; y < box.Max.Y; y++ {
+			for x := box.Min.X; x < box.Max.X; x++ {
+				idx := cur.ColorIndexAt(x, y)
+				opaque.SetColorIndex(x, y, idx)
+				if !changed(x, y) {
+					idx = transparent
+				}
+				masked.SetColorIndex(x, y, idx)
+			}
+		}
+
+		// Transparency only pays off when unchanged pixels form runs; where
+		// they are scattered, as in dithered or moving areas, they break up
+		// runs that would otherwise compress well. Keep whichever is smaller.
+		crop := masked
+		if compressedSize(opaque) <= compressedSize(masked) {
+			crop = opaque
+		}
+		out[i] = crop
+		prevColors = curColors
+	}
+	return out, disposal
+}
+
+// compressedSize returns the number of bytes the GIF writer's LZW stage
+// produces for img.
+func compressedSize(img *image.Paletted) int {
+	var n countingWriter
+	zw := lzw.NewWriter(&n, lzw.LSB, 8)
+	zw.Write(img.Pix)
+	zw.Close()
+	return int(n)
+}
+
+// countingWriter discards its input, counting the bytes.
+type countingWriter int
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// paletteColors converts p to concrete colors so that entries from different
+// palettes can be compared cheaply.
+func paletteColors(p color.Palette) []color.RGBA {
+	colors := make([]color.RGBA, len(p))
+	for i, c := range p {
+		colors[i] = color.RGBAModel.Convert(c).(color.RGBA)
+	}
+	return colors
+}
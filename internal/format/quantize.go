@@ -0,0 +1,305 @@
+package format
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+)
+
+// Quality controls how GIF frames are reduced to a palette. The zero value
+// selects the "medium" preset.
+type Quality struct {
+	// Colors is the maximum palette size, between 2 and 256.
+	Colors int
+	// PerFrame builds a palette for every frame instead of one palette
+	// shared by the whole animation. Frames look better; files get larger
+	// because each frame carries its own color table.
+	PerFrame bool
+	// Dither diffuses quantization error with Floyd–Steinberg. It hides
+	// banding in gradients at the cost of noisier, less compressible frames.
+	Dither bool
+}
+
+// qualities are the presets accepted by ParseQuality, from smallest output
+// to best looking.
+var qualities = []struct {
+	name    string
+	quality Quality
+}{
+	{"low", Quality{Colors: 64}},
+	{"medium", Quality{Colors: 256, Dither: true}},
+	{"high", Quality{Colors: 256, PerFrame: true, Dither: true}},
+}
+
+// QualityNames returns the names of the quality presets.
+func QualityNames() []string {
+	names := make([]string, len(qualities))
+	for i, q := range qualities {
+		names[i] = q.name
+	}
+	return names
+}
+
+// ParseQuality returns the preset registered under name. Names are matched
+// case-insensitively.
+func ParseQuality(name string) (Quality, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, q := range qualities {
+		if q.name == name {
+			return q.quality, nil
+		}
+	}
+	return Quality{}, fmt.Errorf("unknown quality %q (supported: %s)", name, strings.Join(QualityNames(), ", "))
+}
+
+// orDefault returns q, or the "medium" preset if q is the zero value, with
+// Colors clamped to what a GIF color table can hold.
+func (q Quality) orDefault() Quality {
+	if q == (Quality{}) {
+		q = qualities[1].quality
+	}
+	q.Colors = max(2, min(q.Colors, 256))
+	return q
+}
+
+// Colors are histogrammed and looked up at 5 bits per channel.
+const (
+	quantBits = 5
+	quantBins = 1 << (3 * quantBits)
+)
+
+// binIndex returns the histogram bin of an 8-bit RGB color.
+func binIndex(r, g, b uint8) int {
+	const shift = 8 - quantBits
+	return int(r>>shift)<<(2*quantBits) | int(g>>shift)<<quantBits | int(b>>shift)
+}
+
+// colorBin accumulates the pixels that fall into one histogram bin.
+type colorBin struct {
+	count            uint64
+	sumR, sumG, sumB uint64
+	// Bin coordinates, one quantBits-wide value per channel.
+	coords [3]uint8
+}
+
+// quantizer maps truecolor pixels onto an adaptive palette.
+type quantizer struct {
+	palette color.Palette
+	// lut caches the nearest palette index for each histogram bin, or -1 if
+	// the bin has not been looked up yet.
+	lut []int16
+}
+
+// newQuantizer builds a palette of at most colors entries for frames using
+// median cut: the color space is repeatedly split along the widest channel
+// of the box that holds the most pixels times its extent, and each final
+// box contributes the mean of its pixels. Frames are treated as opaque.
+func newQuantizer(frames []*image.RGBA, colors int) *quantizer {
+	hist := make([]colorBin, quantBins)
+	for _, frame := range frames {
+		b := frame.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := frame.Pix[frame.PixOffset(b.Min.X, y):frame.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				bin := &hist[binIndex(row[i], row[i+1], row[i+2])]
+				bin.count++
+				bin.sumR += uint64(row[i])
+				bin.sumG += uint64(row[i+1])
+				bin.sumB += uint64(row[i+2])
+			}
+		}
+	}
+
+	var used []colorBin
+	for i, bin := range hist {
+		if bin.count == 0 {
+			continue
+		}
+		bin.coords = [3]uint8{uint8(i >> (2 * quantBits)), uint8(i >> quantBits & (1<<quantBits - 1)), uint8(i & (1<<quantBits - 1))}
+		used = append(used, bin)
+	}
+
+	boxes := [][]colorBin{used}
+	if len(used) == 0 {
+		boxes = nil
+	}
+	for len(boxes) < colors {
+		best, bestScore, bestAxis := -1, uint64(0), 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			axis, extent := widestAxis(box)
+			var pixels uint64
+			for _, bin := range box {
+				pixels += bin.count
+			}
+			if score := pixels * uint64(extent); score > bestScore {
+				best, bestScore, bestAxis = i, score, axis
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		lo, hi := splitBox(boxes[best], bestAxis)
+		boxes[best] = lo
+		boxes = append(boxes, hi)
+	}
+
+	q := &quantizer{lut: make([]int16, quantBins)}
+	for i := range q.lut {
+		q.lut[i] = -1
+	}
+	for _, box := range boxes {
+		var n, r, g, b uint64
+		for _, bin := range box {
+			n += bin.count
+			r += bin.sumR
+			g += bin.sumG
+			b += bin.sumB
+		}
+		q.palette = append(q.palette, color.RGBA{
+			R: uint8((r + n/2) / n),
+			G: uint8((g + n/2) / n),
+			B: uint8((b + n/2) / n),
+			A: 0xff,
+		})
+	}
+	if len(q.palette) == 0 {
+		q.palette = color.Palette{color.RGBA{A: 0xff}}
+	}
+	return q
+}
+
+// widestAxis returns the channel along which box spans the most bins, and
+// that span.
+func widestAxis(box []colorBin) (axis, extent int) {
+	for a := range 3 {
+		lo, hi := uint8(255), uint8(0)
+		for _, bin := range box {
+			lo = min(lo, bin.coords[a])
+			hi = max(hi, bin.coords[a])
+		}
+		if e := int(hi - lo); e > extent {
+			axis, extent = a, e
+		}
+	}
+	return axis, extent
+}
+
+// splitBox sorts box along axis and cuts it where half of its pixels lie on
+// each side. Both halves are non-empty.
+func splitBox(box []colorBin, axis int) (lo, hi []colorBin) {
+	slices.SortFunc(box, func(a, b colorBin) int {
+		return int(a.coords[axis]) - int(b.coords[axis])
+	})
+
+	var total uint64
+	for _, bin := range box {
+		total += bin.count
+	}
+	var acc uint64
+	cut := 1
+	for i, bin := range box[:len(box)-1] {
+		acc += bin.count
+		cut = i + 1
+		if acc*2 >= total {
+			break
+		}
+	}
+	return box[:cut:cut], box[cut:]
+}
+
+// reserveTransparent appends a fully transparent entry to the palette and
+// returns its index. Pixels are never mapped to it.
+func (q *quantizer) reserveTransparent() uint8 {
+	q.palette = append(q.palette, color.RGBA{})
+	return uint8(len(q.palette) - 1)
+}
+
+// index returns the palette index nearest to an 8-bit RGB color.
+func (q *quantizer) index(r, g, b uint8) uint8 {
+	bin := binIndex(r, g, b)
+	if i := q.lut[bin]; i >= 0 {
+		return uint8(i)
+	}
+
+	// Match against the center of the bin so that the cached answer is
+	// right for every color that shares it.
+	const shift, half = 8 - quantBits, 1 << (8 - quantBits - 1)
+	cr := int(r>>shift)<<shift | half
+	cg := int(g>>shift)<<shift | half
+	cb := int(b>>shift)<<shift | half
+
+	best, bestDist := 0, int(^uint(0)>>1)
+	for i, c := range q.palette {
+		pc := c.(color.RGBA)
+		if pc.A == 0 {
+			continue // the reserved transparent entry
+		}
+		dr, dg, db := cr-int(pc.R), cg-int(pc.G), cb-int(pc.B)
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	q.lut[bin] = int16(best)
+	return uint8(best)
+}
+
+// paletted converts src to an image using the quantizer's palette,
+// optionally with Floyd–Steinberg dithering.
+func (q *quantizer) paletted(src *image.RGBA, dither bool) *image.Paletted {
+	b := src.Bounds()
+	dst := image.NewPaletted(b, q.palette)
+	if !dither {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			s := src.PixOffset(b.Min.X, y)
+			d := dst.PixOffset(b.Min.X, y)
+			for x := 0; x < b.Dx(); x++ {
+				p := src.Pix[s+4*x:]
+				dst.Pix[d+x] = q.index(p[0], p[1], p[2])
+			}
+		}
+		return dst
+	}
+
+	// Error rows are indexed from -1 to Dx so that the diffusion below never
+	// needs a bounds check at the edges; errors are scaled by 16.
+	w := b.Dx()
+	cur := make([][3]int32, w+2)
+	next := make([][3]int32, w+2)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		s := src.PixOffset(b.Min.X, y)
+		d := dst.PixOffset(b.Min.X, y)
+		for x := range w {
+			p := src.Pix[s+4*x:]
+			var want [3]int32
+			for c := range 3 {
+				want[c] = clampUint8(int32(p[c]) + (cur[x+1][c]+8)>>4)
+			}
+			i := q.index(uint8(want[0]), uint8(want[1]), uint8(want[2]))
+			dst.Pix[d+x] = i
+
+			pc := q.palette[i].(color.RGBA)
+			got := [3]int32{int32(pc.R), int32(pc.G), int32(pc.B)}
+			for c := range 3 {
+				e := want[c] - got[c]
+				cur[x+2][c] += e * 7
+				next[x][c] += e * 3
+				next[x+1][c] += e * 5
+				next[x+2][c] += e
+			}
+		}
+		cur, next = next, cur
+		clear(next)
+	}
+	return dst
+}
+
+// clampUint8 clamps v to the range of a uint8.
+func clampUint8(v int32) int32 {
+	return max(0, min(v, 255))
+}
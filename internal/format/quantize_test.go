@@ -0,0 +1,210 @@
+package format
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestParseQuality(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		want    Quality
+		wantErr bool
+	}{
+		{"low", Quality{Colors: 64}, false},
+		{"MEDIUM", Quality{Colors: 256, Dither: true}, false},
+		{"high", Quality{Colors: 256, PerFrame: true, Dither: true}, false},
+		{"ultra", Quality{}, true},
+		{"", Quality{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseQuality(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseQuality(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseQuality(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuantizer_ExactForFewColors(t *testing.T) {
+	t.Parallel()
+
+	colors := []color.RGBA{
+		{R: 200, G: 30, B: 10, A: 255},
+		{R: 12, G: 180, B: 77, A: 255},
+		{R: 250, G: 250, B: 250, A: 255},
+		{R: 0, G: 0, B: 0, A: 255},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := range 16 {
+		for x := range 16 {
+			img.SetRGBA(x, y, colors[(x/4+y/4)%len(colors)])
+		}
+	}
+
+	q := newQuantizer([]*image.RGBA{img}, 256)
+	if len(q.palette) != len(colors) {
+		t.Errorf("palette size = %d, want %d", len(q.palette), len(colors))
+	}
+	for _, dither := range []bool{false, true} {
+		got := q.paletted(img, dither)
+		for y := range 16 {
+			for x := range 16 {
+				if !colorsEqual(got.At(x, y), img.At(x, y)) {
+					t.Fatalf("dither=%v: pixel (%d,%d) = %v, want %v", dither, x, y, got.At(x, y), img.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestQuantizer_RespectsColorLimit(t *testing.T) {
+	t.Parallel()
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	rng := rand.New(rand.NewPCG(3, 4))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.IntN(256))
+	}
+
+	for _, n := range []int{2, 16, 64, 256} {
+		if got := len(newQuantizer([]*image.RGBA{img}, n).palette); got != n {
+			t.Errorf("newQuantizer(noise, %d) palette size = %d", n, got)
+		}
+	}
+}
+
+func TestQuantizer_BeatsPlan9OnGradients(t *testing.T) {
+	t.Parallel()
+
+	// A smooth, narrow-hued gradient like a photo of fur: Plan9 spreads its
+	// colors across the whole cube and bands badly here.
+	img := image.NewRGBA(image.Rect(0, 0, 128, 64))
+	for y := range 64 {
+		for x := range 128 {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(90 + x/2), G: uint8(60 + x/3 + y/4), B: uint8(40 + y/2), A: 255})
+		}
+	}
+
+	q := newQuantizer([]*image.RGBA{img}, 256)
+	adaptive := q.paletted(img, false)
+	plan9 := image.NewPaletted(img.Bounds(), palette.Plan9)
+	for i := range plan9.Pix {
+		p := img.Pix[4*i:]
+		plan9.Pix[i] = uint8(color.Palette(palette.Plan9).Index(color.RGBA{R: p[0], G: p[1], B: p[2], A: 255}))
+	}
+
+	if a, p := squaredError(img, adaptive), squaredError(img, plan9); a >= p {
+		t.Errorf("adaptive error %d is not below Plan9 error %d", a, p)
+	}
+}
+
+func TestGIFEncoder_Palettes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		quality    Quality
+		wantGlobal bool
+		maxColors  int
+	}{
+		{"default", Quality{}, true, 256},
+		{"low", Quality{Colors: 64}, true, 64},
+		{"per frame", Quality{Colors: 256, PerFrame: true, Dither: true}, false, 256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			anim := testAnimation(3, 32, 24)
+			var buf bytes.Buffer
+			if err := (gifEncoder{}).Encode(&buf, anim, Options{Quality: tt.quality}); err != nil {
+				t.Fatalf("Encode() error: %v", err)
+			}
+			decoded, err := gif.DecodeAll(&buf)
+			if err != nil {
+				t.Fatalf("gif.DecodeAll() error: %v", err)
+			}
+
+			global, _ := decoded.Config.ColorModel.(color.Palette)
+			if hasGlobal := len(global) > 0; hasGlobal != tt.wantGlobal {
+				t.Errorf("global color table present = %v, want %v", hasGlobal, tt.wantGlobal)
+			}
+			for i, frame := range decoded.Image {
+				if len(frame.Palette) > tt.maxColors {
+					t.Errorf("frame %d has %d colors, want at most %d", i, len(frame.Palette), tt.maxColors)
+				}
+			}
+		})
+	}
+}
+
+func TestGIFEncoder_FrameDeltas(t *testing.T) {
+	t.Parallel()
+
+	anim := testAnimation(3, 32, 24)
+	// Repeat the last frame so one frame has no changes at all.
+	anim.Frames = append(anim.Frames, anim.Frames[2])
+	anim.Delays = append(anim.Delays, 8)
+
+	quality := Quality{Colors: 64}
+	var buf bytes.Buffer
+	if err := (gifEncoder{}).Encode(&buf, anim, Options{Quality: quality}); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	decoded, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("gif.DecodeAll() error: %v", err)
+	}
+
+	// Only the stripe columns differ between consecutive test frames.
+	wantBounds := []image.Rectangle{
+		image.Rect(0, 0, 32, 24),
+		image.Rect(0, 0, 8, 24),
+		image.Rect(4, 0, 12, 24),
+		image.Rect(0, 0, 1, 1),
+	}
+	for i, frame := range decoded.Image {
+		if frame.Bounds() != wantBounds[i] {
+			t.Errorf("frame %d bounds = %v, want %v", i, frame.Bounds(), wantBounds[i])
+		}
+		if decoded.Disposal[i] != gif.DisposalNone {
+			t.Errorf("frame %d disposal = %d, want DisposalNone", i, decoded.Disposal[i])
+		}
+	}
+
+	// Playing the deltas back must reproduce every fully quantized frame.
+	q := newQuantizer(anim.Frames, quality.Colors-1)
+	q.reserveTransparent()
+	canvas := image.NewRGBA(anim.Frames[0].Bounds())
+	for i, frame := range decoded.Image {
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		assertSamePixels(t, q.paletted(anim.Frames[i], false), canvas)
+	}
+}
+
+// squaredError sums the squared RGB difference between two images.
+func squaredError(a *image.RGBA, b image.Image) int {
+	var sum int
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca := a.RGBAAt(x, y)
+			cb := color.RGBAModel.Convert(b.At(x, y)).(color.RGBA)
+			dr, dg, db := int(ca.R)-int(cb.R), int(ca.G)-int(cb.G), int(ca.B)-int(cb.B)
+			sum += dr*dr + dg*dg + db*db
+		}
+	}
+	return sum
+}
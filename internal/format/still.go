@@ -0,0 +1,55 @@
+package format
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+)
+
+// jpegQuality is the quality used for JPEG output. Memes are noisy enough
+// already; there's no need to add blocking artifacts on top.
+const jpegQuality = 90
+
+// pngEncoder writes a single frame as a static PNG.
+type pngEncoder struct{}
+
+func (pngEncoder) Name() string        { return "png" }
+func (pngEncoder) ContentType() string { return "image/png" }
+func (pngEncoder) Extension() string   { return "png" }
+func (pngEncoder) Animated() bool      { return false }
+
+func (pngEncoder) Encode(w io.Writer, anim *meme.Animation, opts Options) error {
+	frame, err := selectFrame(anim, opts.Frame)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, frame)
+}
+
+// jpegEncoder writes a single frame as a JPEG.
+type jpegEncoder struct{}
+
+func (jpegEncoder) Name() string        { return "jpeg" }
+func (jpegEncoder) ContentType() string { return "image/jpeg" }
+func (jpegEncoder) Extension() string   { return "jpg" }
+func (jpegEncoder) Animated() bool      { return false }
+
+func (jpegEncoder) Encode(w io.Writer, anim *meme.Animation, opts Options) error {
+	frame, err := selectFrame(anim, opts.Frame)
+	if err != nil {
+		return err
+	}
+	return jpeg.Encode(w, frame, &jpeg.Options{Quality: jpegQuality})
+}
+
+// selectFrame returns frame i of anim, or an error if there is no such frame.
+func selectFrame(anim *meme.Animation, i int) (*image.RGBA, error) {
+	if i < 0 || i >= len(anim.Frames) {
+		return nil, fmt.Errorf("frame %d out of range [0, %d)", i, len(anim.Frames))
+	}
+	return anim.Frames[i], nil
+}
@@ -0,0 +1,651 @@
+package format
+
+import (
+	"container/heap"
+	"image"
+)
+
+// This file implements a small lossless VP8L (WebP) bitstream encoder. It
+// uses the subtract-green and predictor transforms, LZ77 back-references
+// limited to "same as the pixel to the left" and "same as the pixel above",
+// and a single set of Huffman codes per image. That is far from libwebp's
+// compression, but it is pure Go.
+//
+// The format is specified at
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+
+const (
+	vp8lSignature = 0x2f
+
+	vp8lNumLiterals  = 256
+	vp8lNumLengths   = 24
+	vp8lNumDistances = 40
+
+	vp8lMaxCodeLength       = 15
+	vp8lMaxCodeLengthLength = 7
+
+	// vp8lMinMatch is the shortest back-reference worth emitting; shorter
+	// runs are cheaper as literals.
+	vp8lMinMatch = 3
+	vp8lMaxMatch = 4096
+
+	// Plane codes for the two back-reference distances the encoder uses,
+	// from the distance map in section 4.2.2 of the specification.
+	vp8lPlaneCodeUp   = 1 // (0, 1): the pixel directly above
+	vp8lPlaneCodeLeft = 2 // (1, 0): the pixel to the left
+
+	vp8lTransformPredictor     = 0
+	vp8lTransformSubtractGreen = 2
+
+	// vp8lPredictorBits is the log2 tile size of the predictor transform.
+	vp8lPredictorBits = 5
+	vp8lNumPredictors = 14
+)
+
+// vp8lCodeLengthOrder is the order in which code-length code lengths are
+// written.
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// bitWriter packs values least-significant bit first, as VP8L requires.
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	nacc uint
+}
+
+// write appends the low n bits of v. n must be at most 32.
+func (b *bitWriter) write(v uint32, n uint) {
+	b.acc |= uint64(v) << b.nacc
+	b.nacc += n
+	for b.nacc >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nacc -= 8
+	}
+}
+
+// bytes flushes any partial byte and returns the written data.
+func (b *bitWriter) bytes() []byte {
+	if b.nacc > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.nacc = 0, 0
+	}
+	return b.buf
+}
+
+// vp8lToken is one unit of the entropy-coded image: either a literal ARGB
+// pixel or a back-reference of length pixels at a plane-coded distance.
+type vp8lToken struct {
+	argb      uint32
+	length    int
+	planeCode int
+}
+
+// encodeVP8L returns the VP8L bitstream for img, suitable for the payload of
+// a "VP8L" RIFF chunk.
+func encodeVP8L(img *image.RGBA) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	argb := make([]uint32, 0, w*h)
+	hasAlpha := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x := range w {
+			r, g, bl, a := uint32(row[4*x]), uint32(row[4*x+1]), uint32(row[4*x+2]), uint32(row[4*x+3])
+			// VP8L stores straight alpha; image.RGBA is premultiplied.
+			if a != 0 && a != 0xff {
+				r, g, bl = r*0xff/a, g*0xff/a, bl*0xff/a
+			}
+			if a != 0xff {
+				hasAlpha = true
+			}
+			// Subtract-green transform.
+			r, bl = (r-g)&0xff, (bl-g)&0xff
+			argb = append(argb, a<<24|r<<16|g<<8|bl)
+		}
+	}
+
+	var bw bitWriter
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+
+	// Transforms are applied by the encoder in the order they are written
+	// and undone by the decoder in reverse.
+	bw.write(1, 1)
+	bw.write(vp8lTransformSubtractGreen, 2)
+
+	modes, residuals := vp8lPredict(argb, w, h)
+	bw.write(1, 1)
+	bw.write(vp8lTransformPredictor, 2)
+	bw.write(vp8lPredictorBits-2, 3)
+	writeVP8LImage(&bw, modes, vp8lTiles(w), false)
+
+	bw.write(0, 1) // no more transforms
+
+	writeVP8LImage(&bw, residuals, w, true)
+	return bw.bytes()
+}
+
+// writeVP8LImage entropy-codes an ARGB image of width w. Only the main image
+// (topLevel) carries the meta Huffman bit; transform sub-images don't.
+func writeVP8LImage(bw *bitWriter, argb []uint32, w int, topLevel bool) {
+	tokens := vp8lTokenize(argb, w)
+
+	bw.write(0, 1) // no color cache
+	if topLevel {
+		bw.write(0, 1) // no meta Huffman image
+	}
+
+	// Histogram every alphabet, then build and write the five codes.
+	var (
+		green    = make([]int, vp8lNumLiterals+vp8lNumLengths)
+		red      = make([]int, vp8lNumLiterals)
+		blue     = make([]int, vp8lNumLiterals)
+		alpha    = make([]int, vp8lNumLiterals)
+		distance = make([]int, vp8lNumDistances)
+	)
+	for _, t := range tokens {
+		if t.length == 0 {
+			green[t.argb>>8&0xff]++
+			red[t.argb>>16&0xff]++
+			blue[t.argb&0xff]++
+			alpha[t.argb>>24]++
+			continue
+		}
+		lenSym, _, _ := vp8lPrefix(t.length)
+		green[vp8lNumLiterals+lenSym]++
+		distSym, _, _ := vp8lPrefix(t.planeCode)
+		distance[distSym]++
+	}
+
+	codes := [5]huffmanCode{}
+	for i, hist := range [][]int{green, red, blue, alpha, distance} {
+		codes[i] = newHuffmanCode(hist, vp8lMaxCodeLength)
+		codes[i].writeTo(bw)
+	}
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].writeSymbol(bw, int(t.argb>>8&0xff))
+			codes[1].writeSymbol(bw, int(t.argb>>16&0xff))
+			codes[2].writeSymbol(bw, int(t.argb&0xff))
+			codes[3].writeSymbol(bw, int(t.argb>>24))
+			continue
+		}
+		lenSym, lenExtraBits, lenExtra := vp8lPrefix(t.length)
+		codes[0].writeSymbol(bw, vp8lNumLiterals+lenSym)
+		bw.write(lenExtra, lenExtraBits)
+		distSym, distExtraBits, distExtra := vp8lPrefix(t.planeCode)
+		codes[4].writeSymbol(bw, distSym)
+		bw.write(distExtra, distExtraBits)
+	}
+}
+
+// vp8lTiles returns the number of predictor tiles covering size pixels.
+func vp8lTiles(size int) int {
+	return (size + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits
+}
+
+// vp8lPredict applies the predictor transform. For each tile it picks the
+// prediction mode with the smallest residuals, and returns the mode image
+// (mode in the green channel) along with the residual image.
+func vp8lPredict(argb []uint32, w, h int) (modes, residuals []uint32) {
+	tw, th := vp8lTiles(w), vp8lTiles(h)
+	modes = make([]uint32, tw*th)
+	residuals = make([]uint32, len(argb))
+
+	for ty := range th {
+		for tx := range tw {
+			x0, y0 := tx<<vp8lPredictorBits, ty<<vp8lPredictorBits
+			x1, y1 := min(x0+1<<vp8lPredictorBits, w), min(y0+1<<vp8lPredictorBits, h)
+
+			best, bestCost := 0, -1
+			for mode := range vp8lNumPredictors {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						p := y*w + x
+						cost += residualCost(subPixels(argb[p], vp8lPrediction(argb, w, x, y, mode)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tw+tx] = 0xff000000 | uint32(best)<<8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					p := y*w + x
+					residuals[p] = subPixels(argb[p], vp8lPrediction(argb, w, x, y, best))
+				}
+			}
+		}
+	}
+	return modes, residuals
+}
+
+// vp8lPrediction predicts the pixel at (x, y) with the given mode. The first
+// row and column use fixed modes regardless of the tile's mode, as the
+// specification requires.
+func vp8lPrediction(argb []uint32, w, x, y, mode int) uint32 {
+	p := y*w + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[p-1]
+	case x == 0:
+		return argb[p-w]
+	}
+
+	// For the rightmost column, p-w+1 wraps to the leftmost pixel of the
+	// current row, which is exactly what the specification asks for.
+	l, t, tl, tr := argb[p-1], argb[p-w], argb[p-w-1], argb[p-w+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return avgPixels(avgPixels(l, tr), t)
+	case 6:
+		return avgPixels(l, tl)
+	case 7:
+		return avgPixels(l, t)
+	case 8:
+		return avgPixels(tl, t)
+	case 9:
+		return avgPixels(t, tr)
+	case 10:
+		return avgPixels(avgPixels(l, tl), avgPixels(t, tr))
+	case 11:
+		return selectPixel(l, t, tl)
+	case 12:
+		return mapChannels3(l, t, tl, func(a, b, c int) int { return a + b - c })
+	default:
+		return mapChannels3(avgPixels(l, t), tl, 0, func(a, b, _ int) int { return a + (a-b)/2 })
+	}
+}
+
+// avgPixels averages two pixels channel by channel, rounding down.
+func avgPixels(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+// selectPixel implements the Select predictor: whichever of l and t is
+// closer to the gradient estimate l + t - tl.
+func selectPixel(l, t, tl uint32) uint32 {
+	var distL, distT int
+	for shift := 0; shift < 32; shift += 8 {
+		cl, ct, ctl := int(l>>shift&0xff), int(t>>shift&0xff), int(tl>>shift&0xff)
+		distL += absInt(ctl - ct)
+		distT += absInt(ctl - cl)
+	}
+	if distL < distT {
+		return l
+	}
+	return t
+}
+
+// mapChannels3 applies f to each channel of a, b and c, clamping the result
+// to [0, 255].
+func mapChannels3(a, b, c uint32, f func(a, b, c int) int) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		v := f(int(a>>shift&0xff), int(b>>shift&0xff), int(c>>shift&0xff))
+		out |= uint32(min(max(v, 0), 255)) << shift
+	}
+	return out
+}
+
+// subPixels subtracts b from a channel by channel, modulo 256.
+func subPixels(a, b uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		out |= ((a>>shift - b>>shift) & 0xff) << shift
+	}
+	return out
+}
+
+// residualCost estimates how expensive a residual is to code: small values
+// in either direction are cheap.
+func residualCost(res uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		cost += absInt8(byte(res >> shift))
+	}
+	return cost
+}
+
+// vp8lTokenize greedily replaces runs of pixels that repeat the pixel to the
+// left or the row above with back-references.
+func vp8lTokenize(argb []uint32, w int) []vp8lToken {
+	var tokens []vp8lToken
+	for p := 0; p < len(argb); {
+		bestLen, bestCode := 0, 0
+		if p >= 1 {
+			if n := matchLength(argb, p, 1); n > bestLen {
+				bestLen, bestCode = n, vp8lPlaneCodeLeft
+			}
+		}
+		if p >= w {
+			if n := matchLength(argb, p, w); n > bestLen {
+				bestLen, bestCode = n, vp8lPlaneCodeUp
+			}
+		}
+		if bestLen >= vp8lMinMatch {
+			tokens = append(tokens, vp8lToken{length: bestLen, planeCode: bestCode})
+			p += bestLen
+			continue
+		}
+		tokens = append(tokens, vp8lToken{argb: argb[p]})
+		p++
+	}
+	return tokens
+}
+
+// matchLength returns how many pixels starting at p repeat the pixels dist
+// positions earlier, up to vp8lMaxMatch.
+func matchLength(argb []uint32, p, dist int) int {
+	n := 0
+	for p+n < len(argb) && n < vp8lMaxMatch && argb[p+n] == argb[p+n-dist] {
+		n++
+	}
+	return n
+}
+
+// vp8lPrefix splits an LZ77 length or distance value (>= 1) into a prefix
+// symbol and extra bits, inverting section 5.2.2 of the specification.
+func vp8lPrefix(v int) (symbol int, extraBits uint, extra uint32) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	hi := 0
+	for d>>(hi+1) != 0 {
+		hi++
+	}
+	second := (d >> (hi - 1)) & 1
+	extraBits = uint(hi - 1)
+	return 2*hi + second, extraBits, uint32(d) & (1<<extraBits - 1)
+}
+
+// huffmanCode is a canonical Huffman code over a fixed-size alphabet.
+type huffmanCode struct {
+	lengths []uint8
+	codes   []uint16 // bit-reversed, ready for an LSB-first writer
+
+	// simple holds the symbols of a one- or two-symbol code that can use
+	// VP8L's compact "simple" encoding.
+	simple []int
+}
+
+// newHuffmanCode builds a length-limited Huffman code for the histogram.
+func newHuffmanCode(hist []int, maxLen int) huffmanCode {
+	var used []int
+	for sym, n := range hist {
+		if n > 0 {
+			used = append(used, sym)
+		}
+	}
+
+	hc := huffmanCode{
+		lengths: make([]uint8, len(hist)),
+		codes:   make([]uint16, len(hist)),
+	}
+
+	switch {
+	case len(used) == 0:
+		// Nothing is ever coded; a single zero-bit symbol keeps decoders happy.
+		hc.simple = []int{0}
+		return hc
+	case len(used) == 1 && used[0] < 256:
+		// A one-symbol simple code reads zero bits per symbol.
+		hc.simple = used
+		return hc
+	case len(used) == 1:
+		// Pair the symbol with a dummy so the code is complete.
+		dummy := 0
+		if used[0] == 0 {
+			dummy = 1
+		}
+		hc.lengths[used[0]], hc.lengths[dummy] = 1, 1
+	case len(used) == 2 && used[1] < 256:
+		hc.simple = used
+		hc.lengths[used[0]], hc.lengths[used[1]] = 1, 1
+	default:
+		hc.lengths = huffmanLengths(hist, maxLen)
+	}
+
+	hc.assignCodes()
+	return hc
+}
+
+// assignCodes derives canonical, bit-reversed codes from the code lengths.
+func (hc *huffmanCode) assignCodes() {
+	var count [vp8lMaxCodeLength + 1]int
+	for _, l := range hc.lengths {
+		count[l]++
+	}
+	count[0] = 0
+
+	var next [vp8lMaxCodeLength + 1]int
+	code := 0
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	for sym, l := range hc.lengths {
+		if l == 0 {
+			continue
+		}
+		hc.codes[sym] = reverseBits(uint16(next[l]), l)
+		next[l]++
+	}
+}
+
+// reverseBits reverses the low n bits of v.
+func reverseBits(v uint16, n uint8) uint16 {
+	var r uint16
+	for range n {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+// writeSymbol writes the code for sym.
+func (hc *huffmanCode) writeSymbol(bw *bitWriter, sym int) {
+	bw.write(uint32(hc.codes[sym]), uint(hc.lengths[sym]))
+}
+
+// writeTo writes the code's description to the bitstream.
+func (hc *huffmanCode) writeTo(bw *bitWriter) {
+	if hc.simple != nil {
+		bw.write(1, 1) // simple code
+		bw.write(uint32(len(hc.simple)-1), 1)
+		if hc.simple[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(hc.simple[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(hc.simple[0]), 8)
+		}
+		if len(hc.simple) == 2 {
+			bw.write(uint32(hc.simple[1]), 8)
+		}
+		return
+	}
+
+	bw.write(0, 1) // normal code
+
+	// Run-length encode the code lengths with the repeat symbols 16-18.
+	type clToken struct {
+		sym   int
+		extra uint32
+	}
+	var tokens []clToken
+	lengths := hc.lengths
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+
+		if l == 0 {
+			for run > 0 {
+				switch {
+				case run >= 11:
+					n := min(run, 138)
+					tokens = append(tokens, clToken{18, uint32(n - 11)})
+					run -= n
+				case run >= 3:
+					tokens = append(tokens, clToken{17, uint32(run - 3)})
+					run = 0
+				default:
+					tokens = append(tokens, clToken{0, 0})
+					run--
+				}
+			}
+			continue
+		}
+
+		tokens = append(tokens, clToken{int(l), 0})
+		run--
+		for run > 0 {
+			if run < 3 {
+				tokens = append(tokens, clToken{int(l), 0})
+				run--
+				continue
+			}
+			n := min(run, 6)
+			tokens = append(tokens, clToken{16, uint32(n - 3)})
+			run -= n
+		}
+	}
+
+	hist := make([]int, 19)
+	for _, t := range tokens {
+		hist[t.sym]++
+	}
+	clCode := newHuffmanCode(hist, vp8lMaxCodeLengthLength)
+	if len(clCode.simple) == 1 {
+		// The code-length code is always written in normal form, so a lone
+		// symbol is paired with an unused dummy to make the code complete.
+		sym, dummy := clCode.simple[0], 0
+		if sym == 0 {
+			dummy = 1
+		}
+		clCode.lengths[sym], clCode.lengths[dummy] = 1, 1
+		clCode.assignCodes()
+	}
+
+	numCodes := 4
+	for i, sym := range vp8lCodeLengthOrder {
+		if clCode.lengths[sym] != 0 {
+			numCodes = max(numCodes, i+1)
+		}
+	}
+	bw.write(uint32(numCodes-4), 4)
+	for _, sym := range vp8lCodeLengthOrder[:numCodes] {
+		bw.write(uint32(clCode.lengths[sym]), 3)
+	}
+
+	bw.write(0, 1) // code lengths cover the whole alphabet
+
+	for _, t := range tokens {
+		clCode.writeSymbol(bw, t.sym)
+		switch t.sym {
+		case 16:
+			bw.write(t.extra, 2)
+		case 17:
+			bw.write(t.extra, 3)
+		case 18:
+			bw.write(t.extra, 7)
+		}
+	}
+}
+
+// huffmanNode is a node in the Huffman construction heap.
+type huffmanNode struct {
+	weight      int
+	symbol      int // -1 for internal nodes
+	left, right *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].weight != h[j].weight {
+		return h[i].weight < h[j].weight
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)   { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths returns Huffman code lengths for a histogram with at least
+// two used symbols, limited to maxLen bits. When the optimal tree is too
+// deep, small counts are raised and the tree rebuilt, as libwebp does.
+func huffmanLengths(hist []int, maxLen int) []uint8 {
+	lengths := make([]uint8, len(hist))
+	for countMin := 1; ; countMin *= 2 {
+		h := &huffmanHeap{}
+		for sym, n := range hist {
+			if n > 0 {
+				*h = append(*h, &huffmanNode{weight: max(n, countMin), symbol: sym})
+			}
+		}
+		heap.Init(h)
+		for h.Len() > 1 {
+			a := heap.Pop(h).(*huffmanNode)
+			b := heap.Pop(h).(*huffmanNode)
+			heap.Push(h, &huffmanNode{weight: a.weight + b.weight, symbol: -1, left: a, right: b})
+		}
+
+		clear(lengths)
+		tooDeep := false
+		var walk func(n *huffmanNode, depth int)
+		walk = func(n *huffmanNode, depth int) {
+			if n.symbol >= 0 {
+				if depth > maxLen {
+					tooDeep = true
+				}
+				lengths[n.symbol] = uint8(depth)
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk((*h)[0], 0)
+
+		if !tooDeep {
+			return lengths
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package format
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+)
+
+// VP8X feature flags.
+const (
+	webpFlagAnimation = 1 << 1
+	webpFlagAlpha     = 1 << 4
+)
+
+// webpEncoder writes an infinitely looping, losslessly compressed animated
+// WebP. A single-frame animation is written as a plain still WebP.
+type webpEncoder struct{}
+
+func (webpEncoder) Name() string        { return "webp" }
+func (webpEncoder) ContentType() string { return "image/webp" }
+func (webpEncoder) Extension() string   { return "webp" }
+func (webpEncoder) Animated() bool      { return true }
+
+func (webpEncoder) Encode(w io.Writer, anim *meme.Animation, _ Options) error {
+	if len(anim.Frames) == 0 {
+		return errors.New("webp: animation has no frames")
+	}
+
+	bounds := anim.Frames[0].Bounds()
+	if bounds.Dx() > 1<<14 || bounds.Dy() > 1<<14 {
+		return errors.New("webp: frames larger than 16384 pixels are not supported")
+	}
+
+	if len(anim.Frames) == 1 {
+		return writeRIFF(w, riffChunk("VP8L", encodeVP8L(anim.Frames[0])))
+	}
+
+	flags := byte(webpFlagAnimation)
+	for _, frame := range anim.Frames {
+		if !frame.Opaque() {
+			flags |= webpFlagAlpha
+			break
+		}
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = flags
+	putUint24(vp8x[4:], uint32(bounds.Dx()-1))
+	putUint24(vp8x[7:], uint32(bounds.Dy()-1))
+
+	// Background color (unused by browsers, which show transparency) and a
+	// loop count of zero, meaning forever.
+	animChunk := make([]byte, 6)
+
+	chunks := [][]byte{riffChunk("VP8X", vp8x), riffChunk("ANIM", animChunk)}
+	for i, frame := range anim.Frames {
+		fb := frame.Bounds()
+		if fb.Size() != bounds.Size() {
+			return errors.New("webp: frames differ in size")
+		}
+
+		header := make([]byte, 16)
+		// Frame x and y offsets stay zero: every frame covers the canvas.
+		putUint24(header[6:], uint32(fb.Dx()-1))
+		putUint24(header[9:], uint32(fb.Dy()-1))
+		putUint24(header[12:], uint32(anim.Delays[i]*10)) // milliseconds
+		// Do not blend with the previous frame; do not dispose.
+		header[15] = 1 << 1
+
+		payload := append(header, riffChunk("VP8L", encodeVP8L(frame))...)
+		chunks = append(chunks, riffChunk("ANMF", payload))
+	}
+
+	return writeRIFF(w, chunks...)
+}
+
+// riffChunk returns a RIFF chunk with the given FourCC, padded to an even
+// length.
+func riffChunk(fourCC string, data []byte) []byte {
+	chunk := make([]byte, 8, 8+len(data)+1)
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// writeRIFF writes a RIFF WEBP container holding chunks.
+func writeRIFF(w io.Writer, chunks ...[]byte) error {
+	size := 4 // "WEBP"
+	for _, c := range chunks {
+		size += len(c)
+	}
+
+	header := make([]byte, 12)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(size))
+	copy(header[8:], "WEBP")
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if _, err := w.Write(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putUint24 writes v as a 24-bit little-endian integer.
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
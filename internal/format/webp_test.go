@@ -0,0 +1,165 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// assertSamePixels fails the test if got and want differ anywhere.
+func assertSamePixels(t *testing.T, got image.Image, want *image.RGBA) {
+	t.Helper()
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("bounds = %v, want %v", got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !colorsEqual(got.At(x, y), want.At(x, y)) {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got.At(x, y), want.At(x, y))
+			}
+		}
+	}
+}
+
+func TestEncodeVP8L_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	noise := image.NewRGBA(image.Rect(0, 0, 37, 29))
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range noise.Pix {
+		noise.Pix[i] = uint8(rng.IntN(256))
+	}
+	for i := 3; i < len(noise.Pix); i += 4 {
+		noise.Pix[i] = 0xff
+	}
+
+	translucent := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for y := range 10 {
+		for x := range 20 {
+			a := uint8(x * 12)
+			translucent.SetRGBA(x, y, color.RGBA{R: a / 2, G: a / 3, B: a, A: a})
+		}
+	}
+
+	solid := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range solid.Pix {
+		solid.Pix[i] = 0xff
+	}
+
+	tests := map[string]*image.RGBA{
+		"gradient frame": testAnimation(1, 48, 40).Frames[0],
+		"noise":          noise,
+		"translucent":    translucent,
+		"solid":          solid,
+		"single pixel":   image.NewRGBA(image.Rect(0, 0, 1, 1)),
+	}
+	for name, img := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			if err := writeRIFF(&buf, riffChunk("VP8L", encodeVP8L(img))); err != nil {
+				t.Fatalf("writeRIFF() error: %v", err)
+			}
+			decoded, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("webp.Decode() error: %v", err)
+			}
+			assertSamePixels(t, decoded, img)
+		})
+	}
+}
+
+func TestVP8LPrefix(t *testing.T) {
+	t.Parallel()
+
+	// Invert the decoder's formula for every value the encoder can emit.
+	for v := 1; v <= vp8lMaxMatch; v++ {
+		sym, extraBits, extra := vp8lPrefix(v)
+		got := sym + 1
+		if sym >= 4 {
+			eb := (sym - 2) >> 1
+			if uint(eb) != extraBits {
+				t.Fatalf("vp8lPrefix(%d) extra bits = %d, want %d", v, extraBits, eb)
+			}
+			got = (2+sym&1)<<eb + int(extra) + 1
+		}
+		if got != v {
+			t.Fatalf("vp8lPrefix(%d) decodes to %d", v, got)
+		}
+	}
+}
+
+func TestWebPEncoder_Animated(t *testing.T) {
+	t.Parallel()
+
+	anim := testAnimation(3, 32, 24)
+	var buf bytes.Buffer
+	if err := (webpEncoder{}).Encode(&buf, anim, Options{}); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	data := buf.Bytes()
+
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatalf("missing RIFF/WEBP header")
+	}
+	if got := int(binary.LittleEndian.Uint32(data[4:])); got != len(data)-8 {
+		t.Errorf("RIFF size = %d, want %d", got, len(data)-8)
+	}
+
+	var frames []image.Image
+	for p := 12; p+8 <= len(data); {
+		fourCC := string(data[p : p+4])
+		n := int(binary.LittleEndian.Uint32(data[p+4:]))
+		payload := data[p+8 : p+8+n]
+		switch fourCC {
+		case "VP8X":
+			if payload[0]&webpFlagAnimation == 0 {
+				t.Error("VP8X chunk lacks the animation flag")
+			}
+		case "ANMF":
+			if d := int(payload[12]) | int(payload[13])<<8 | int(payload[14])<<16; d != 80 {
+				t.Errorf("frame duration = %dms, want 80", d)
+			}
+			// Re-wrap the frame's VP8L bitstream as a still WebP to decode it.
+			var still bytes.Buffer
+			if err := writeRIFF(&still, payload[16:]); err != nil {
+				t.Fatalf("writeRIFF() error: %v", err)
+			}
+			img, err := webp.Decode(&still)
+			if err != nil {
+				t.Fatalf("decoding frame %d: %v", len(frames), err)
+			}
+			frames = append(frames, img)
+		}
+		p += 8 + n + n%2
+	}
+
+	if len(frames) != len(anim.Frames) {
+		t.Fatalf("decoded %d frames, want %d", len(frames), len(anim.Frames))
+	}
+	for i, img := range frames {
+		assertSamePixels(t, img, anim.Frames[i])
+	}
+}
+
+func TestWebPEncoder_SingleFrameIsStill(t *testing.T) {
+	t.Parallel()
+
+	anim := testAnimation(1, 16, 16)
+	var buf bytes.Buffer
+	if err := (webpEncoder{}).Encode(&buf, anim, Options{}); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	img, err := webp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("webp.Decode() error: %v", err)
+	}
+	assertSamePixels(t, img, anim.Frames[0])
+}
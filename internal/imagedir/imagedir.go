@@ -0,0 +1,164 @@
+// Package imagedir indexes a local directory of images so that potatoes and
+// cats can be served without network access.
+package imagedir
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+)
+
+// ErrNoImages is returned when a scan finds no matching images.
+var ErrNoImages = errors.New("no images found")
+
+// ErrUnknownFile is returned by Read for paths that are not in the index.
+var ErrUnknownFile = errors.New("file not in image directory")
+
+// extensions maps file extensions to format names as used by the image
+// package.
+var extensions = map[string]string{
+	".png":  "png",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".gif":  "gif",
+	".webp": "webp",
+}
+
+// Dir is an index of the image files in a directory. It is safe for
+// concurrent use, including while it reloads.
+type Dir struct {
+	root      string
+	recursive bool
+	formats   []string
+
+	mu    sync.RWMutex
+	files []string
+	index map[string]bool
+}
+
+// Option configures a Dir.
+type Option func(*Dir)
+
+// WithRecursive makes the scan descend into subdirectories.
+func WithRecursive(recursive bool) Option {
+	return func(d *Dir) { d.recursive = recursive }
+}
+
+// WithFormats limits the index to the given formats, named as by the image
+// package. The default is decode.DefaultFormats.
+func WithFormats(formats ...string) Option {
+	return func(d *Dir) { d.formats = formats }
+}
+
+// New scans root and returns its index. It fails if root can't be read or
+// holds no matching images.
+func New(root string, opts ...Option) (*Dir, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolving image directory: %w", err)
+	}
+	d := &Dir{root: abs, formats: decode.DefaultFormats}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Root returns the absolute path of the directory.
+func (d *Dir) Root() string {
+	return d.root
+}
+
+// Reload rescans the directory. If the scan fails or finds nothing, the
+// previous index is kept and the error returned.
+func (d *Dir) Reload() error {
+	var files []string
+	err := filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != d.root && !d.recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		if format, ok := extensions[strings.ToLower(filepath.Ext(path))]; ok && slices.Contains(d.formats, format) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scanning %s: %w", d.root, err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("scanning %s: %w", d.root, ErrNoImages)
+	}
+
+	index := make(map[string]bool, len(files))
+	for _, f := range files {
+		index[f] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.files = files
+	d.index = index
+	return nil
+}
+
+// Len returns the number of indexed images.
+func (d *Dir) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.files)
+}
+
+// Files returns the paths of the indexed images, ordered by path. The slice
+// is shared and must not be modified.
+func (d *Dir) Files() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.files
+}
+
+// Pick returns the path of an image chosen with rng. Files are ordered by
+// path, so a seeded rng picks the same file from an unchanged directory.
+func (d *Dir) Pick(rng *rand.Rand) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.files[rng.IntN(len(d.files))]
+}
+
+// Read returns the contents of an indexed image. Paths outside the index are
+// refused, so callers may pass paths they did not pick themselves. Errors
+// name the file relative to the directory, so that they don't reveal where
+// the directory is.
+func (d *Dir) Read(path string) ([]byte, error) {
+	d.mu.RLock()
+	known := d.index[path]
+	d.mu.RUnlock()
+	if !known {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFile, filepath.Base(path))
+	}
+	data, err := os.ReadFile(path)
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		pe.Path, _ = filepath.Rel(d.root, path)
+	}
+	return data, err
+}
@@ -0,0 +1,163 @@
+package imagedir
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the named files under root with placeholder contents.
+func writeFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// names returns the indexed files relative to the root.
+func names(t *testing.T, d *Dir) []string {
+	t.Helper()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var rel []string
+	for _, f := range d.files {
+		r, err := filepath.Rel(d.root, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	return rel
+}
+
+func TestNew_Scan(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFiles(t, root, "a.png", "b.JPG", "c.jpeg", "d.gif", "e.webp", "notes.txt", "sub/f.png", "sub/deeper/g.gif")
+
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{"top level only", nil, []string{"a.png", "b.JPG", "c.jpeg", "d.gif", "e.webp"}},
+		{"recursive", []Option{WithRecursive(true)}, []string{"a.png", "b.JPG", "c.jpeg", "d.gif", "e.webp", "sub/deeper/g.gif", "sub/f.png"}},
+		{"format filter", []Option{WithFormats("jpeg")}, []string{"b.JPG", "c.jpeg"}},
+		{"recursive format filter", []Option{WithRecursive(true), WithFormats("gif")}, []string{"d.gif", "sub/deeper/g.gif"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := New(root, tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+			if got := names(t, d); !slices.Equal(got, tt.want) {
+				t.Errorf("indexed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	t.Parallel()
+
+	empty := t.TempDir()
+	writeFiles(t, empty, "readme.txt", "sub/a.png")
+
+	if _, err := New(empty); !errors.Is(err, ErrNoImages) {
+		t.Errorf("New(no images) error = %v, want ErrNoImages", err)
+	}
+	if _, err := New(filepath.Join(empty, "missing")); err == nil {
+		t.Error("New(missing dir) should fail")
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFiles(t, root, "a.png")
+	d, err := New(root)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	writeFiles(t, root, "b.png")
+	if err := d.Reload(); err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	if got := names(t, d); !slices.Equal(got, []string{"a.png", "b.png"}) {
+		t.Fatalf("after adding: indexed %v", got)
+	}
+
+	// An emptied directory keeps the previous index.
+	for _, n := range []string{"a.png", "b.png"} {
+		os.Remove(filepath.Join(root, n))
+	}
+	if err := d.Reload(); !errors.Is(err, ErrNoImages) {
+		t.Fatalf("Reload() of empty dir error = %v, want ErrNoImages", err)
+	}
+	if d.Len() != 2 {
+		t.Errorf("Len() = %d after failed reload, want 2", d.Len())
+	}
+}
+
+func TestPickAndRead(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFiles(t, root, "a.png", "b.png", "c.png", "secret.txt")
+	d, err := New(root)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	for seed := range uint64(5) {
+		first := d.Pick(rand.New(rand.NewPCG(seed, seed)))
+		second := d.Pick(rand.New(rand.NewPCG(seed, seed)))
+		if first != second {
+			t.Errorf("seed %d: picked %q then %q", seed, first, second)
+		}
+		data, err := d.Read(first)
+		if err != nil {
+			t.Fatalf("Read(%q) error: %v", first, err)
+		}
+		if string(data) != filepath.Base(first) {
+			t.Errorf("Read(%q) = %q", first, data)
+		}
+	}
+
+	for _, path := range []string{
+		filepath.Join(root, "secret.txt"),
+		root + "/sub/../a.png",
+		"/etc/passwd",
+	} {
+		if _, err := d.Read(path); !errors.Is(err, ErrUnknownFile) {
+			t.Errorf("Read(%q) error = %v, want ErrUnknownFile", path, err)
+		}
+	}
+
+	// Errors don't reveal where the directory is.
+	gone := filepath.Join(root, "c.png")
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{gone, filepath.Join(root, "secret.txt")} {
+		if _, err := d.Read(path); err == nil || strings.Contains(err.Error(), root) {
+			t.Errorf("Read(%q) error = %v, want an error without the directory", path, err)
+		}
+	}
+}
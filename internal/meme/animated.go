@@ -0,0 +1,66 @@
+package meme
+
+import (
+	"image"
+	"math"
+	"slices"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+)
+
+// scaledFrames returns img resized by scale for each of n frames shown for
+// delay each. A still image is scaled once and repeated. An animated one is
+// played in sync with the meme's loop; see syncFrames.
+func scaledFrames(img image.Image, n, delay int, scale func(image.Image) *image.RGBA) []*image.RGBA {
+	a, ok := img.(*decode.Animation)
+	if !ok {
+		return slices.Repeat([]*image.RGBA{scale(img)}, n)
+	}
+
+	schedule := syncFrames(a.Delays(), n, delay)
+	last := slices.Max(schedule)
+	scaled := make(map[int]*image.RGBA)
+	for i, frame := range a.Frames() {
+		if slices.Contains(schedule, i) {
+			scaled[i] = scale(frame)
+		}
+		if i == last {
+			break
+		}
+	}
+
+	frames := make([]*image.RGBA, n)
+	for i, src := range schedule {
+		frames[i] = scaled[src]
+	}
+	return frames
+}
+
+// syncFrames returns which source frame, shown for delays, is on screen at
+// each of n frames shown for delay each. The source animation is played a
+// whole number of times per loop, as close to its own speed as that allows,
+// so the two loops restart together.
+func syncFrames(delays []int, n, delay int) []int {
+	var total int
+	for _, d := range delays {
+		total += d
+	}
+	loop := n * delay
+	plays := max(1, int(math.Round(float64(loop)/float64(total))))
+	speed := float64(plays*total) / float64(loop)
+
+	schedule := make([]int, n)
+	for i := range schedule {
+		at := math.Mod(float64(i*delay)*speed, float64(total))
+		schedule[i] = len(delays) - 1
+		var end float64
+		for j, d := range delays {
+			end += float64(d)
+			if at < end {
+				schedule[i] = j
+				break
+			}
+		}
+	}
+	return schedule
+}
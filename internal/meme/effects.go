@@ -6,9 +6,11 @@ import (
 	"math/rand/v2"
 )
 
+// DefaultFrames and DefaultFrameDelay are the frame count and delay used when
+// Options leaves them zero.
 const (
-	TotalFrames = 16
-	FrameDelay  = 8 // centiseconds (80ms per frame ≈ 12.5fps, ~1.9s loop)
+	DefaultFrames     = 16
+	DefaultFrameDelay = 8 // centiseconds (80ms per frame ≈ 12.5fps, ~1.9s loop)
 )
 
 // Sparkle represents a single sparkle overlay.
@@ -21,7 +23,7 @@ type Sparkle struct {
 // PotatoClone represents a smaller potato copy bouncing independently.
 type PotatoClone struct {
 	X, Y     int     // position
-	Scale    float64 // size multiplier (0.15-0.3)
+	Scale    float64 // width as a fraction of the default canvas width (0.15-0.25)
 	Rotation float64 // rotation in radians
 	BounceY  int     // vertical bounce offset
 }
@@ -31,8 +33,8 @@ type ComicBurst struct {
 	X, Y     int
 	Text     string
 	Rotation float64
-	Scale    float64
-	Visible  bool // only show on some frames for flashing effect
+	Scale    float64 // size multiplier at the default canvas size (0.7-1.3)
+	Visible  bool    // only show on some frames for flashing effect
 }
 
 // FrameParams holds all computed animation values for a single frame.
@@ -72,87 +74,132 @@ type FrameParams struct {
 
 var burstWords = []string{"SPUD!", "WOW!", "TATER!", "POW!", "NICE!", "EPIC!", "YEET!", "BRUH!", "OMG!", "SPICY!"}
 
-// ComputeFrameParams calculates animation parameters for a given frame.
-func ComputeFrameParams(frame, totalFrames, canvasW, canvasH int) FrameParams {
+// Chaos holds how strongly ComputeFrameParams animates a meme. The zero
+// value animates nothing. Distances are in pixels of the default canvas and
+// scale with the canvas.
+type Chaos struct {
+	// Rainbow cycles the text through every hue; otherwise it is white.
+	Rainbow bool
+	// TextPulse is how far the font size swings either way, as a fraction
+	// of its size.
+	TextPulse float64
+	// Bounce is how high the potato bounces, in pixels.
+	Bounce float64
+	// Wobble is how far the potato rotates either way, in radians.
+	Wobble float64
+	// Shake is the largest screen shake offset, in pixels.
+	Shake int
+	// Sparkles is the fewest sparkles on a frame. Up to a third more are
+	// added at random.
+	Sparkles int
+	// Clones is the number of potato clones.
+	Clones int
+	// Bursts is the number of comic bursts.
+	Bursts int
+	// Glow is the average opacity of the glow behind the potato. It pulses
+	// by half as much either way.
+	Glow float64
+	// Zoom is how far the background zooms in, as a fraction of its size,
+	// at the middle of its pulse.
+	Zoom float64
+}
+
+// ComputeFrameParams calculates animation parameters for a given frame of a
+// canvasW×canvasH meme, animated as strongly as c says. The seed is mixed
+// into every per-frame random source, so the same seed always yields the
+// same parameters while different seeds vary the jitter, sparkles and
+// bursts.
+func ComputeFrameParams(frame, totalFrames, canvasW, canvasH int, seed uint64, c Chaos) FrameParams {
 	t := float64(frame) / float64(totalFrames) // 0.0 to ~1.0
+	scale := layoutScale(canvasW, canvasH)
 
 	// Rainbow text color — cycle hue through 360°
-	hue := t * 360.0
-	textColor := hslToRGB(hue, 1.0, 0.55) // fully saturated, slightly bright
+	var textColor color.Color = color.White
+	if c.Rainbow {
+		hue := t * 360.0
+		textColor = hslToRGB(hue, 1.0, 0.55) // fully saturated, slightly bright
+	}
 
-	// Text pulse — oscillate font size ±15%
-	fontScale := 1.0 + 0.15*math.Sin(2*math.Pi*t)
+	// Text pulse — oscillate font size
+	fontScale := 1.0 + c.TextPulse*math.Sin(2*math.Pi*t)
 
 	// Potato bounce — absolute sine wave bounce
-	bounceHeight := 40.0
-	potatoBounceY := -int(math.Abs(math.Sin(2*math.Pi*t)) * bounceHeight)
+	potatoBounceY := -int(math.Abs(math.Sin(2*math.Pi*t)) * c.Bounce * scale)
 
-	// Potato wobble — gentle rotation ±10°
-	potatoRotation := 0.17 * math.Sin(2*math.Pi*t) // ~10° in radians
+	// Potato wobble — gentle rotation
+	potatoRotation := c.Wobble * math.Sin(2*math.Pi*t)
 
-	// Screen shake — random ±3px jitter (deterministic per frame)
-	rng := rand.New(rand.NewPCG(uint64(frame*7919), uint64(frame*6271)))
-	shakeDX := rng.IntN(7) - 3
-	shakeDY := rng.IntN(7) - 3
+	// Screen shake — random jitter (deterministic per frame)
+	rng := rand.New(rand.NewPCG(seed^uint64(frame*7919), uint64(frame*6271)))
+	shake := int(math.Round(float64(c.Shake) * scale))
+	shakeDX := rng.IntN(2*shake+1) - shake
+	shakeDY := rng.IntN(2*shake+1) - shake
 
-	// Sparkles — 6-8 random sparkles per frame
-	numSparkles := 6 + rng.IntN(3)
+	// Sparkles — random sparkles per frame
+	numSparkles := c.Sparkles + rng.IntN(c.Sparkles/3+1)
 	sparkles := make([]Sparkle, numSparkles)
 	for i := range sparkles {
 		sparkles[i] = Sparkle{
 			X:     rng.IntN(canvasW),
 			Y:     rng.IntN(canvasH),
-			Size:  4 + rng.IntN(8),         // 4-11px radius
-			Alpha: 0.5 + rng.Float64()*0.5, // 0.5-1.0
+			Size:  int(float64(4+rng.IntN(8)) * scale), // 4-11px radius
+			Alpha: 0.5 + rng.Float64()*0.5,             // 0.5-1.0
 		}
 	}
 
-	// Potato clones — 3 smaller copies at different positions and phases
-	clones := make([]PotatoClone, 3)
-	cloneRNG := rand.New(rand.NewPCG(uint64(frame*3571+1), uint64(frame*2903+1)))
+	// Potato clones — smaller copies at different positions and phases.
+	// The first three have fixed spots; any more are scattered by a source
+	// that is the same on every frame, so they stay put.
+	clones := make([]PotatoClone, c.Clones)
+	cloneRNG := rand.New(rand.NewPCG(seed, 2903))
+	px := func(n float64) int { return int(n * scale) }
 	clonePositions := [][2]int{
-		{60, 80},                       // upper-left area
-		{canvasW - 180, 60},            // upper-right area
-		{canvasW/2 - 50, canvasH - 90}, // bottom-center area
+		{px(60), px(80)},                       // upper-left area
+		{canvasW - px(180), px(60)},            // upper-right area
+		{canvasW/2 - px(50), canvasH - px(90)}, // bottom-center area
 	}
 	for i := range clones {
-		phase := float64(i) * 2.0 * math.Pi / 3.0 // 120° phase offset between clones
-		scale := 0.15 + float64(i)*0.05           // 0.15, 0.20, 0.25
-		bounceFreq := 1.5 + float64(i)*0.7        // different bounce frequencies
-		cloneBounce := -int(math.Abs(math.Sin(2*math.Pi*t*bounceFreq+phase)) * 25.0)
+		phase := float64(i) * 2.0 * math.Pi / float64(c.Clones) // evenly spread phase offsets
+		size := 0.15 + float64(i%3)*0.05                        // 0.15, 0.20, 0.25
+		bounceFreq := 1.5 + float64(i%3)*0.7                    // different bounce frequencies
+		cloneBounce := -int(math.Abs(math.Sin(2*math.Pi*t*bounceFreq+phase)) * 25.0 * scale)
 		cloneRotation := 0.25 * math.Sin(2*math.Pi*t*1.3+phase)
-		_ = cloneRNG // seeded but positions are deterministic from clonePositions
+		pos := [2]int{px(20) + cloneRNG.IntN(canvasW-px(180)), px(20) + cloneRNG.IntN(canvasH-px(160))}
+		if i < len(clonePositions) {
+			pos = clonePositions[i]
+		}
 		clones[i] = PotatoClone{
-			X:        clonePositions[i][0],
-			Y:        clonePositions[i][1],
-			Scale:    scale,
+			X:        pos[0],
+			Y:        pos[1],
+			Scale:    size,
 			Rotation: cloneRotation,
 			BounceY:  cloneBounce,
 		}
 	}
 
 	// Divine glow — pulsing alpha behind main potato
-	glowAlpha := 0.4 + 0.2*math.Sin(2*math.Pi*t*1.5)
-	glowRadius := 120 + int(20*math.Sin(2*math.Pi*t*1.5))
+	glowAlpha := c.Glow + c.Glow/2*math.Sin(2*math.Pi*t*1.5)
+	glowRadius := int(float64(120+int(20*math.Sin(2*math.Pi*t*1.5))) * scale)
 
 	// Ticker — scrolls from right to left across frames
-	// TickerX starts at canvasWidth and decreases. The speed is ~40px per frame.
-	tickerSpeed := 40.0
+	// TickerX starts at canvasW and decreases by canvasW over the loop, which
+	// is 40px per frame by default.
+	tickerSpeed := float64(canvasW) / float64(totalFrames)
 	tickerX := float64(canvasW) - float64(frame)*tickerSpeed
 
-	// Zoom pulse — oscillates between 1.0 and 1.08
-	zoomScale := 1.0 + 0.04*(1.0+math.Sin(2*math.Pi*t*0.8))
+	// Zoom pulse — oscillates between 1.0 and 1+2*Zoom
+	zoomScale := 1.0 + c.Zoom*(1.0+math.Sin(2*math.Pi*t*0.8))
 
 	// Hypno wheel — rotation angle increases each frame
 	spiralAngle := 2.0 * math.Pi * t * 0.5 // half rotation per loop
 
-	// Comic bursts — 2 bursts per frame, flashing on alternating frames
-	burstRNG := rand.New(rand.NewPCG(uint64(frame*4219+7), uint64(frame*3137+13)))
-	bursts := make([]ComicBurst, 2)
+	// Comic bursts — flashing on alternating frames
+	burstRNG := rand.New(rand.NewPCG(seed^uint64(frame*4219+7), uint64(frame*3137+13)))
+	bursts := make([]ComicBurst, c.Bursts)
 	for i := range bursts {
 		bursts[i] = ComicBurst{
-			X:        80 + burstRNG.IntN(canvasW-160),
-			Y:        80 + burstRNG.IntN(canvasH-200),
+			X:        px(80) + burstRNG.IntN(canvasW-px(160)),
+			Y:        px(80) + burstRNG.IntN(canvasH-px(200)),
 			Text:     burstWords[burstRNG.IntN(len(burstWords))],
 			Rotation: (burstRNG.Float64() - 0.5) * 0.5, // ±0.25 radians
 			Scale:    0.7 + burstRNG.Float64()*0.6,     // 0.7-1.3
@@ -0,0 +1,76 @@
+package meme
+
+import (
+	"image"
+	stddraw "image/draw"
+
+	"golang.org/x/image/draw"
+)
+
+// The ways a background can be fitted to a canvas of another shape.
+const (
+	// FitCover fills the canvas, cropping the middle of the image.
+	FitCover = "cover"
+	// FitContain shows all of the image, with black bars on either side.
+	FitContain = "contain"
+	// FitBlurFill shows all of the image, over a blurred copy stretched to
+	// fill the canvas.
+	FitBlurFill = "blur-fill"
+)
+
+// Fits lists every fit.
+var Fits = []string{FitCover, FitContain, FitBlurFill}
+
+// blurFactor is how many times smaller than the canvas the blur-fill
+// backdrop is drawn before being stretched back over it, which blurs it.
+const blurFactor = 16
+
+// fitImage returns src fitted to a w×h canvas as fit says. An image of the
+// canvas's shape is scaled to it whatever the fit.
+func fitImage(src image.Image, w, h int, fit string) *image.RGBA {
+	switch fit {
+	case FitContain:
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		stddraw.Draw(dst, dst.Bounds(), image.Black, image.Point{}, stddraw.Src)
+		draw.BiLinear.Scale(dst, containRect(src.Bounds(), w, h), src, src.Bounds(), draw.Over, nil)
+		return dst
+	case FitBlurFill:
+		small := image.NewRGBA(image.Rect(0, 0, max(1, w/blurFactor), max(1, h/blurFactor)))
+		draw.CatmullRom.Scale(small, small.Bounds(), src, src.Bounds(), draw.Src, nil)
+		dst := scaleImage(small, w, h)
+		draw.BiLinear.Scale(dst, containRect(src.Bounds(), w, h), src, src.Bounds(), draw.Over, nil)
+		return dst
+	default:
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.BiLinear.Scale(dst, dst.Bounds(), src, coverRect(src.Bounds(), w, h), draw.Over, nil)
+		return dst
+	}
+}
+
+// coverRect returns the largest part of b, centered, with the shape of a w×h
+// canvas.
+func coverRect(b image.Rectangle, w, h int) image.Rectangle {
+	cw, ch := b.Dx(), b.Dy()
+	switch {
+	case cw*h > ch*w: // wider than the canvas
+		cw = max(1, ch*w/h)
+	case cw*h < ch*w: // taller than the canvas
+		ch = max(1, cw*h/w)
+	}
+	at := b.Min.Add(image.Pt((b.Dx()-cw)/2, (b.Dy()-ch)/2))
+	return image.Rectangle{Min: at, Max: at.Add(image.Pt(cw, ch))}
+}
+
+// containRect returns the largest rectangle with the shape of b, centered on
+// a w×h canvas.
+func containRect(b image.Rectangle, w, h int) image.Rectangle {
+	dw, dh := w, h
+	switch {
+	case b.Dx()*h > b.Dy()*w: // wider than the canvas
+		dh = max(1, b.Dy()*w/b.Dx())
+	case b.Dx()*h < b.Dy()*w: // taller than the canvas
+		dw = max(1, b.Dx()*h/b.Dy())
+	}
+	at := image.Pt((w-dw)/2, (h-dh)/2)
+	return image.Rectangle{Min: at, Max: at.Add(image.Pt(dw, dh))}
+}
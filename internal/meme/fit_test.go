@@ -0,0 +1,128 @@
+package meme
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// portraitCat is a 300x600 image: green on top, red in the middle half and
+// blue at the bottom.
+func portraitCat() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 600))
+	for y := range 600 {
+		c := color.RGBA{R: 255, A: 255}
+		switch {
+		case y < 150:
+			c = color.RGBA{G: 255, A: 255}
+		case y >= 450:
+			c = color.RGBA{B: 255, A: 255}
+		}
+		for x := range 300 {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestFitImage(t *testing.T) {
+	t.Parallel()
+
+	var (
+		red   = color.RGBA{R: 255, A: 255}
+		green = color.RGBA{G: 255, A: 255}
+		blue  = color.RGBA{B: 255, A: 255}
+		black = color.RGBA{A: 255}
+	)
+	// On a 640x480 canvas the contained cat is 240 pixels wide, from x=200
+	// to x=440.
+	tests := []struct {
+		fit  string
+		want map[image.Point]color.RGBA
+	}{
+		{FitCover, map[image.Point]color.RGBA{
+			{0, 0}: red, {639, 0}: red, {320, 240}: red, {0, 479}: red, {639, 479}: red,
+		}},
+		{FitContain, map[image.Point]color.RGBA{
+			{100, 240}: black, {540, 240}: black, {320, 5}: green, {320, 240}: red, {320, 474}: blue,
+		}},
+		{FitBlurFill, map[image.Point]color.RGBA{
+			{320, 5}: green, {320, 240}: red, {320, 474}: blue,
+		}},
+	}
+	for _, tt := range tests {
+		img := fitImage(portraitCat(), 640, 480, tt.fit)
+		if img.Bounds() != image.Rect(0, 0, 640, 480) {
+			t.Errorf("%s: bounds = %v, want 640x480", tt.fit, img.Bounds())
+			continue
+		}
+		for p, want := range tt.want {
+			if got := img.RGBAAt(p.X, p.Y); got != want {
+				t.Errorf("%s: pixel at %v = %v, want %v", tt.fit, p, got, want)
+			}
+		}
+	}
+
+	// The blurred backdrop fills the bars with the cat's colors, blended:
+	// red at the middle of the left edge, and some green at the top.
+	img := fitImage(portraitCat(), 640, 480, FitBlurFill)
+	if got := img.RGBAAt(100, 240); got.R < 200 || got.A != 255 {
+		t.Errorf("blur-fill backdrop at (100,240) = %v, want opaque red", got)
+	}
+	if got := img.RGBAAt(100, 5); got.G < 100 || got.A != 255 {
+		t.Errorf("blur-fill backdrop at (100,5) = %v, want opaque green", got)
+	}
+}
+
+func TestFitRects(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		b             image.Rectangle
+		w, h          int
+		cover, inside image.Rectangle
+	}{
+		{image.Rect(0, 0, 640, 480), 640, 480, image.Rect(0, 0, 640, 480), image.Rect(0, 0, 640, 480)},
+		{image.Rect(0, 0, 300, 600), 640, 480, image.Rect(0, 187, 300, 412), image.Rect(200, 0, 440, 480)},
+		{image.Rect(10, 10, 810, 210), 640, 480, image.Rect(277, 10, 543, 210), image.Rect(0, 160, 640, 320)},
+		{image.Rect(0, 0, 640, 480), 1080, 1080, image.Rect(80, 0, 560, 480), image.Rect(0, 135, 1080, 945)},
+	}
+	for _, tt := range tests {
+		if got := coverRect(tt.b, tt.w, tt.h); got != tt.cover {
+			t.Errorf("coverRect(%v, %d, %d) = %v, want %v", tt.b, tt.w, tt.h, got, tt.cover)
+		}
+		if got := containRect(tt.b, tt.w, tt.h); got != tt.inside {
+			t.Errorf("containRect(%v, %d, %d) = %v, want %v", tt.b, tt.w, tt.h, got, tt.inside)
+		}
+	}
+}
+
+func TestGenerate_Fit(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	plain, err := ParseEffects("none", Effects())
+	if err != nil {
+		t.Fatalf("ParseEffects() error: %v", err)
+	}
+	potato := newTestImage(10, 10, color.White)
+	for fit, want := range map[string]color.RGBA{
+		"":         {R: 255, A: 255},
+		FitCover:   {R: 255, A: 255},
+		FitContain: {A: 255},
+	} {
+		anim, err := g.Generate(potato, portraitCat(), "", "", Options{Effects: plain, Frames: 1, Fit: fit})
+		if err != nil {
+			t.Fatalf("Generate() error: %v", err)
+		}
+		if got := anim.Frames[0].RGBAAt(20, 240); got != want {
+			t.Errorf("fit %q: left edge = %v, want %v", fit, got, want)
+		}
+	}
+
+	if _, err := g.Generate(potato, portraitCat(), "", "", Options{Fit: "stretch"}); err == nil {
+		t.Error("Generate() with an unknown fit should fail")
+	}
+}
@@ -6,11 +6,10 @@ import (
 	"fmt"
 	"image"
 	"image/color"
-	"image/color/palette"
 	stddraw "image/draw"
-	"image/gif"
 	"math"
 	"math/rand/v2"
+	"slices"
 	"strings"
 
 	"github.com/fogleman/gg"
@@ -23,13 +22,29 @@ import (
 var fontBytes []byte
 
 const (
-	canvasWidth  = 640
-	canvasHeight = 480
+	// DefaultWidth and DefaultHeight are the canvas size used when Options
+	// leaves it zero. The layout is designed at this size and scaled to
+	// others; see layoutScale.
+	DefaultWidth  = 640
+	DefaultHeight = 480
+	// MinSize and MaxSize bound the canvas width and height.
+	MinSize = 64
+	MaxSize = 1920
+	// MaxFrames is the most frames an animation can have.
+	MaxFrames = 120
+	// MaxPixels bounds the pixels of every frame together, which is what
+	// the memory a render needs grows with.
+	MaxPixels = 40_000_000
+	// MinFrameDelay and MaxFrameDelay bound how long each frame is shown,
+	// in hundredths of a second. Browsers show faster frames more slowly.
+	MinFrameDelay = 2
+	MaxFrameDelay = 100
+
+	// The sizes below are at the default canvas size.
 	fontSize     = 48
 	outlineShift = 2
-	topMargin    = 40
-	bottomMargin = 440
-	potatoScale  = 0.4 // 40% of canvas width
+	textMargin   = 40  // from the top and bottom edges to the text's center
+	potatoScale  = 0.4 // 40% of the default canvas width
 )
 
 // memeTexts holds predefined text pairs for random meme generation.
@@ -74,8 +89,92 @@ var tickerMessages = []string{
 
 // Generator composites a potato image and a cat image with meme text.
 type Generator interface {
-	Generate(potatoImg, catImg image.Image, topText, bottomText string) (*gif.GIF, error)
-	GenerateRandom(potatoImg, catImg image.Image) (*gif.GIF, error)
+	Generate(potatoImg, catImg image.Image, topText, bottomText string, opts Options) (*Animation, error)
+	GenerateRandom(potatoImg, catImg image.Image, opts Options) (*Animation, error)
+}
+
+// Animation is a rendered meme, independent of any output format: a sequence
+// of full-canvas truecolor frames and how long each one is shown.
+type Animation struct {
+	Frames []*image.RGBA
+	Delays []int // per-frame delay in hundredths of a second, as in image/gif
+}
+
+// Options controls a single render.
+type Options struct {
+	// Rand is the source for every random choice made while rendering. Two
+	// renders with identically seeded sources and the same input images
+	// produce identical output. If nil, a randomly seeded source is used.
+	Rand *rand.Rand
+	// Effects are drawn, in order, onto each frame. If nil, the default
+	// preset's effects are drawn; see ParseEffects.
+	Effects []Effect
+	// Chaos is how strongly frames are animated. If nil, the default
+	// preset's.
+	Chaos *Chaos
+	// Width and Height are the canvas size, from MinSize to MaxSize. If
+	// zero, DefaultWidth and DefaultHeight.
+	Width, Height int
+	// Frames is the number of frames, up to MaxFrames. If zero,
+	// DefaultFrames.
+	Frames int
+	// Delay is how long each frame is shown, in hundredths of a second, from
+	// MinFrameDelay to MaxFrameDelay. If zero, DefaultFrameDelay.
+	Delay int
+	// Fit is how the cat is fitted to the canvas, one of Fits. If empty,
+	// FitCover.
+	Fit string
+}
+
+// withDefaults returns o with zero sizes and an empty fit replaced by their
+// defaults.
+func (o Options) withDefaults() Options {
+	if o.Width == 0 {
+		o.Width = DefaultWidth
+	}
+	if o.Height == 0 {
+		o.Height = DefaultHeight
+	}
+	if o.Frames == 0 {
+		o.Frames = DefaultFrames
+	}
+	if o.Delay == 0 {
+		o.Delay = DefaultFrameDelay
+	}
+	if o.Fit == "" {
+		o.Fit = FitCover
+	}
+	return o
+}
+
+// Validate returns an error describing the first size of o that is out of
+// bounds, or its fit if it is unknown.
+func (o Options) Validate() error {
+	o = o.withDefaults()
+	if o.Width < MinSize || o.Width > MaxSize || o.Height < MinSize || o.Height > MaxSize {
+		return fmt.Errorf("width and height must be between %d and %d, got %dx%d", MinSize, MaxSize, o.Width, o.Height)
+	}
+	if o.Frames < 1 || o.Frames > MaxFrames {
+		return fmt.Errorf("frames must be between 1 and %d, got %d", MaxFrames, o.Frames)
+	}
+	if o.Delay < MinFrameDelay || o.Delay > MaxFrameDelay {
+		return fmt.Errorf("frame delay must be between %d and %d hundredths of a second, got %d", MinFrameDelay, MaxFrameDelay, o.Delay)
+	}
+	if n := o.Frames * o.Width * o.Height; n > MaxPixels {
+		return fmt.Errorf("%d frames of %dx%d is %d pixels, more than the limit of %d", o.Frames, o.Width, o.Height, n, MaxPixels)
+	}
+	if !slices.Contains(Fits, o.Fit) {
+		return fmt.Errorf("fit must be one of %s, got %q", strings.Join(Fits, ", "), o.Fit)
+	}
+	return nil
+}
+
+// rng returns the configured random source, or a freshly seeded one.
+func (o Options) rng() *rand.Rand {
+	if o.Rand != nil {
+		return o.Rand
+	}
+	return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
 }
 
 // MemeGenerator implements Generator using the fogleman/gg drawing library.
@@ -92,84 +191,104 @@ func NewGenerator() (*MemeGenerator, error) {
 	return &MemeGenerator{font: f}, nil
 }
 
-// Generate composites catImg as the background, overlays potatoImg in the
-// lower-right area, and renders topText/bottomText in classic meme style
-// across multiple frames to produce an animated GIF with maximum chaos effects.
-func (g *MemeGenerator) Generate(potatoImg, catImg image.Image, topText, bottomText string) (*gif.GIF, error) {
+// Generate composites catImg as the background, fitted to the canvas as
+// opts.Fit says, overlays potatoImg in the lower-right area, and renders
+// topText/bottomText in classic meme style across multiple frames to produce
+// an animation with maximum chaos effects.
+// A *decode.Animation cat or potato keeps moving, looping in sync with the
+// effects; the potato clones move along with the potato. Each frame is drawn
+// by opts.Effects.
+func (g *MemeGenerator) Generate(potatoImg, catImg image.Image, topText, bottomText string, opts Options) (*Animation, error) {
 	if potatoImg == nil {
 		return nil, errors.New("potato image is required")
 	}
 	if catImg == nil {
 		return nil, errors.New("cat image is required")
 	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+	width, height, frames := opts.Width, opts.Height, opts.Frames
+	scale := layoutScale(width, height)
 
-	// Pre-scale images once before the frame loop.
-	scaledCat := scaleImage(catImg, canvasWidth, canvasHeight)
+	// Pre-scale images once before the frame loop. An animated cat gets one
+	// scaled image per frame.
+	catFrames := scaledFrames(catImg, frames, opts.Delay, func(src image.Image) *image.RGBA {
+		return fitImage(src, width, height, opts.Fit)
+	})
 
-	potatoW := int(float64(canvasWidth) * potatoScale)
+	potatoW := int(DefaultWidth * potatoScale * scale)
 	potatoH := scaleHeight(potatoImg, potatoW)
-	scaledPotato := scaleImage(potatoImg, potatoW, potatoH)
+	potatoFrames := scaledFrames(potatoImg, frames, opts.Delay, func(src image.Image) *image.RGBA {
+		return scaleImage(src, potatoW, potatoH)
+	})
 
 	// Base position for the potato (lower-right).
-	potatoBaseX := canvasWidth - potatoW - 20
-	potatoBaseY := canvasHeight - potatoH - 60
+	potatoBaseX := width - potatoW - int(20*scale)
+	potatoBaseY := height - potatoH - int(60*scale)
 
 	topTextUpper := strings.ToUpper(topText)
 	bottomTextUpper := strings.ToUpper(bottomText)
 
-	// Pick a ticker message once for the entire animation.
-	tickerMsg := tickerMessages[rand.IntN(len(tickerMessages))]
+	rng := opts.rng()
 
-	anim := &gif.GIF{
-		LoopCount: 0, // infinite loop
-	}
+	// Pick a ticker message and the per-frame seed once for the entire
+	// animation.
+	tickerMsg := tickerMessages[rng.IntN(len(tickerMessages))]
+	frameSeed := rng.Uint64()
 
-	for i := range TotalFrames {
-		params := ComputeFrameParams(i, TotalFrames, canvasWidth, canvasHeight)
+	anim := &Animation{}
 
-		dc := gg.NewContext(canvasWidth, canvasHeight)
-
-		// 1. Draw cat background with zoom scale and screen shake.
-		drawZoomedBackground(dc, scaledCat, params.ZoomScale, params.ShakeDX, params.ShakeDY)
-
-		// 2. Hypno wheel overlay (low alpha, rotating).
-		drawHypnoWheel(dc, float64(canvasWidth)/2, float64(canvasHeight)/2,
-			float64(canvasWidth)*0.8, params.SpiralAngle, 0.08)
+	preset := DefaultPreset()
+	pipeline := opts.Effects
+	if pipeline == nil {
+		pipeline = preset.Effects
+	}
+	chaos := preset.Chaos
+	if opts.Chaos != nil {
+		chaos = *opts.Chaos
+	}
 
-		// 3. Divine glow behind main potato.
-		potatoDrawX := potatoBaseX
-		potatoDrawY := potatoBaseY + params.PotatoBounceY
-		potatoCenterX := float64(potatoDrawX) + float64(potatoW)/2
-		potatoCenterY := float64(potatoDrawY) + float64(potatoH)/2
-		drawDivineGlow(dc, potatoCenterX, potatoCenterY, params.GlowRadius, params.GlowAlpha)
+	// Lay the captions out once, so their lines break in the same places on
+	// every frame.
+	var captions [2]textLayout
+	for i, text := range []string{topTextUpper, bottomTextUpper} {
+		captions[i] = layoutText(g.font, text, fontSize*scale, minFontSize*scale, 1+math.Abs(chaos.TextPulse),
+			float64(width)-2*textPadding*scale, float64(height)*textBoxHeight)
+	}
 
-		// 4. Main potato with bounce and rotation.
-		dc.Push()
-		dc.RotateAbout(params.PotatoRotation, potatoCenterX, potatoCenterY)
-		dc.DrawImage(scaledPotato, potatoDrawX, potatoDrawY)
-		dc.Pop()
+	for i := range frames {
+		params := ComputeFrameParams(i, frames, width, height, frameSeed, chaos)
+		f := &Frame{
+			Index:      i,
+			Params:     params,
+			Width:      width,
+			Height:     height,
+			Scale:      scale,
+			Cat:        catFrames[i],
+			Potato:     potatoFrames[i],
+			PotatoX:    potatoBaseX,
+			PotatoY:    potatoBaseY + params.PotatoBounceY,
+			TopText:    topTextUpper,
+			BottomText: bottomTextUpper,
+			captions:   captions,
+			Ticker:     tickerMsg,
+			Font:       g.font,
+			Zoom:       1,
+		}
 
-		// 5. Potato clones — smaller copies bouncing independently.
-		drawPotatoClones(dc, potatoImg, params.Clones)
+		dc := gg.NewContext(width, height)
 
-		// 6. Comic bursts — starburst shapes with text, flashing.
-		drawComicBursts(dc, g.font, params.Bursts)
+		// Start from opaque black so edges exposed by screen shake never
+		// leave transparent pixels in the frame.
+		dc.SetRGB(0, 0, 0)
+		dc.Clear()
 
-		// 7. Sparkles.
-		for _, sp := range params.Sparkles {
-			drawSparkle(dc, sp.X, sp.Y, sp.Size, sp.Alpha)
+		for _, e := range pipeline {
+			e.Draw(dc, f)
 		}
 
-		// 8. Meme text with animated color and size.
-		scaledFontSize := fontSize * params.FontScale
-		face := truetype.NewFace(g.font, &truetype.Options{Size: scaledFontSize})
-		drawMemeText(dc, face, topTextUpper, canvasWidth/2, topMargin, params.TextColor)
-		drawMemeText(dc, face, bottomTextUpper, canvasWidth/2, bottomMargin, params.TextColor)
-
-		// 9. News ticker banner + scrolling text.
-		drawTicker(dc, g.font, tickerMsg, params.TickerX)
-
-		// Convert frame to paletted image.
 		rgbaFrame, ok := dc.Image().(*image.RGBA)
 		if !ok {
 			// Fallback: copy into RGBA.
@@ -178,25 +297,32 @@ func (g *MemeGenerator) Generate(potatoImg, catImg image.Image, topText, bottomT
 			stddraw.Draw(rgbaFrame, b, dc.Image(), b.Min, stddraw.Src)
 		}
 
-		palettedImg := image.NewPaletted(image.Rect(0, 0, canvasWidth, canvasHeight), palette.Plan9)
-		stddraw.FloydSteinberg.Draw(palettedImg, palettedImg.Bounds(), rgbaFrame, image.Point{})
-
-		anim.Image = append(anim.Image, palettedImg)
-		anim.Delay = append(anim.Delay, FrameDelay)
+		anim.Frames = append(anim.Frames, rgbaFrame)
+		anim.Delays = append(anim.Delays, opts.Delay)
 	}
 
 	return anim, nil
 }
 
 // GenerateRandom picks a random predefined text pair and calls Generate.
-func (g *MemeGenerator) GenerateRandom(potatoImg, catImg image.Image) (*gif.GIF, error) {
-	pair := memeTexts[rand.IntN(len(memeTexts))]
-	return g.Generate(potatoImg, catImg, pair.Top, pair.Bottom)
+func (g *MemeGenerator) GenerateRandom(potatoImg, catImg image.Image, opts Options) (*Animation, error) {
+	opts.Rand = opts.rng()
+	pair := memeTexts[opts.Rand.IntN(len(memeTexts))]
+	return g.Generate(potatoImg, catImg, pair.Top, pair.Bottom, opts)
+}
+
+// layoutScale returns how much larger than the default a canvas of w×h is,
+// by which sizes and distances laid out for the default canvas are scaled.
+// It is the lesser of the two dimensions' ratios, so that what fits across
+// or down the default canvas fits this one.
+func layoutScale(w, h int) float64 {
+	return min(float64(w)/DefaultWidth, float64(h)/DefaultHeight)
 }
 
 // drawZoomedBackground draws the cat background with a zoom scale applied,
 // centered on the canvas, plus screen shake offset.
 func drawZoomedBackground(dc *gg.Context, catImg *image.RGBA, zoomScale float64, shakeDX, shakeDY int) {
+	canvasWidth, canvasHeight := dc.Width(), dc.Height()
 	zoomedW := int(float64(canvasWidth) * zoomScale)
 	zoomedH := int(float64(canvasHeight) * zoomScale)
 
@@ -250,10 +376,11 @@ func drawDivineGlow(dc *gg.Context, cx, cy float64, radius int, alpha float64) {
 	}
 }
 
-// drawPotatoClones draws smaller potato copies at their computed positions.
-func drawPotatoClones(dc *gg.Context, potatoImg image.Image, clones []PotatoClone) {
+// drawPotatoClones draws smaller potato copies at their computed positions,
+// sized for a canvas scale times the default.
+func drawPotatoClones(dc *gg.Context, potatoImg image.Image, clones []PotatoClone, scale float64) {
 	for _, clone := range clones {
-		cloneW := int(float64(canvasWidth) * clone.Scale)
+		cloneW := int(DefaultWidth * clone.Scale * scale)
 		cloneH := scaleHeight(potatoImg, cloneW)
 		if cloneW < 1 || cloneH < 1 {
 			continue
@@ -272,8 +399,9 @@ func drawPotatoClones(dc *gg.Context, potatoImg image.Image, clones []PotatoClon
 	}
 }
 
-// drawComicBursts draws starburst shapes with comic text that flash on/off.
-func drawComicBursts(dc *gg.Context, f *truetype.Font, bursts []ComicBurst) {
+// drawComicBursts draws starburst shapes with comic text that flash on/off,
+// sized for a canvas scale times the default.
+func drawComicBursts(dc *gg.Context, f *truetype.Font, bursts []ComicBurst, scale float64) {
 	for _, burst := range bursts {
 		if !burst.Visible {
 			continue
@@ -281,8 +409,8 @@ func drawComicBursts(dc *gg.Context, f *truetype.Font, bursts []ComicBurst) {
 
 		cx := float64(burst.X)
 		cy := float64(burst.Y)
-		outerR := 45.0 * burst.Scale
-		innerR := 22.0 * burst.Scale
+		outerR := 45.0 * burst.Scale * scale
+		innerR := 22.0 * burst.Scale * scale
 		points := 10
 
 		dc.Push()
@@ -296,11 +424,11 @@ func drawComicBursts(dc *gg.Context, f *truetype.Font, bursts []ComicBurst) {
 		// Draw starburst outline.
 		drawStarburst(dc, cx, cy, outerR, innerR, points, 0)
 		dc.SetRGBA(0.8, 0.0, 0.0, 1.0) // red outline
-		dc.SetLineWidth(2)
+		dc.SetLineWidth(2 * scale)
 		dc.Stroke()
 
 		// Draw burst text.
-		burstFontSize := 16.0 * burst.Scale
+		burstFontSize := 16.0 * burst.Scale * scale
 		face := truetype.NewFace(f, &truetype.Options{Size: burstFontSize})
 		dc.SetFontFace(face)
 		dc.SetRGBA(0.8, 0.0, 0.0, 1.0) // red text
@@ -329,23 +457,25 @@ func drawStarburst(dc *gg.Context, cx, cy, outerR, innerR float64, points int, r
 	dc.ClosePath()
 }
 
-// drawTicker draws a semi-transparent banner at the bottom with scrolling text.
-func drawTicker(dc *gg.Context, f *truetype.Font, message string, tickerX float64) {
-	bannerHeight := 30.0
-	bannerY := float64(canvasHeight) - bannerHeight
+// drawTicker draws a semi-transparent banner at the bottom with scrolling
+// text, sized for a canvas scale times the default.
+func drawTicker(dc *gg.Context, f *truetype.Font, message string, tickerX, scale float64) {
+	canvasWidth, canvasHeight := float64(dc.Width()), float64(dc.Height())
+	bannerHeight := 30.0 * scale
+	bannerY := canvasHeight - bannerHeight
 
 	// Semi-transparent dark banner.
 	dc.SetRGBA(0, 0, 0, 0.7)
-	dc.DrawRectangle(0, bannerY, float64(canvasWidth), bannerHeight)
+	dc.DrawRectangle(0, bannerY, canvasWidth, bannerHeight)
 	dc.Fill()
 
 	// Red accent line at top of banner.
 	dc.SetRGBA(0.8, 0.0, 0.0, 0.9)
-	dc.DrawRectangle(0, bannerY, float64(canvasWidth), 2)
+	dc.DrawRectangle(0, bannerY, canvasWidth, 2*scale)
 	dc.Fill()
 
 	// Ticker text in white.
-	tickerFace := truetype.NewFace(f, &truetype.Options{Size: 18})
+	tickerFace := truetype.NewFace(f, &truetype.Options{Size: 18 * scale})
 	dc.SetFontFace(tickerFace)
 	dc.SetColor(color.White)
 
@@ -357,7 +487,7 @@ func drawTicker(dc *gg.Context, f *truetype.Font, message string, tickerX float6
 	// Draw primary text.
 	dc.DrawStringAnchored(message, tickerX, textY, 0, 0.5)
 	// Draw wrapped copy so it seamlessly loops.
-	dc.DrawStringAnchored(message, tickerX+w+100, textY, 0, 0.5)
+	dc.DrawStringAnchored(message, tickerX+w+100*scale, textY, 0, 0.5)
 }
 
 // scaleImage renders src scaled to the given dimensions using bilinear interpolation.
@@ -379,15 +509,15 @@ func scaleHeight(src image.Image, targetW int) int {
 }
 
 // drawMemeText renders text with a black outline and a colored fill, centered at
-// (cx, cy). The outline is produced by drawing the text 8 times at small
-// offsets in each cardinal and diagonal direction.
-func drawMemeText(dc *gg.Context, face font.Face, text string, cx, cy float64, fillColor color.Color) {
+// (cx, cy). The outline is produced by drawing the text 8 times at offsets of
+// shift pixels in each cardinal and diagonal direction.
+func drawMemeText(dc *gg.Context, face font.Face, text string, cx, cy float64, shift int, fillColor color.Color) {
 	dc.SetFontFace(face)
 
 	// Outline: draw in black at 8 surrounding offsets.
 	dc.SetColor(color.Black)
-	for dx := -outlineShift; dx <= outlineShift; dx += outlineShift {
-		for dy := -outlineShift; dy <= outlineShift; dy += outlineShift {
+	for dx := -shift; dx <= shift; dx += shift {
+		for dy := -shift; dy <= shift; dy += shift {
 			if dx == 0 && dy == 0 {
 				continue
 			}
@@ -400,11 +530,12 @@ func drawMemeText(dc *gg.Context, face font.Face, text string, cx, cy float64, f
 	dc.DrawStringAnchored(text, cx, cy, 0.5, 0.5)
 }
 
-// drawSparkle renders a 4-pointed star shape at the given position.
-func drawSparkle(dc *gg.Context, x, y, size int, alpha float64) {
+// drawSparkle renders a 4-pointed star shape at the given position, with
+// lines as wide as a canvas scale times the default needs.
+func drawSparkle(dc *gg.Context, x, y, size int, alpha, scale float64) {
 	c := color.RGBA{R: 255, G: 255, B: 200, A: uint8(alpha * 255)} // warm yellow-white
 	dc.SetColor(c)
-	dc.SetLineWidth(2)
+	dc.SetLineWidth(2 * scale)
 	// Vertical line
 	dc.DrawLine(float64(x), float64(y-size), float64(x), float64(y+size))
 	dc.Stroke()
@@ -1,9 +1,15 @@
 package meme
 
 import (
+	"bytes"
 	"image"
 	"image/color"
+	"image/gif"
+	"math/rand/v2"
+	"reflect"
 	"testing"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
 )
 
 // newTestImage creates a solid-color RGBA image of the given size.
@@ -39,38 +45,40 @@ func TestGenerate_ValidInputs(t *testing.T) {
 	potato := newTestImage(200, 200, color.RGBA{R: 255, G: 200, B: 100, A: 255})
 	cat := newTestImage(640, 480, color.RGBA{R: 100, G: 100, B: 100, A: 255})
 
-	result, err := g.Generate(potato, cat, "top text", "bottom text")
+	result, err := g.Generate(potato, cat, "top text", "bottom text", Options{})
 	if err != nil {
 		t.Fatalf("Generate() error: %v", err)
 	}
 	if result == nil {
-		t.Fatal("Generate() returned nil GIF")
+		t.Fatal("Generate() returned nil animation")
 	}
 
-	if len(result.Image) != TotalFrames {
-		t.Errorf("Generate() frame count = %d, want %d", len(result.Image), TotalFrames)
+	if len(result.Frames) != DefaultFrames {
+		t.Errorf("Generate() frame count = %d, want %d", len(result.Frames), DefaultFrames)
 	}
 
-	if len(result.Delay) != TotalFrames {
-		t.Errorf("Generate() delay count = %d, want %d", len(result.Delay), TotalFrames)
+	if len(result.Delays) != DefaultFrames {
+		t.Errorf("Generate() delay count = %d, want %d", len(result.Delays), DefaultFrames)
 	}
 
-	for i, frame := range result.Image {
+	for i, frame := range result.Frames {
 		bounds := frame.Bounds()
-		if bounds.Dx() != canvasWidth || bounds.Dy() != canvasHeight {
+		if bounds.Dx() != DefaultWidth || bounds.Dy() != DefaultHeight {
 			t.Errorf("Generate() frame %d size = %dx%d, want %dx%d",
-				i, bounds.Dx(), bounds.Dy(), canvasWidth, canvasHeight)
+				i, bounds.Dx(), bounds.Dy(), DefaultWidth, DefaultHeight)
 		}
 	}
 
-	for i, d := range result.Delay {
-		if d != FrameDelay {
-			t.Errorf("Generate() frame %d delay = %d, want %d", i, d, FrameDelay)
+	for i, d := range result.Delays {
+		if d != DefaultFrameDelay {
+			t.Errorf("Generate() frame %d delay = %d, want %d", i, d, DefaultFrameDelay)
 		}
 	}
 
-	if result.LoopCount != 0 {
-		t.Errorf("Generate() LoopCount = %d, want 0 (infinite)", result.LoopCount)
+	for i, frame := range result.Frames {
+		if !frame.Opaque() {
+			t.Errorf("Generate() frame %d is not opaque", i)
+		}
 	}
 }
 
@@ -82,7 +90,7 @@ func TestGenerate_NilPotatoImage(t *testing.T) {
 
 	cat := newTestImage(640, 480, color.RGBA{R: 100, G: 100, B: 100, A: 255})
 
-	_, err = g.Generate(nil, cat, "top", "bottom")
+	_, err = g.Generate(nil, cat, "top", "bottom", Options{})
 	if err == nil {
 		t.Fatal("Generate() with nil potato image should return error")
 	}
@@ -99,7 +107,7 @@ func TestGenerate_NilCatImage(t *testing.T) {
 
 	potato := newTestImage(200, 200, color.RGBA{R: 255, G: 200, B: 100, A: 255})
 
-	_, err = g.Generate(potato, nil, "top", "bottom")
+	_, err = g.Generate(potato, nil, "top", "bottom", Options{})
 	if err == nil {
 		t.Fatal("Generate() with nil cat image should return error")
 	}
@@ -117,23 +125,23 @@ func TestGenerateRandom(t *testing.T) {
 	potato := newTestImage(200, 200, color.RGBA{R: 255, G: 200, B: 100, A: 255})
 	cat := newTestImage(640, 480, color.RGBA{R: 100, G: 100, B: 100, A: 255})
 
-	result, err := g.GenerateRandom(potato, cat)
+	result, err := g.GenerateRandom(potato, cat, Options{})
 	if err != nil {
 		t.Fatalf("GenerateRandom() error: %v", err)
 	}
 	if result == nil {
-		t.Fatal("GenerateRandom() returned nil GIF")
+		t.Fatal("GenerateRandom() returned nil animation")
 	}
 
-	if len(result.Image) != TotalFrames {
-		t.Errorf("GenerateRandom() frame count = %d, want %d", len(result.Image), TotalFrames)
+	if len(result.Frames) != DefaultFrames {
+		t.Errorf("GenerateRandom() frame count = %d, want %d", len(result.Frames), DefaultFrames)
 	}
 
-	for i, frame := range result.Image {
+	for i, frame := range result.Frames {
 		bounds := frame.Bounds()
-		if bounds.Dx() != canvasWidth || bounds.Dy() != canvasHeight {
+		if bounds.Dx() != DefaultWidth || bounds.Dy() != DefaultHeight {
 			t.Errorf("GenerateRandom() frame %d size = %dx%d, want %dx%d",
-				i, bounds.Dx(), bounds.Dy(), canvasWidth, canvasHeight)
+				i, bounds.Dx(), bounds.Dy(), DefaultWidth, DefaultHeight)
 		}
 	}
 }
@@ -147,10 +155,10 @@ func TestGenerateRandom_NilInputs(t *testing.T) {
 	cat := newTestImage(640, 480, color.RGBA{R: 100, G: 100, B: 100, A: 255})
 	potato := newTestImage(200, 200, color.RGBA{R: 255, G: 200, B: 100, A: 255})
 
-	if _, err := g.GenerateRandom(nil, cat); err == nil {
+	if _, err := g.GenerateRandom(nil, cat, Options{}); err == nil {
 		t.Error("GenerateRandom() with nil potato should return error")
 	}
-	if _, err := g.GenerateRandom(potato, nil); err == nil {
+	if _, err := g.GenerateRandom(potato, nil, Options{}); err == nil {
 		t.Error("GenerateRandom() with nil cat should return error")
 	}
 }
@@ -167,3 +175,317 @@ func TestScaleHeight(t *testing.T) {
 		t.Errorf("scaleHeight(400x200, 200) = %d, want 100", got)
 	}
 }
+
+func TestOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	valid := []Options{
+		{},
+		{Width: 1080, Height: 1080},
+		{Width: MinSize, Height: MaxSize, Frames: 1, Delay: MaxFrameDelay},
+		{Width: 320, Height: 240, Frames: MaxFrames, Delay: MinFrameDelay},
+	}
+	for _, o := range valid {
+		if err := o.Validate(); err != nil {
+			t.Errorf("%+v: Validate() error: %v", o, err)
+		}
+	}
+
+	invalid := []Options{
+		{Width: 10000, Height: 10000},
+		{Width: MinSize - 1},
+		{Height: -480},
+		{Frames: MaxFrames + 1},
+		{Frames: -1},
+		{Delay: 1},
+		{Delay: MaxFrameDelay + 1},
+		{Width: MaxSize, Height: MaxSize, Frames: 20},
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("%+v: Validate() should fail", o)
+		}
+	}
+}
+
+func TestGenerate_Sizes(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	potato := newTestImage(200, 200, color.RGBA{R: 255, G: 200, B: 100, A: 255})
+	cat := newTestImage(640, 480, color.RGBA{R: 100, G: 100, B: 100, A: 255})
+	tests := []Options{
+		{Width: 1080, Height: 1080, Frames: 2},
+		{Width: 320, Height: 240, Frames: 24, Delay: 4},
+		{Width: 270, Height: 480, Frames: 3},
+		{Width: MinSize, Height: MinSize, Frames: 1},
+	}
+	for _, opts := range tests {
+		anim, err := g.Generate(potato, cat, "top text", "bottom text", opts)
+		if err != nil {
+			t.Errorf("%+v: Generate() error: %v", opts, err)
+			continue
+		}
+		want := opts.withDefaults()
+		if len(anim.Frames) != want.Frames {
+			t.Errorf("%+v: %d frames, want %d", opts, len(anim.Frames), want.Frames)
+		}
+		for i, frame := range anim.Frames {
+			if frame.Bounds() != image.Rect(0, 0, want.Width, want.Height) || anim.Delays[i] != want.Delay {
+				t.Errorf("%+v: frame %d is %v shown for %d, want %dx%d for %d", opts, i, frame.Bounds(), anim.Delays[i], want.Width, want.Height, want.Delay)
+				break
+			}
+		}
+	}
+
+	if _, err := g.Generate(potato, cat, "top", "bottom", Options{Width: 10000, Height: 10000}); err == nil {
+		t.Error("Generate() of a 10000x10000 meme should fail")
+	}
+}
+
+func TestGenerate_LayoutScales(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	ticker, err := ParseEffects("none,ticker", Effects())
+	if err != nil {
+		t.Fatalf("ParseEffects() error: %v", err)
+	}
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	potato := newTestImage(10, 10, white)
+	cat := newTestImage(640, 480, white)
+
+	// The ticker banner is 30 pixels tall on the default canvas, so it is
+	// twice that on one twice the size, and as tall on one only wider.
+	tests := []struct {
+		width, height, banner int
+	}{
+		{DefaultWidth, DefaultHeight, 30},
+		{2 * DefaultWidth, 2 * DefaultHeight, 60},
+		{2 * DefaultWidth, DefaultHeight, 30},
+		{DefaultWidth / 2, DefaultHeight / 2, 15},
+	}
+	for _, tt := range tests {
+		anim, err := g.Generate(potato, cat, "", "", Options{Effects: ticker, Width: tt.width, Height: tt.height, Frames: 1})
+		if err != nil {
+			t.Fatalf("Generate() error: %v", err)
+		}
+		frame := anim.Frames[0]
+		// Sample the banner's left edge, below the accent line, where the
+		// text hasn't scrolled in yet.
+		if got := frame.RGBAAt(1, tt.height-tt.banner-1); got != white {
+			t.Errorf("%dx%d: above the banner = %v, want the white cat", tt.width, tt.height, got)
+		}
+		if got := frame.RGBAAt(1, tt.height-tt.banner/2); got == white {
+			t.Errorf("%dx%d: %d pixels from the bottom = white, want the banner", tt.width, tt.height, tt.banner/2)
+		}
+	}
+}
+
+func TestGenerateRandom_SameSeedIsPixelIdentical(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	potato := newTestImage(200, 200, color.RGBA{R: 255, G: 200, B: 100, A: 255})
+	cat := newTestImage(640, 480, color.RGBA{R: 100, G: 100, B: 100, A: 255})
+
+	render := func(seed uint64) []byte {
+		t.Helper()
+		anim, err := g.GenerateRandom(potato, cat, Options{Rand: rand.New(rand.NewPCG(seed, seed))})
+		if err != nil {
+			t.Fatalf("GenerateRandom() error: %v", err)
+		}
+		var pix []byte
+		for _, frame := range anim.Frames {
+			pix = append(pix, frame.Pix...)
+		}
+		return pix
+	}
+
+	if !bytes.Equal(render(7), render(7)) {
+		t.Error("GenerateRandom() with the same seed produced different frames")
+	}
+}
+
+func TestComputeFrameParams_Seed(t *testing.T) {
+	a := ComputeFrameParams(3, DefaultFrames, DefaultWidth, DefaultHeight, 99, DefaultPreset().Chaos)
+	b := ComputeFrameParams(3, DefaultFrames, DefaultWidth, DefaultHeight, 99, DefaultPreset().Chaos)
+	if !reflect.DeepEqual(a, b) {
+		t.Error("ComputeFrameParams() with the same seed returned different params")
+	}
+
+	c := ComputeFrameParams(3, DefaultFrames, DefaultWidth, DefaultHeight, 100, DefaultPreset().Chaos)
+	if reflect.DeepEqual(a.Sparkles, c.Sparkles) && reflect.DeepEqual(a.Bursts, c.Bursts) {
+		t.Error("ComputeFrameParams() with different seeds returned identical sparkles and bursts")
+	}
+}
+
+func TestSyncFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		delays []int
+		want   []int
+	}{
+		{"one play fills the loop", []int{64, 64}, []int{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1}},
+		{"short loop plays several times", []int{16, 16}, []int{0, 0, 1, 1, 0, 0, 1, 1, 0, 0, 1, 1, 0, 0, 1, 1}},
+		{"long loop is sped up", []int{100, 100, 100, 100}, []int{0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3}},
+		{"uneven delays", []int{96, 16, 16}, []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2}},
+	}
+	for _, tt := range tests {
+		if got := syncFrames(tt.delays, DefaultFrames, DefaultFrameDelay); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: syncFrames(%v) = %v, want %v", tt.name, tt.delays, got, tt.want)
+		}
+	}
+}
+
+// animatedCat decodes a full-canvas GIF that shows each of colors for delay.
+func animatedCat(t *testing.T, delay int, colors ...color.RGBA) image.Image {
+	t.Helper()
+	g := &gif.GIF{}
+	for _, c := range colors {
+		frame := image.NewPaletted(image.Rect(0, 0, 64, 48), color.Palette{c})
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, delay)
+	}
+	return decodeGIF(t, g)
+}
+
+// decodeGIF encodes g and decodes it as a *decode.Animation.
+func decodeGIF(t *testing.T, g *gif.GIF) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatalf("encoding test GIF: %v", err)
+	}
+	img, _, err := decode.Limits{}.DecodeAnimated(&buf)
+	if err != nil {
+		t.Fatalf("decoding test GIF: %v", err)
+	}
+	if _, ok := img.(*decode.Animation); !ok {
+		t.Fatalf("test GIF decoded as %T, want an animation", img)
+	}
+	return img
+}
+
+func TestGenerate_AnimatedCat(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	potato := newTestImage(200, 200, color.RGBA{R: 128, G: 128, B: 128, A: 255})
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	cat := animatedCat(t, DefaultFrames*DefaultFrameDelay/2, red, blue)
+
+	result, err := g.Generate(potato, cat, "top", "bottom", Options{Rand: rand.New(rand.NewPCG(1, 2))})
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+
+	// The cat shows red for the first half of the loop and blue for the
+	// second; sample the left edge, away from the potato and text.
+	for i, frame := range result.Frames {
+		var r, b int
+		for y := 150; y < 300; y++ {
+			for x := 5; x < 40; x++ {
+				c := frame.RGBAAt(x, y)
+				r += int(c.R)
+				b += int(c.B)
+			}
+		}
+		if wantRed := i < DefaultFrames/2; (r > b) != wantRed {
+			t.Errorf("frame %d: red %d, blue %d; want the cat's %s frame", i, r, b, map[bool]string{true: "red", false: "blue"}[wantRed])
+		}
+	}
+}
+
+func TestScaledFrames_Disposal(t *testing.T) {
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	palette := color.Palette{color.Transparent, red, blue}
+	// A potato whose left half is red in the first half of the loop and
+	// whose right half is blue in the second, on a transparent background.
+	halves := func(disposal byte) image.Image {
+		left := image.NewPaletted(image.Rect(0, 0, 10, 20), palette)
+		right := image.NewPaletted(image.Rect(10, 0, 20, 20), palette)
+		for i := range left.Pix {
+			left.Pix[i], right.Pix[i] = 1, 2
+		}
+		g := &gif.GIF{
+			Image:    []*image.Paletted{left, right},
+			Delay:    []int{DefaultFrames * DefaultFrameDelay / 2, DefaultFrames * DefaultFrameDelay / 2},
+			Disposal: []byte{disposal, gif.DisposalNone},
+			Config:   image.Config{ColorModel: palette, Width: 20, Height: 20},
+		}
+		return decodeGIF(t, g)
+	}
+
+	tests := []struct {
+		name     string
+		disposal byte
+		// wantLeft is the left half in the second half of the loop.
+		wantLeft color.RGBA
+	}{
+		{"none keeps the red half", gif.DisposalNone, red},
+		{"background clears it", gif.DisposalBackground, color.RGBA{}},
+		{"previous restores the empty canvas", gif.DisposalPrevious, color.RGBA{}},
+	}
+	for _, tt := range tests {
+		frames := scaledFrames(halves(tt.disposal), DefaultFrames, DefaultFrameDelay, func(src image.Image) *image.RGBA {
+			return scaleImage(src, 40, 40)
+		})
+		if len(frames) != DefaultFrames {
+			t.Fatalf("%s: got %d frames, want %d", tt.name, len(frames), DefaultFrames)
+		}
+		first, last := frames[0], frames[DefaultFrames-1]
+		if got := first.RGBAAt(10, 20); got != red {
+			t.Errorf("%s: first frame left = %v, want red", tt.name, got)
+		}
+		if got := first.RGBAAt(30, 20); got.A != 0 {
+			t.Errorf("%s: first frame right = %v, want transparent", tt.name, got)
+		}
+		if got := last.RGBAAt(10, 20); got != tt.wantLeft {
+			t.Errorf("%s: last frame left = %v, want %v", tt.name, got, tt.wantLeft)
+		}
+		if got := last.RGBAAt(30, 20); got != blue {
+			t.Errorf("%s: last frame right = %v, want blue", tt.name, got)
+		}
+	}
+}
+
+func TestGenerate_AnimatedPotato(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	potato := animatedCat(t, DefaultFrames*DefaultFrameDelay/2, red, blue)
+	cat := newTestImage(640, 480, color.RGBA{G: 128, A: 255})
+
+	result, err := g.Generate(potato, cat, "top", "bottom", Options{Rand: rand.New(rand.NewPCG(3, 4))})
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+
+	// Sample the middle of the main potato, which stays covered through
+	// its bounce and wobble.
+	for i, frame := range result.Frames {
+		var r, b int
+		for y := 240; y < 270; y++ {
+			for x := 470; x < 510; x++ {
+				c := frame.RGBAAt(x, y)
+				r += int(c.R)
+				b += int(c.B)
+			}
+		}
+		if wantRed := i < DefaultFrames/2; (r > b) != wantRed {
+			t.Errorf("frame %d: red %d, blue %d; want the potato's %s frame", i, r, b, map[bool]string{true: "red", false: "blue"}[wantRed])
+		}
+	}
+}
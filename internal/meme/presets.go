@@ -0,0 +1,108 @@
+package meme
+
+import "strings"
+
+// Preset is a named level of chaos: how strongly a meme is animated and
+// which effects it draws.
+type Preset struct {
+	// Name is the short name used to select the preset, e.g. "mild".
+	Name string
+	// Chaos is how strongly frames are animated.
+	Chaos Chaos
+	// Effects are the effects drawn unless others are selected.
+	Effects []Effect
+}
+
+// presets lists every preset from calmest to wildest.
+var presets = []Preset{
+	{
+		// A plain top-and-bottom-text meme that doesn't move.
+		Name:    "classic",
+		Effects: mustParseEffects("none,text"),
+	},
+	{
+		Name: "mild",
+		Chaos: Chaos{
+			Rainbow:   true,
+			TextPulse: 0.05,
+			Bounce:    15,
+			Wobble:    0.06,
+			Sparkles:  3,
+			Glow:      0.25,
+			Zoom:      0.015,
+		},
+		Effects: mustParseEffects("zoom,glow,sparkles,text"),
+	},
+	{
+		Name: "chaotic",
+		Chaos: Chaos{
+			Rainbow:   true,
+			TextPulse: 0.15,
+			Bounce:    40,
+			Wobble:    0.17, // ~10°
+			Shake:     3,
+			Sparkles:  6,
+			Clones:    3,
+			Bursts:    2,
+			Glow:      0.4,
+			Zoom:      0.04,
+		},
+		Effects: Effects(),
+	},
+	{
+		Name: "unhinged",
+		Chaos: Chaos{
+			Rainbow:   true,
+			TextPulse: 0.3,
+			Bounce:    80,
+			Wobble:    0.4,
+			Shake:     12,
+			Sparkles:  16,
+			Clones:    8,
+			Bursts:    6,
+			Glow:      0.6,
+			Zoom:      0.1,
+		},
+		Effects: Effects(),
+	},
+}
+
+// defaultPreset is the index in presets of the preset used when none is
+// chosen.
+const defaultPreset = 2
+
+// mustParseEffects is ParseEffects of every effect, for effect lists known
+// to be valid.
+func mustParseEffects(s string) []Effect {
+	effects, err := ParseEffects(s, Effects())
+	if err != nil {
+		panic(err)
+	}
+	return effects
+}
+
+// DefaultPreset returns the preset used when none is chosen.
+func DefaultPreset() Preset {
+	return presets[defaultPreset]
+}
+
+// PresetNames returns the names of every preset, calmest first.
+func PresetNames() []string {
+	names := make([]string, len(presets))
+	for i, p := range presets {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// LookupPreset returns the preset named name. Names are matched
+// case-insensitively.
+func LookupPreset(name string) (Preset, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, p := range presets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Preset{}, false
+}
@@ -0,0 +1,121 @@
+package meme
+
+import (
+	"bytes"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestLookupPreset(t *testing.T) {
+	t.Parallel()
+
+	if got, want := PresetNames(), []string{"classic", "mild", "chaotic", "unhinged"}; !slices.Equal(got, want) {
+		t.Errorf("PresetNames() = %v, want %v", got, want)
+	}
+	for _, name := range PresetNames() {
+		if p, ok := LookupPreset(name); !ok || p.Name != name {
+			t.Errorf("LookupPreset(%q) = %v, %v", name, p.Name, ok)
+		}
+	}
+	if p, ok := LookupPreset(" Unhinged "); !ok || p.Name != "unhinged" {
+		t.Error("LookupPreset() should ignore case and surrounding space")
+	}
+	if _, ok := LookupPreset("spicy"); ok {
+		t.Error("LookupPreset(\"spicy\") should fail")
+	}
+	if DefaultPreset().Name != "chaotic" {
+		t.Errorf("DefaultPreset() = %q, want chaotic", DefaultPreset().Name)
+	}
+}
+
+func TestPresets_Effects(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		preset, spec string
+		want         []string
+	}{
+		{"classic", "", []string{"cat", "potato", "text"}},
+		{"mild", "", []string{"zoom", "cat", "glow", "potato", "sparkles", "text"}},
+		{"mild", "-glow", []string{"zoom", "cat", "potato", "sparkles", "text"}},
+		{"classic", "ticker", []string{"cat", "potato", "ticker"}},
+		{"unhinged", "", EffectNames()},
+	}
+	for _, tt := range tests {
+		p, _ := LookupPreset(tt.preset)
+		effects, err := ParseEffects(tt.spec, p.Effects)
+		if err != nil {
+			t.Errorf("%s: ParseEffects(%q) error: %v", tt.preset, tt.spec, err)
+			continue
+		}
+		if got := effectNames(effects); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: ParseEffects(%q) = %v, want %v", tt.preset, tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestComputeFrameParams_Presets(t *testing.T) {
+	t.Parallel()
+
+	chaotic, _ := LookupPreset("chaotic")
+	unhinged, _ := LookupPreset("unhinged")
+
+	first := ComputeFrameParams(0, DefaultFrames, DefaultWidth, DefaultHeight, 5, unhinged.Chaos)
+	var maxShake [2]int
+	for i := range DefaultFrames {
+		c := ComputeFrameParams(i, DefaultFrames, DefaultWidth, DefaultHeight, 5, chaotic.Chaos)
+		u := ComputeFrameParams(i, DefaultFrames, DefaultWidth, DefaultHeight, 5, unhinged.Chaos)
+		if len(u.Clones) <= len(c.Clones) || len(u.Bursts) <= len(c.Bursts) || len(u.Sparkles) <= len(c.Sparkles) {
+			t.Fatalf("frame %d: unhinged has %d clones, %d bursts and %d sparkles; chaotic has %d, %d and %d",
+				i, len(u.Clones), len(u.Bursts), len(u.Sparkles), len(c.Clones), len(c.Bursts), len(c.Sparkles))
+		}
+		if abs(c.ShakeDX) > 3 || abs(c.ShakeDY) > 3 || abs(u.ShakeDX) > 12 || abs(u.ShakeDY) > 12 {
+			t.Errorf("frame %d: shake %d,%d and %d,%d out of range", i, c.ShakeDX, c.ShakeDY, u.ShakeDX, u.ShakeDY)
+		}
+		maxShake[0] = max(maxShake[0], abs(c.ShakeDX), abs(c.ShakeDY))
+		maxShake[1] = max(maxShake[1], abs(u.ShakeDX), abs(u.ShakeDY))
+
+		// Extra clones stay in the same place on every frame.
+		for j := range u.Clones {
+			if u.Clones[j].X != first.Clones[j].X || u.Clones[j].Y != first.Clones[j].Y {
+				t.Errorf("frame %d: clone %d moved from %d,%d to %d,%d", i, j, first.Clones[j].X, first.Clones[j].Y, u.Clones[j].X, u.Clones[j].Y)
+			}
+		}
+	}
+	if maxShake[1] <= maxShake[0] {
+		t.Errorf("unhinged shakes up to %d pixels, chaotic up to %d", maxShake[1], maxShake[0])
+	}
+
+	classic, _ := LookupPreset("classic")
+	p := ComputeFrameParams(5, DefaultFrames, DefaultWidth, DefaultHeight, 5, classic.Chaos)
+	if p.TextColor != color.White || p.FontScale != 1 || p.PotatoBounceY != 0 || p.PotatoRotation != 0 ||
+		p.ShakeDX != 0 || p.ShakeDY != 0 || p.ZoomScale != 1 || len(p.Sparkles)+len(p.Clones)+len(p.Bursts) != 0 {
+		t.Errorf("classic frame params animate: %+v", p)
+	}
+}
+
+// abs returns the absolute value of n.
+func abs(n int) int {
+	return max(n, -n)
+}
+
+func TestGenerate_ClassicIsStatic(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	classic, _ := LookupPreset("classic")
+	potato := newTestImage(200, 200, color.RGBA{R: 255, G: 200, B: 100, A: 255})
+	cat := newTestImage(640, 480, color.RGBA{R: 100, G: 100, B: 100, A: 255})
+	anim, err := g.Generate(potato, cat, "top", "bottom", Options{Effects: classic.Effects, Chaos: &classic.Chaos})
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	for i, frame := range anim.Frames[1:] {
+		if !bytes.Equal(frame.Pix, anim.Frames[0].Pix) {
+			t.Fatalf("frame %d differs from frame 0", i+1)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package meme
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// Effect is one step of drawing a meme frame, such as the glow behind the
+// potato or the news ticker.
+type Effect interface {
+	// Name is the short name used to select the effect, e.g. "glow".
+	Name() string
+	// Draw draws the effect onto dc for f. Effects that move the scene
+	// rather than draw on it, like shake, update f for the effects after
+	// them.
+	Draw(dc *gg.Context, f *Frame)
+}
+
+// Frame is everything an effect knows about the frame being drawn.
+type Frame struct {
+	// Index is the frame's position in the animation.
+	Index int
+	// Params are the animation values computed for the frame.
+	Params FrameParams
+	// Width and Height are the size of the canvas.
+	Width, Height int
+	// Scale is how much larger than the default the canvas is laid out.
+	// Effects scale their sizes and distances by it.
+	Scale float64
+	// Cat is the background, already scaled to the canvas.
+	Cat *image.RGBA
+	// Potato is the main potato, already scaled, and PotatoX and PotatoY
+	// are where its top-left corner is drawn, bounce included.
+	Potato           *image.RGBA
+	PotatoX, PotatoY int
+	// TopText and BottomText are the meme text, upper-cased.
+	TopText, BottomText string
+	// captions are TopText and BottomText laid out to fit the canvas.
+	captions [2]textLayout
+	// Ticker is the news ticker message.
+	Ticker string
+	// Font is the meme font.
+	Font *truetype.Font
+
+	// Zoom and Shake are how far the background is scaled and moved. They
+	// are 1 and zero unless the zoom and shake effects set them.
+	Zoom  float64
+	Shake image.Point
+}
+
+// potatoCenter returns the center of the main potato.
+func (f *Frame) potatoCenter() (x, y float64) {
+	b := f.Potato.Bounds()
+	return float64(f.PotatoX) + float64(b.Dx())/2, float64(f.PotatoY) + float64(b.Dy())/2
+}
+
+// effect is an Effect implemented by a function.
+type effect struct {
+	name string
+	draw func(dc *gg.Context, f *Frame)
+}
+
+func (e effect) Name() string                  { return e.name }
+func (e effect) Draw(dc *gg.Context, f *Frame) { e.draw(dc, f) }
+
+// effects lists every effect in the order they are drawn, bottom layer
+// first.
+var effects = []Effect{
+	effect{"shake", func(_ *gg.Context, f *Frame) {
+		f.Shake = image.Pt(f.Params.ShakeDX, f.Params.ShakeDY)
+	}},
+	effect{"zoom", func(_ *gg.Context, f *Frame) {
+		f.Zoom = f.Params.ZoomScale
+	}},
+	effect{"cat", func(dc *gg.Context, f *Frame) {
+		drawZoomedBackground(dc, f.Cat, f.Zoom, f.Shake.X, f.Shake.Y)
+	}},
+	effect{"hypno", func(dc *gg.Context, f *Frame) {
+		drawHypnoWheel(dc, float64(f.Width)/2, float64(f.Height)/2,
+			float64(f.Width)*0.8, f.Params.SpiralAngle, 0.08)
+	}},
+	effect{"glow", func(dc *gg.Context, f *Frame) {
+		cx, cy := f.potatoCenter()
+		drawDivineGlow(dc, cx, cy, f.Params.GlowRadius, f.Params.GlowAlpha)
+	}},
+	effect{"potato", func(dc *gg.Context, f *Frame) {
+		cx, cy := f.potatoCenter()
+		dc.Push()
+		dc.RotateAbout(f.Params.PotatoRotation, cx, cy)
+		dc.DrawImage(f.Potato, f.PotatoX, f.PotatoY)
+		dc.Pop()
+	}},
+	effect{"clones", func(dc *gg.Context, f *Frame) {
+		// Clones are scaled down from the main potato's frame so they
+		// animate with it.
+		drawPotatoClones(dc, f.Potato, f.Params.Clones, f.Scale)
+	}},
+	effect{"bursts", func(dc *gg.Context, f *Frame) {
+		drawComicBursts(dc, f.Font, f.Params.Bursts, f.Scale)
+	}},
+	effect{"sparkles", func(dc *gg.Context, f *Frame) {
+		for _, sp := range f.Params.Sparkles {
+			drawSparkle(dc, sp.X, sp.Y, sp.Size, sp.Alpha, f.Scale)
+		}
+	}},
+	effect{"text", func(dc *gg.Context, f *Frame) {
+		shift := max(1, int(math.Round(outlineShift*f.Scale)))
+		margin := textMargin * f.Scale
+		top, bottom := f.captions[0], f.captions[1]
+		top.draw(dc, f.Font, float64(f.Width)/2, margin, f.Params.FontScale, false, shift, f.Params.TextColor)
+		bottom.draw(dc, f.Font, float64(f.Width)/2, float64(f.Height)-margin, f.Params.FontScale, true, shift, f.Params.TextColor)
+	}},
+	effect{"ticker", func(dc *gg.Context, f *Frame) {
+		drawTicker(dc, f.Font, f.Ticker, f.Params.TickerX, f.Scale)
+	}},
+}
+
+// required names the effects every meme draws, whatever is selected.
+var required = []string{"cat", "potato"}
+
+// Effects returns every effect in drawing order. It is what a render uses
+// when Options.Effects is nil.
+func Effects() []Effect {
+	return slices.Clone(effects)
+}
+
+// EffectNames returns the names of every effect in drawing order.
+func EffectNames() []string {
+	names := make([]string, len(effects))
+	for i, e := range effects {
+		names[i] = e.Name()
+	}
+	return names
+}
+
+// LookupEffect returns the effect registered under name. Names are matched
+// case-insensitively.
+func LookupEffect(name string) (Effect, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, e := range effects {
+		if e.Name() == name {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// ParseEffects parses a comma-separated effect selection. A list of names,
+// as in "glow,sparkles,text", selects just those effects; a list of names
+// each prefixed with "-", as in "-shake,-ticker", selects defaults but
+// those. "none" selects no optional effects. The cat and the potato are
+// always drawn. The result is in drawing order; an empty s selects
+// defaults.
+func ParseEffects(s string, defaults []Effect) ([]Effect, error) {
+	named := make(map[string]bool)
+	var include, exclude, none bool
+	for name := range strings.SplitSeq(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "none" {
+			none = true
+			continue
+		}
+		excluded := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		if _, ok := LookupEffect(name); !ok {
+			return nil, fmt.Errorf("unknown effect %q (supported: %s)", name, strings.Join(EffectNames(), ", "))
+		}
+		if excluded && slices.Contains(required, name) {
+			return nil, fmt.Errorf("the %s effect can't be turned off", name)
+		}
+		include = include || !excluded
+		exclude = exclude || excluded
+		named[name] = true
+	}
+	if exclude && (include || none) {
+		return nil, fmt.Errorf("effects must either all be excluded with - or all be named")
+	}
+	if !include && !exclude && !none {
+		return defaults, nil
+	}
+
+	var selected []Effect
+	if exclude {
+		for _, e := range defaults {
+			if !named[e.Name()] {
+				selected = append(selected, e)
+			}
+		}
+		return selected, nil
+	}
+	for _, e := range effects {
+		if named[e.Name()] || slices.Contains(required, e.Name()) {
+			selected = append(selected, e)
+		}
+	}
+	return selected, nil
+}
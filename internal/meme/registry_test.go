@@ -0,0 +1,121 @@
+package meme
+
+import (
+	"image/color"
+	"slices"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+// effectNames returns the names of effects.
+func effectNames(effects []Effect) []string {
+	var names []string
+	for _, e := range effects {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestParseEffects(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"", EffectNames()},
+		{"glow,sparkles,text", []string{"cat", "glow", "potato", "sparkles", "text"}},
+		{"Text, GLOW ,", []string{"cat", "glow", "potato", "text"}},
+		{"-shake,-ticker", []string{"zoom", "cat", "hypno", "glow", "potato", "clones", "bursts", "sparkles", "text"}},
+		{"none", []string{"cat", "potato"}},
+		{"none,text", []string{"cat", "potato", "text"}},
+		{"potato", []string{"cat", "potato"}},
+	}
+	for _, tt := range tests {
+		effects, err := ParseEffects(tt.spec, Effects())
+		if err != nil {
+			t.Errorf("ParseEffects(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if got := effectNames(effects); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseEffects(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"glitter", "-glitter", "glow,-ticker", "none,-ticker", "-cat", "-potato"} {
+		if _, err := ParseEffects(spec, Effects()); err == nil {
+			t.Errorf("ParseEffects(%q): expected error", spec)
+		}
+	}
+}
+
+func TestLookupEffect(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range EffectNames() {
+		e, ok := LookupEffect(name)
+		if !ok || e.Name() != name {
+			t.Errorf("LookupEffect(%q) = %v, %v", name, e, ok)
+		}
+	}
+	if _, ok := LookupEffect(" Sparkles "); !ok {
+		t.Error("LookupEffect() should ignore case and surrounding space")
+	}
+	if _, ok := LookupEffect("glitter"); ok {
+		t.Error("LookupEffect(\"glitter\") should fail")
+	}
+}
+
+// recordingEffect is an Effect that records the frames it draws.
+type recordingEffect struct {
+	frames []int
+}
+
+func (e *recordingEffect) Name() string { return "recording" }
+
+func (e *recordingEffect) Draw(dc *gg.Context, f *Frame) {
+	e.frames = append(e.frames, f.Index)
+	dc.SetColor(color.White)
+	dc.SetPixel(0, 0)
+}
+
+func TestGenerate_Effects(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	catColor := color.RGBA{R: 100, G: 100, B: 100, A: 255}
+	potato := newTestImage(200, 200, color.RGBA{R: 255, G: 200, B: 100, A: 255})
+	cat := newTestImage(640, 480, catColor)
+
+	plain, err := ParseEffects("none", Effects())
+	if err != nil {
+		t.Fatalf("ParseEffects() error: %v", err)
+	}
+	anim, err := g.Generate(potato, cat, "top", "bottom", Options{Effects: plain})
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	// Without shake, zoom or ticker the cat reaches every edge, unmoved.
+	for i, frame := range anim.Frames {
+		for _, p := range [][2]int{{0, 0}, {639, 0}, {0, 479}, {639, 479}} {
+			if got := frame.RGBAAt(p[0], p[1]); got != catColor {
+				t.Fatalf("frame %d at %v = %v, want the cat's %v", i, p, got, catColor)
+			}
+		}
+	}
+
+	rec := &recordingEffect{}
+	anim, err = g.Generate(potato, cat, "top", "bottom", Options{Effects: append(plain, rec)})
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}; !slices.Equal(rec.frames, want) {
+		t.Errorf("custom effect drew frames %v, want %v", rec.frames, want)
+	}
+	if got := anim.Frames[0].RGBAAt(0, 0); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("custom effect's pixel = %v, want white", got)
+	}
+}
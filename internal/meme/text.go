@@ -0,0 +1,97 @@
+package meme
+
+import (
+	"image/color"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// Sizes in pixels below are at the default canvas size.
+const (
+	// textPadding is the space kept clear between a caption and the left
+	// and right edges.
+	textPadding = 20
+	// minFontSize is the smallest a caption is shrunk to fit.
+	minFontSize = 12
+	// textBoxHeight is the most of the canvas's height each caption takes.
+	textBoxHeight = 0.3
+	// lineSpacing is the distance between the lines of a caption, as a
+	// multiple of the font size.
+	lineSpacing = 1.1
+	// shrinkStep is how much smaller each attempt to fit a caption is.
+	shrinkStep = 0.9
+)
+
+// textLayout is a caption broken into lines that fit the canvas.
+type textLayout struct {
+	lines []string
+	size  float64 // font size when the pulse is at 1
+}
+
+// layoutText wraps text into lines no wider than maxW at the largest size, up
+// to size, at which they also fit in maxH. Sizes are tried as if multiplied
+// by maxScale, the largest the pulse makes the text, so the text fits on
+// every frame and its lines break in the same places on each. Text that
+// doesn't fit even at minSize is laid out at minSize anyway.
+func layoutText(f *truetype.Font, text string, size, minSize, maxScale, maxW, maxH float64) textLayout {
+	for {
+		face := truetype.NewFace(f, &truetype.Options{Size: size * maxScale})
+		lines := wrapText(face, text, maxW)
+		fits := float64(len(lines))*size*maxScale*lineSpacing <= maxH
+		for _, line := range lines {
+			fits = fits && measureText(face, line) <= maxW
+		}
+		if fits || size <= minSize {
+			return textLayout{lines: lines, size: size}
+		}
+		size = max(minSize, size*shrinkStep)
+	}
+}
+
+// wrapText breaks text into lines no wider than maxW in face, between words.
+// Line breaks in text are kept. A word wider than maxW gets a line to
+// itself.
+func wrapText(face font.Face, text string, maxW float64) []string {
+	var lines []string
+	for paragraph := range strings.SplitSeq(text, "\n") {
+		var line string
+		for _, word := range strings.Fields(paragraph) {
+			if line == "" {
+				line = word
+				continue
+			}
+			if measureText(face, line+" "+word) > maxW {
+				lines = append(lines, line)
+				line = word
+				continue
+			}
+			line += " " + word
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// measureText returns how wide s is drawn in face, in pixels.
+func measureText(face font.Face, s string) float64 {
+	return float64(font.MeasureString(face, s)) / 64
+}
+
+// draw draws the caption's lines at its size times fontScale, each centered
+// on cx. The first line is centered on y, with the rest below it, or, if up
+// is set, the last line is, with the rest above it.
+func (l textLayout) draw(dc *gg.Context, f *truetype.Font, cx, y, fontScale float64, up bool, shift int, fillColor color.Color) {
+	size := l.size * fontScale
+	face := truetype.NewFace(f, &truetype.Options{Size: size})
+	if up {
+		y -= float64(len(l.lines)-1) * size * lineSpacing
+	}
+	for i, line := range l.lines {
+		drawMemeText(dc, face, line, cx, y+float64(i)*size*lineSpacing, shift, fillColor)
+	}
+}
@@ -0,0 +1,127 @@
+package meme
+
+import (
+	"image/color"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/golang/freetype/truetype"
+)
+
+func TestLayoutText(t *testing.T) {
+	t.Parallel()
+
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	const (
+		maxScale = 1.15
+		maxW     = DefaultWidth - 2*textPadding
+		maxH     = DefaultHeight * textBoxHeight
+	)
+	tests := []struct {
+		text     string
+		lines    int  // 0 to only require more than one
+		fullSize bool // whether the text fits without shrinking
+		want     []string
+	}{
+		{"I CAN HAZ", 1, true, []string{"I CAN HAZ"}},
+		{"WE HAVE A CAT AT HOME. THE CAT AT HOME:", 2, true, nil},
+		{"ROSES ARE RED\nPOTATOES ARE BROWN", 2, true, []string{"ROSES ARE RED", "POTATOES ARE BROWN"}},
+		{strings.Repeat("THE POTATO CAT HAS RISEN AND IT IS HUNGRY ", 6), 0, false, nil},
+		{"", 0, true, nil},
+	}
+	for _, tt := range tests {
+		l := layoutText(g.font, tt.text, fontSize, minFontSize, maxScale, maxW, maxH)
+		if tt.lines != 0 && len(l.lines) != tt.lines {
+			t.Errorf("%q: %d lines %q, want %d", tt.text, len(l.lines), l.lines, tt.lines)
+		}
+		if tt.text != "" && tt.lines == 0 && len(l.lines) < 2 {
+			t.Errorf("%q: %d lines, want several", tt.text, len(l.lines))
+		}
+		if tt.want != nil && !slices.Equal(l.lines, tt.want) {
+			t.Errorf("%q: lines = %q, want %q", tt.text, l.lines, tt.want)
+		}
+		if (l.size == fontSize) != tt.fullSize {
+			t.Errorf("%q: size = %v, want full size %v: %v", tt.text, l.size, fontSize, tt.fullSize)
+		}
+		if got := strings.Join(strings.Fields(strings.Join(l.lines, " ")), " "); got != strings.Join(strings.Fields(tt.text), " ") {
+			t.Errorf("%q: lines %q lose or reorder words", tt.text, l.lines)
+		}
+
+		// Every line fits at the largest size the pulse draws it at, and so
+		// do the lines together.
+		face := truetype.NewFace(g.font, &truetype.Options{Size: l.size * maxScale})
+		for _, line := range l.lines {
+			if w := measureText(face, line); w > maxW {
+				t.Errorf("%q: line %q is %.1f pixels wide, more than %d", tt.text, line, w, maxW)
+			}
+		}
+		if h := float64(len(l.lines)) * l.size * maxScale * lineSpacing; h > maxH {
+			t.Errorf("%q: %d lines are %.1f pixels tall, more than %v", tt.text, len(l.lines), h, maxH)
+		}
+	}
+
+	// Text that can't fit stops shrinking at the smallest size.
+	l := layoutText(g.font, strings.Repeat("SPUD ", 500), fontSize, minFontSize, maxScale, maxW, maxH)
+	if l.size != minFontSize {
+		t.Errorf("overflowing text size = %v, want %v", l.size, minFontSize)
+	}
+}
+
+func TestGenerate_TextFitsCanvas(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() error: %v", err)
+	}
+
+	text, err := ParseEffects("none,text", Effects())
+	if err != nil {
+		t.Fatalf("ParseEffects() error: %v", err)
+	}
+	blue := color.RGBA{B: 255, A: 255}
+	potato := newTestImage(10, 10, blue)
+	cat := newTestImage(640, 480, blue)
+	chaos := Chaos{TextPulse: 0.15}
+
+	for _, opts := range []Options{
+		{Effects: text, Chaos: &chaos},
+		{Effects: text, Chaos: &chaos, Width: 320, Height: 320, Frames: 4},
+	} {
+		opts = opts.withDefaults()
+		anim, err := g.Generate(potato, cat, "mom can we have a cat", "we have a cat at home. the cat at home:", opts)
+		if err != nil {
+			t.Fatalf("Generate() error: %v", err)
+		}
+
+		// Measure the widest rendered line of each caption, as the span of
+		// the rows with text in them. The outline and antialiasing may reach
+		// a few pixels past the padding.
+		scale := layoutScale(opts.Width, opts.Height)
+		slack := 2*outlineShift*scale + 2
+		lo := textPadding*scale - slack
+		hi := float64(opts.Width) - lo
+		for i, frame := range anim.Frames {
+			for _, half := range [][2]int{{0, opts.Height / 2}, {opts.Height / 2, opts.Height}} {
+				left, right := opts.Width, -1
+				for y := half[0]; y < half[1]; y++ {
+					for x := range opts.Width {
+						if frame.RGBAAt(x, y) != blue {
+							left, right = min(left, x), max(right, x)
+						}
+					}
+				}
+				if right < 0 {
+					t.Fatalf("%dx%d frame %d: no text in rows %v", opts.Width, opts.Height, i, half)
+				}
+				if float64(left) < lo || float64(right) > hi {
+					t.Errorf("%dx%d frame %d: text in rows %v spans x=%d to %d, want within %.0f to %.0f",
+						opts.Width, opts.Height, i, half, left, right, lo, hi)
+				}
+			}
+		}
+	}
+}
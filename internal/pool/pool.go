@@ -0,0 +1,174 @@
+// Package pool keeps decoded images, or other values, ready ahead of demand so
+// that requests don't have to wait on slow upstreams.
+package pool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// retryBaseDelay is how long a worker waits after a failed fetch. It
+	// doubles with each failure in a row, up to retryMaxDelay.
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// Source fetches one item, typically an image.
+type Source[T any] func(ctx context.Context) (T, error)
+
+// Config controls the size and refill pace of a pool.
+type Config struct {
+	// Depth is how many items are kept ready.
+	Depth int
+	// Workers is how many fetches may run at once.
+	Workers int
+	// RefillInterval is the minimum time between the starts of two fetches,
+	// which caps the load a pool puts on its upstream. Zero means no limit.
+	RefillInterval time.Duration
+}
+
+// Status is a snapshot of a pool's state and lifetime counters.
+type Status struct {
+	Name           string `json:"name"`
+	Ready          int    `json:"ready"`
+	Depth          int    `json:"depth"`
+	Workers        int    `json:"workers"`
+	RefillInterval string `json:"refill_interval"`
+	Fetched        uint64 `json:"fetched"`
+	Failed         uint64 `json:"failed"`
+	Hits           uint64 `json:"hits"`
+	Misses         uint64 `json:"misses"`
+	LastError      string `json:"last_error,omitempty"`
+}
+
+// Pool holds up to Config.Depth items fetched from a Source. Items are
+// handed out once each; every item taken makes room for a refill.
+type Pool[T any] struct {
+	name   string
+	source Source[T]
+	cfg    Config
+
+	ready chan T
+	// slots holds one token per item that is ready or being fetched, so
+	// that a full pool stops fetching.
+	slots chan struct{}
+
+	fetched, failed, hits, misses atomic.Uint64
+
+	mu      sync.Mutex
+	lastErr string
+}
+
+// New returns an empty pool that fills from source once Run is called. Depth
+// and Workers are raised to at least one.
+func New[T any](name string, source Source[T], cfg Config) *Pool[T] {
+	cfg.Depth = max(cfg.Depth, 1)
+	cfg.Workers = max(cfg.Workers, 1)
+	return &Pool[T]{
+		name:   name,
+		source: source,
+		cfg:    cfg,
+		ready:  make(chan T, cfg.Depth),
+		slots:  make(chan struct{}, cfg.Depth),
+	}
+}
+
+// Run fills the pool and keeps it full until ctx is canceled.
+func (p *Pool[T]) Run(ctx context.Context) {
+	var pace <-chan time.Time
+	if p.cfg.RefillInterval > 0 {
+		ticker := time.NewTicker(p.cfg.RefillInterval)
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for range p.cfg.Workers {
+		wg.Go(func() { p.work(ctx, pace) })
+	}
+	wg.Wait()
+}
+
+// work repeatedly claims a slot, waits for its turn, and fetches an item.
+// After a failure it backs off, so that an upstream that is down, or a
+// circuit breaker failing fast, doesn't keep it spinning.
+func (p *Pool[T]) work(ctx context.Context, pace <-chan time.Time) {
+	var backoff time.Duration
+	for first := true; ; first = false {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		// The first fetch of each worker goes straight out so that the pool
+		// warms up quickly after startup.
+		if pace != nil && !first {
+			select {
+			case <-pace:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		item, err := p.source(ctx)
+		if err != nil {
+			<-p.slots
+			if ctx.Err() != nil {
+				return
+			}
+			p.failed.Add(1)
+			p.mu.Lock()
+			p.lastErr = err.Error()
+			p.mu.Unlock()
+
+			backoff = min(max(2*backoff, retryBaseDelay), retryMaxDelay)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		backoff = 0
+		p.fetched.Add(1)
+		p.ready <- item
+	}
+}
+
+// Get takes a ready item without waiting. It reports false if the pool is
+// empty.
+func (p *Pool[T]) Get() (T, bool) {
+	select {
+	case item := <-p.ready:
+		<-p.slots
+		p.hits.Add(1)
+		return item, true
+	default:
+		p.misses.Add(1)
+		var zero T
+		return zero, false
+	}
+}
+
+// Status returns a snapshot of the pool.
+func (p *Pool[T]) Status() Status {
+	p.mu.Lock()
+	lastErr := p.lastErr
+	p.mu.Unlock()
+
+	return Status{
+		Name:           p.name,
+		Ready:          len(p.ready),
+		Depth:          p.cfg.Depth,
+		Workers:        p.cfg.Workers,
+		RefillInterval: p.cfg.RefillInterval.String(),
+		Fetched:        p.fetched.Load(),
+		Failed:         p.failed.Load(),
+		Hits:           p.hits.Load(),
+		Misses:         p.misses.Load(),
+		LastError:      lastErr,
+	}
+}
@@ -0,0 +1,177 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSource returns distinct 1-pixel-wide images whose height is the
+// call number, and counts its calls.
+type countingSource struct {
+	calls atomic.Int64
+	err   error
+}
+
+func (s *countingSource) fetch(ctx context.Context) (image.Image, error) {
+	n := s.calls.Add(1)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return image.NewRGBA(image.Rect(0, 0, 1, int(n))), nil
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// runPool starts p and stops it when the test ends.
+func runPool[T any](t *testing.T, p *Pool[T]) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestPool_FillsToDepthAndStops(t *testing.T) {
+	t.Parallel()
+
+	src := &countingSource{}
+	p := New("test", src.fetch, Config{Depth: 3, Workers: 2})
+	runPool(t, p)
+
+	waitFor(t, func() bool { return p.Status().Ready == 3 })
+	// Give the workers a chance to overfill if they were going to.
+	time.Sleep(20 * time.Millisecond)
+	if got := src.calls.Load(); got != 3 {
+		t.Errorf("source called %d times for a full pool of 3", got)
+	}
+}
+
+func TestPool_GetRefills(t *testing.T) {
+	t.Parallel()
+
+	src := &countingSource{}
+	p := New("test", src.fetch, Config{Depth: 2})
+	runPool(t, p)
+	waitFor(t, func() bool { return p.Status().Ready == 2 })
+
+	seen := map[int]bool{}
+	for range 5 {
+		waitFor(t, func() bool { return p.Status().Ready > 0 })
+		img, ok := p.Get()
+		if !ok {
+			t.Fatal("Get() on a ready pool returned false")
+		}
+		if h := img.Bounds().Dy(); seen[h] {
+			t.Fatalf("image %d handed out twice", h)
+		} else {
+			seen[h] = true
+		}
+	}
+
+	st := p.Status()
+	if st.Hits != 5 {
+		t.Errorf("Hits = %d, want 5", st.Hits)
+	}
+	if st.Fetched < 5 {
+		t.Errorf("Fetched = %d, want at least 5", st.Fetched)
+	}
+}
+
+func TestPool_EmptyGetDoesNotBlock(t *testing.T) {
+	t.Parallel()
+
+	// Never started, so never filled.
+	p := New("test", (&countingSource{}).fetch, Config{Depth: 1})
+	if _, ok := p.Get(); ok {
+		t.Fatal("Get() on an empty pool returned true")
+	}
+	if st := p.Status(); st.Misses != 1 || st.Hits != 0 {
+		t.Errorf("Status() = %+v, want 1 miss and no hits", st)
+	}
+}
+
+func TestPool_FailuresArePacedAndReported(t *testing.T) {
+	t.Parallel()
+
+	src := &countingSource{err: errors.New("upstream down")}
+	p := New("test", src.fetch, Config{Depth: 2, Workers: 1, RefillInterval: 20 * time.Millisecond})
+	runPool(t, p)
+
+	waitFor(t, func() bool { return p.Status().Failed >= 3 })
+	time.Sleep(50 * time.Millisecond)
+
+	st := p.Status()
+	if st.LastError != "upstream down" {
+		t.Errorf("LastError = %q, want %q", st.LastError, "upstream down")
+	}
+	if st.Ready != 0 {
+		t.Errorf("Ready = %d, want 0", st.Ready)
+	}
+	// Roughly 6 attempts fit in ~110ms at one per 20ms; an unpaced loop
+	// would make thousands.
+	if calls := src.calls.Load(); calls > 20 {
+		t.Errorf("source called %d times; refill interval not respected", calls)
+	}
+}
+
+func TestPool_FailuresBackOff(t *testing.T) {
+	t.Parallel()
+
+	// Without a refill interval only the backoff keeps the workers from
+	// spinning.
+	src := &countingSource{err: errors.New("upstream down")}
+	p := New("test", src.fetch, Config{Depth: 4, Workers: 4})
+	runPool(t, p)
+
+	time.Sleep(250 * time.Millisecond)
+	// Each worker fails at 0 and 100ms, then waits until 300ms.
+	if calls := src.calls.Load(); calls < 4 || calls > 12 {
+		t.Errorf("source called %d times in 250ms by 4 workers, want a few each", calls)
+	}
+}
+
+func TestPool_RunStopsOnCancel(t *testing.T) {
+	t.Parallel()
+
+	p := New("test", func(ctx context.Context) (image.Image, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, Config{Depth: 2, Workers: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	if st := p.Status(); st.Failed != 0 {
+		t.Errorf("Failed = %d; cancellation should not count as a failure", st.Failed)
+	}
+}
@@ -0,0 +1,301 @@
+package potato
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEjectAfter is how many consecutive failures eject a source.
+	DefaultEjectAfter = 3
+	// DefaultEjectFor is how long an ejected source is skipped.
+	DefaultEjectFor = 30 * time.Second
+	// latencySmoothing is the weight of the newest search in a source's
+	// average latency.
+	latencySmoothing = 0.2
+)
+
+// ErrListingsUnsupported is returned by Aggregator.SearchListing when none of
+// its sources is a ListingSearcher.
+var ErrListingsUnsupported = errors.New("no potato source supports listing options")
+
+// WeightedSource is one of the Searchers of an Aggregator.
+type WeightedSource struct {
+	// Name identifies the source in its SourceStatus.
+	Name     string
+	Searcher Searcher
+	// Weight is the source's share of searches relative to the other
+	// sources. A source with zero weight is only searched once the weighted
+	// sources have failed.
+	Weight float64
+}
+
+// HealthConfig controls when an Aggregator ejects a failing source. Zero
+// fields take their defaults.
+type HealthConfig struct {
+	// EjectAfter is how many consecutive failures eject a source.
+	EjectAfter int
+	// EjectFor is how long an ejected source is skipped. After that one
+	// more failure ejects it again.
+	EjectFor time.Duration
+}
+
+// SourceStatus is a snapshot of the health of one source of an Aggregator.
+type SourceStatus struct {
+	Name        string  `json:"name"`
+	Weight      float64 `json:"weight"`
+	Successes   uint64  `json:"successes"`
+	Failures    uint64  `json:"failures"`
+	SuccessRate float64 `json:"success_rate"`
+	// Latency is a moving average of the source's search times.
+	Latency      string    `json:"latency"`
+	Ejected      bool      `json:"ejected"`
+	EjectedUntil time.Time `json:"ejected_until,omitzero"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// sourceHealth is what an Aggregator tracks about one source.
+type sourceHealth struct {
+	successes, failures uint64
+	// consecutive counts failures since the last success.
+	consecutive int
+	// cleared is what the last success reset consecutive from, for Report
+	// to restore when that success turns out to be a broken image.
+	cleared      int
+	latency      time.Duration
+	ejectedUntil time.Time
+	lastError    string
+}
+
+// Aggregator is a Searcher that spreads searches over several sources by
+// weight. When a source fails, the search fails over to the others. A source
+// that fails HealthConfig.EjectAfter times in a row is ejected: it is skipped
+// for HealthConfig.EjectFor, unless every source is ejected.
+type Aggregator struct {
+	sources []WeightedSource
+	cfg     HealthConfig
+
+	mu     sync.Mutex
+	health []sourceHealth
+}
+
+// NewAggregator returns an Aggregator over sources. At least one source
+// must have a positive weight.
+func NewAggregator(sources []WeightedSource, cfg HealthConfig) (*Aggregator, error) {
+	if !slices.ContainsFunc(sources, func(s WeightedSource) bool { return s.Weight > 0 }) {
+		return nil, errors.New("aggregator needs a source with a positive weight")
+	}
+	for _, s := range sources {
+		if s.Weight < 0 || math.IsInf(s.Weight, 0) || math.IsNaN(s.Weight) {
+			return nil, fmt.Errorf("source %s: weight must be non-negative and finite", s.Name)
+		}
+	}
+	if cfg.EjectAfter <= 0 {
+		cfg.EjectAfter = DefaultEjectAfter
+	}
+	if cfg.EjectFor <= 0 {
+		cfg.EjectFor = DefaultEjectFor
+	}
+	return &Aggregator{
+		sources: sources,
+		cfg:     cfg,
+		health:  make([]sourceHealth, len(sources)),
+	}, nil
+}
+
+// SearchRandom searches the sources in an order drawn with rng until one
+// succeeds; a nil rng uses a randomly seeded source. The error of a search
+// that no source could serve joins the errors of all of them.
+func (a *Aggregator) SearchRandom(ctx context.Context, query string, rng *rand.Rand) (Result, error) {
+	return a.search(ctx, a.eligible(func(Searcher) bool { return true }), rng, func(s Searcher) (Result, error) {
+		return s.SearchRandom(ctx, query, rng)
+	})
+}
+
+// SearchListing is SearchRandom over the sources that are ListingSearchers.
+// It returns ErrListingsUnsupported if there are none.
+func (a *Aggregator) SearchListing(ctx context.Context, query string, o ListingOptions, rng *rand.Rand) (Result, error) {
+	eligible := a.eligible(func(s Searcher) bool {
+		_, ok := s.(ListingSearcher)
+		return ok
+	})
+	if len(eligible) == 0 {
+		return Result{}, ErrListingsUnsupported
+	}
+	return a.search(ctx, eligible, rng, func(s Searcher) (Result, error) {
+		return s.(ListingSearcher).SearchListing(ctx, query, o, rng)
+	})
+}
+
+// Load loads a result through the first source that is a Loader and accepts
+// it.
+func (a *Aggregator) Load(ctx context.Context, url string) ([]byte, error) {
+	err := fmt.Errorf("no potato source loads %q", url)
+	for _, s := range a.sources {
+		if l, ok := s.Searcher.(Loader); ok {
+			data, lerr := l.Load(ctx, url)
+			if lerr == nil {
+				return data, nil
+			}
+			err = lerr
+		}
+	}
+	return nil, err
+}
+
+// Report tells the source that found r whether its image could be loaded
+// and decoded. A load error turns the search, which was counted as a
+// success, into a failure, so a source that only finds broken images is
+// ejected like one whose searches fail. Results that didn't come from a
+// search of a, or loads that succeeded, change nothing.
+func (a *Aggregator) Report(r Result, err error) {
+	i := r.aggregated - 1
+	if err == nil || i < 0 || i >= len(a.sources) {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	h := &a.health[i]
+	if h.successes > 0 {
+		h.successes--
+	}
+	h.failures++
+	h.consecutive = max(h.consecutive, h.cleared) + 1
+	h.cleared = 0
+	h.lastError = err.Error()
+	if h.consecutive >= a.cfg.EjectAfter {
+		h.ejectedUntil = time.Now().Add(a.cfg.EjectFor)
+	}
+}
+
+// Status reports the health of every source, in configuration order.
+func (a *Aggregator) Status() []SourceStatus {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	statuses := make([]SourceStatus, len(a.sources))
+	for i, s := range a.sources {
+		h := a.health[i]
+		st := SourceStatus{
+			Name:      s.Name,
+			Weight:    s.Weight,
+			Successes: h.successes,
+			Failures:  h.failures,
+			Latency:   h.latency.String(),
+			LastError: h.lastError,
+		}
+		if total := h.successes + h.failures; total > 0 {
+			st.SuccessRate = float64(h.successes) / float64(total)
+		}
+		if now.Before(h.ejectedUntil) {
+			st.Ejected = true
+			st.EjectedUntil = h.ejectedUntil
+		}
+		statuses[i] = st
+	}
+	return statuses
+}
+
+// eligible returns the indexes of the sources whose Searcher satisfies ok.
+func (a *Aggregator) eligible(ok func(Searcher) bool) []int {
+	var indexes []int
+	for i, s := range a.sources {
+		if ok(s.Searcher) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// search tries the eligible sources in the order given by order until try
+// succeeds with one of them, recording the outcome of every attempt.
+func (a *Aggregator) search(ctx context.Context, eligible []int, rng *rand.Rand, try func(Searcher) (Result, error)) (Result, error) {
+	if ctx.Err() != nil {
+		return Result{}, ctx.Err()
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+
+	var errs []error
+	for _, i := range a.order(eligible, rng) {
+		start := time.Now()
+		result, err := try(a.sources[i].Searcher)
+		if ctx.Err() != nil {
+			// Running out of time is the caller's failure, not the source's.
+			return Result{}, ctx.Err()
+		}
+		a.record(i, time.Since(start), err)
+		if err == nil {
+			result.aggregated = i + 1
+			return result, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", a.sources[i].Name, err))
+	}
+	return Result{}, errors.Join(errs...)
+}
+
+// order returns the eligible sources in the order they are tried: the
+// weighted sources that aren't ejected, shuffled with rng so that each is
+// more likely to come first the higher its weight, then the zero-weight
+// ones. If every eligible source is ejected, all of them are tried.
+func (a *Aggregator) order(eligible []int, rng *rand.Rand) []int {
+	now := time.Now()
+	a.mu.Lock()
+	healthy := slices.DeleteFunc(slices.Clone(eligible), func(i int) bool {
+		return now.Before(a.health[i].ejectedUntil)
+	})
+	a.mu.Unlock()
+	if len(healthy) == 0 {
+		healthy = eligible
+	}
+
+	var weighted, spare []int
+	for _, i := range healthy {
+		if a.sources[i].Weight > 0 {
+			weighted = append(weighted, i)
+		} else {
+			spare = append(spare, i)
+		}
+	}
+
+	order := make([]int, 0, len(healthy))
+	for len(weighted) > 0 {
+		j := pickWeighted(len(weighted), func(j int) float64 { return a.sources[weighted[j]].Weight }, rng)
+		order = append(order, weighted[j])
+		weighted = slices.Delete(weighted, j, j+1)
+	}
+	return append(order, spare...)
+}
+
+// record notes the outcome of one search of source i.
+func (a *Aggregator) record(i int, latency time.Duration, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	h := &a.health[i]
+	if h.successes+h.failures == 0 {
+		h.latency = latency
+	} else {
+		h.latency += time.Duration(latencySmoothing * float64(latency-h.latency))
+	}
+
+	if err == nil {
+		h.successes++
+		h.cleared = h.consecutive
+		h.consecutive = 0
+		h.ejectedUntil = time.Time{}
+		return
+	}
+	h.failures++
+	h.consecutive++
+	h.lastError = err.Error()
+	if h.consecutive >= a.cfg.EjectAfter {
+		h.ejectedUntil = time.Now().Add(a.cfg.EjectFor)
+	}
+}
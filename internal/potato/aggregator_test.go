@@ -0,0 +1,299 @@
+package potato
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/imagedir"
+)
+
+// Compile-time check: Aggregator must be usable wherever its sources are.
+var (
+	_ Searcher        = (*Aggregator)(nil)
+	_ ListingSearcher = (*Aggregator)(nil)
+	_ Loader          = (*Aggregator)(nil)
+)
+
+// stubSearcher returns a result named after itself, or err while failing.
+type stubSearcher struct {
+	name    string
+	failing atomic.Bool
+	calls   atomic.Int64
+}
+
+func (s *stubSearcher) SearchRandom(ctx context.Context, query string, rng *rand.Rand) (Result, error) {
+	s.calls.Add(1)
+	if s.failing.Load() {
+		return Result{}, errors.New(s.name + " is down")
+	}
+	return Result{URL: "https://example.com/" + s.name + ".png", Source: s.name}, nil
+}
+
+// stubListingSearcher is a stubSearcher that takes listing options.
+type stubListingSearcher struct {
+	stubSearcher
+	lastListing ListingOptions
+}
+
+func (s *stubListingSearcher) SearchListing(ctx context.Context, query string, o ListingOptions, rng *rand.Rand) (Result, error) {
+	s.lastListing = o
+	return s.SearchRandom(ctx, query, rng)
+}
+
+func newAggregator(t *testing.T, cfg HealthConfig, sources ...WeightedSource) *Aggregator {
+	t.Helper()
+	a, err := NewAggregator(sources, cfg)
+	if err != nil {
+		t.Fatalf("NewAggregator() error: %v", err)
+	}
+	return a
+}
+
+func TestNewAggregator_Validates(t *testing.T) {
+	t.Parallel()
+
+	s := &stubSearcher{name: "a"}
+	for _, sources := range [][]WeightedSource{
+		nil,
+		{{Name: "a", Searcher: s, Weight: 0}},
+		{{Name: "a", Searcher: s, Weight: 1}, {Name: "b", Searcher: s, Weight: -1}},
+		{{Name: "a", Searcher: s, Weight: 1}, {Name: "b", Searcher: s, Weight: math.Inf(1)}},
+		{{Name: "a", Searcher: s, Weight: 1}, {Name: "b", Searcher: s, Weight: math.NaN()}},
+	} {
+		if _, err := NewAggregator(sources, HealthConfig{}); err == nil {
+			t.Errorf("NewAggregator(%+v) succeeded, want an error", sources)
+		}
+	}
+}
+
+func TestAggregator_Weights(t *testing.T) {
+	t.Parallel()
+
+	a := newAggregator(t, HealthConfig{},
+		WeightedSource{Name: "big", Searcher: &stubSearcher{name: "big"}, Weight: 3},
+		WeightedSource{Name: "small", Searcher: &stubSearcher{name: "small"}, Weight: 1},
+		WeightedSource{Name: "spare", Searcher: &stubSearcher{name: "spare"}, Weight: 0},
+	)
+
+	rng := rand.New(rand.NewPCG(3, 4))
+	counts := map[string]int{}
+	for range 4000 {
+		r, err := a.SearchRandom(context.Background(), "", rng)
+		if err != nil {
+			t.Fatalf("SearchRandom() error: %v", err)
+		}
+		counts[r.Source]++
+	}
+	if counts["big"] < 2800 || counts["big"] > 3200 || counts["spare"] != 0 {
+		t.Errorf("picks = %v, want about 3:1 and no spare", counts)
+	}
+}
+
+func TestAggregator_FailsOver(t *testing.T) {
+	t.Parallel()
+
+	primary := &stubSearcher{name: "primary"}
+	primary.failing.Store(true)
+	spare := &stubSearcher{name: "spare"}
+	a := newAggregator(t, HealthConfig{EjectAfter: 100},
+		WeightedSource{Name: "primary", Searcher: primary, Weight: 1},
+		WeightedSource{Name: "spare", Searcher: spare, Weight: 0},
+	)
+
+	r, err := a.SearchRandom(context.Background(), "", nil)
+	if err != nil || r.Source != "spare" {
+		t.Fatalf("SearchRandom() = %+v, %v; want the spare", r, err)
+	}
+
+	spare.failing.Store(true)
+	_, err = a.SearchRandom(context.Background(), "", nil)
+	if err == nil || !strings.Contains(err.Error(), "primary is down") || !strings.Contains(err.Error(), "spare is down") {
+		t.Errorf("SearchRandom() error = %v, want both failures", err)
+	}
+
+	st := a.Status()
+	if st[0].Failures != 2 || st[0].SuccessRate != 0 || st[0].LastError != "primary is down" {
+		t.Errorf("primary status = %+v", st[0])
+	}
+	if st[1].Successes != 1 || st[1].Failures != 1 || st[1].SuccessRate != 0.5 {
+		t.Errorf("spare status = %+v", st[1])
+	}
+}
+
+func TestAggregator_EjectsFailingSource(t *testing.T) {
+	t.Parallel()
+
+	flaky := &stubSearcher{name: "flaky"}
+	flaky.failing.Store(true)
+	steady := &stubSearcher{name: "steady"}
+	a := newAggregator(t, HealthConfig{EjectAfter: 2, EjectFor: time.Hour},
+		// flaky is nearly always tried first while it is in.
+		WeightedSource{Name: "flaky", Searcher: flaky, Weight: 1e9},
+		WeightedSource{Name: "steady", Searcher: steady, Weight: 1},
+	)
+	search := func() {
+		t.Helper()
+		if r, err := a.SearchRandom(context.Background(), "", nil); err != nil || r.Source != "steady" {
+			t.Fatalf("SearchRandom() = %+v, %v; want steady", r, err)
+		}
+	}
+
+	for range 5 {
+		search()
+	}
+	if n := flaky.calls.Load(); n != 2 {
+		t.Errorf("flaky searched %d times, want 2 before it was ejected", n)
+	}
+	if st := a.Status()[0]; !st.Ejected {
+		t.Errorf("flaky status = %+v, want ejected", st)
+	}
+
+	// Once readmitted, one more failure ejects it again.
+	readmit := func() {
+		a.mu.Lock()
+		a.health[0].ejectedUntil = time.Time{}
+		a.mu.Unlock()
+	}
+	readmit()
+	search()
+	search()
+	if n := flaky.calls.Load(); n != 3 {
+		t.Errorf("flaky searched %d times, want 3", n)
+	}
+
+	// A success clears its record.
+	readmit()
+	flaky.failing.Store(false)
+	if r, _ := a.SearchRandom(context.Background(), "", nil); r.Source != "flaky" {
+		t.Errorf("SearchRandom() = %+v, want flaky back", r)
+	}
+	if st := a.Status()[0]; st.Ejected || a.health[0].consecutive != 0 {
+		t.Errorf("flaky status = %+v, want healthy", st)
+	}
+}
+
+func TestAggregator_ReportEjectsBrokenImages(t *testing.T) {
+	t.Parallel()
+
+	broken := &stubSearcher{name: "broken"}
+	steady := &stubSearcher{name: "steady"}
+	a := newAggregator(t, HealthConfig{EjectAfter: 2, EjectFor: time.Hour},
+		WeightedSource{Name: "broken", Searcher: broken, Weight: 1e9},
+		WeightedSource{Name: "steady", Searcher: steady, Weight: 1},
+	)
+
+	// Every search of broken succeeds, but its images don't decode.
+	for range 2 {
+		r, err := a.SearchRandom(context.Background(), "", nil)
+		if err != nil || r.Source != "broken" {
+			t.Fatalf("SearchRandom() = %+v, %v; want broken", r, err)
+		}
+		a.Report(r, errors.New("decoding image: unknown format"))
+	}
+	st := a.Status()[0]
+	if !st.Ejected || st.Successes != 0 || st.Failures != 2 || st.LastError != "decoding image: unknown format" {
+		t.Errorf("broken status = %+v, want ejected after 2 failures", st)
+	}
+	r, err := a.SearchRandom(context.Background(), "", nil)
+	if err != nil || r.Source != "steady" {
+		t.Errorf("SearchRandom() = %+v, %v; want steady", r, err)
+	}
+
+	// Loaded images and results of other searchers change nothing.
+	a.Report(r, nil)
+	a.Report(Result{URL: "https://example.com/x.png", Source: "broken"}, errors.New("broken"))
+	if st := a.Status(); st[0].Failures != 2 || st[1].Successes != 1 || st[1].Failures != 0 {
+		t.Errorf("status = %+v, want it unchanged", st)
+	}
+}
+
+func TestAggregator_AllEjected(t *testing.T) {
+	t.Parallel()
+
+	only := &stubSearcher{name: "only"}
+	only.failing.Store(true)
+	a := newAggregator(t, HealthConfig{EjectAfter: 1, EjectFor: time.Hour},
+		WeightedSource{Name: "only", Searcher: only, Weight: 1},
+	)
+
+	a.SearchRandom(context.Background(), "", nil)
+	only.failing.Store(false)
+	if _, err := a.SearchRandom(context.Background(), "", nil); err != nil {
+		t.Errorf("with every source ejected, SearchRandom() error: %v; want them tried anyway", err)
+	}
+}
+
+func TestAggregator_CancellationIsNotAFailure(t *testing.T) {
+	t.Parallel()
+
+	s := &stubSearcher{name: "s"}
+	a := newAggregator(t, HealthConfig{}, WeightedSource{Name: "s", Searcher: s, Weight: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.SearchRandom(ctx, "", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("SearchRandom() error = %v, want context.Canceled", err)
+	}
+	if st := a.Status()[0]; st.Failures != 0 {
+		t.Errorf("status = %+v, want no failures", st)
+	}
+}
+
+func TestAggregator_SearchListing(t *testing.T) {
+	t.Parallel()
+
+	plain := &stubSearcher{name: "plain"}
+	a := newAggregator(t, HealthConfig{}, WeightedSource{Name: "plain", Searcher: plain, Weight: 1})
+	if _, err := a.SearchListing(context.Background(), "", ListingOptions{Sort: "new"}, nil); !errors.Is(err, ErrListingsUnsupported) {
+		t.Errorf("SearchListing() error = %v, want ErrListingsUnsupported", err)
+	}
+
+	reddit := &stubListingSearcher{stubSearcher: stubSearcher{name: "reddit"}}
+	a = newAggregator(t, HealthConfig{},
+		WeightedSource{Name: "plain", Searcher: plain, Weight: 1e9},
+		WeightedSource{Name: "reddit", Searcher: reddit, Weight: 1},
+	)
+	r, err := a.SearchListing(context.Background(), "", ListingOptions{Sort: "new"}, nil)
+	if err != nil || r.Source != "reddit" || reddit.lastListing.Sort != "new" {
+		t.Errorf("SearchListing() = %+v, %v; want the listing searcher with the options", r, err)
+	}
+}
+
+func TestAggregator_Load(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "spud.png"), []byte("spud"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := imagedir.New(root)
+	if err != nil {
+		t.Fatalf("imagedir.New() error: %v", err)
+	}
+	a := newAggregator(t, HealthConfig{},
+		WeightedSource{Name: "fallback", Searcher: FallbackSearcher{}, Weight: 1},
+		WeightedSource{Name: "local", Searcher: NewLocalSearcher(dir), Weight: 1},
+	)
+
+	r, err := a.SearchRandom(context.Background(), "spud", nil)
+	for err == nil && r.Source != SourceLocal {
+		r, err = a.SearchRandom(context.Background(), "spud", nil)
+	}
+	if err != nil {
+		t.Fatalf("SearchRandom() error: %v", err)
+	}
+	if data, err := a.Load(context.Background(), r.URL); err != nil || string(data) != "spud" {
+		t.Errorf("Load(%q) = %q, %v; want the local file", r.URL, data, err)
+	}
+	if _, err := a.Load(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("Load() of a file outside the directory succeeded")
+	}
+}
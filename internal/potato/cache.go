@@ -0,0 +1,235 @@
+package potato
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultListingTTL is how long a subreddit listing is served before it
+	// is revalidated.
+	DefaultListingTTL = 5 * time.Minute
+	// listingRetryDelay is how long a failed refresh is remembered before
+	// Reddit is tried again.
+	listingRetryDelay = 30 * time.Second
+	// defaultRateLimitWait applies when Reddit rate limits us without saying
+	// for how long.
+	defaultRateLimitWait = time.Minute
+	// maxRateLimitWait caps how long a single response can silence us.
+	maxRateLimitWait = 15 * time.Minute
+	// refreshTimeout bounds a listing refresh, which runs apart from the
+	// requests waiting on it.
+	refreshTimeout = 10 * time.Second
+	// maxListings caps how many listings are cached. Searches and
+	// per-request subreddits are keyed by what clients ask for, so without a
+	// cap they could grow the cache forever; the least recently used
+	// listing makes way.
+	maxListings = 256
+	// maxStaleAge is how long past its expiry a listing is kept to be
+	// served while Reddit fails. Listings in use are refreshed, or retried,
+	// long before.
+	maxStaleAge = time.Hour
+)
+
+// listing is the cached state of one subreddit listing or search, keyed by
+// its endpoint. Values are never modified once stored; a refresh
+// replaces them.
+type listing struct {
+	candidates   []Result
+	etag         string
+	lastModified string
+	expires      time.Time
+	// covers maps the listing's imgur album pages to their cover images.
+	covers map[string]string
+}
+
+// candidates returns the qualifying images of the listing at endpoint; see
+// listingURL. A listing younger than the TTL is served from the cache. Older
+// ones are revalidated with a conditional request, with concurrent callers
+// sharing one request. The shared request isn't canceled with the caller
+// that started it; each caller stops waiting when its own ctx is done. While
+// Reddit is failing or rate limiting us the previous listing keeps being
+// served, so an error means nothing usable is cached.
+func (rc *RedditClient) candidates(ctx context.Context, endpoint string) ([]Result, error) {
+	now := time.Now()
+	rc.mu.Lock()
+	prev := rc.listings[endpoint]
+	if prev != nil {
+		rc.lastUsed[endpoint] = now
+	}
+	blockedUntil := rc.blockedUntil
+	rc.mu.Unlock()
+
+	if prev != nil && now.Before(prev.expires) {
+		return prev.candidates, nil
+	}
+	if now.Before(blockedUntil) {
+		if prev != nil && len(prev.candidates) > 0 {
+			return prev.candidates, nil
+		}
+		return nil, fmt.Errorf("reddit rate limited until %s", blockedUntil.Format(time.RFC3339))
+	}
+
+	refreshed := rc.refresh.DoChan(endpoint, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+		defer cancel()
+		return rc.refreshListing(ctx, endpoint, prev)
+	})
+	select {
+	case res := <-refreshed:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]Result), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refreshListing fetches or revalidates the listing at endpoint and stores
+// the result.
+func (rc *RedditClient) refreshListing(ctx context.Context, endpoint string, prev *listing) ([]Result, error) {
+	resp, err := rc.fetchListing(ctx, endpoint, prev)
+	if err != nil {
+		return rc.keepStale(endpoint, prev, err)
+	}
+	defer resp.Body.Close()
+
+	rc.noteRateLimit(resp)
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && prev != nil:
+		next := *prev
+		next.expires = time.Now().Add(rc.listingTTL())
+		rc.store(endpoint, &next)
+		return next.candidates, nil
+	case resp.StatusCode != http.StatusOK:
+		return rc.keepStale(endpoint, prev, fmt.Errorf("reddit returned status %d", resp.StatusCode))
+	}
+
+	candidates, err := filterListing(resp)
+	if err != nil {
+		return rc.keepStale(endpoint, prev, err)
+	}
+	var known map[string]string
+	if prev != nil {
+		known = prev.covers
+	}
+	candidates, covers := rc.followImgur(ctx, candidates, known)
+	rc.store(endpoint, &listing{
+		candidates:   candidates,
+		covers:       covers,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		expires:      time.Now().Add(rc.listingTTL()),
+	})
+	return candidates, nil
+}
+
+// keepStale handles a failed refresh of the listing at key, including one
+// that timed out. The previous candidates, if any, are returned and kept for
+// listingRetryDelay before Reddit is asked again; with nothing cached, err
+// is returned.
+func (rc *RedditClient) keepStale(key string, prev *listing, err error) ([]Result, error) {
+	next := &listing{expires: time.Now().Add(listingRetryDelay)}
+	if prev != nil {
+		next.candidates = prev.candidates
+		next.etag = prev.etag
+		next.lastModified = prev.lastModified
+		next.covers = prev.covers
+	}
+	rc.store(key, next)
+
+	if len(next.candidates) == 0 {
+		return nil, err
+	}
+	return next.candidates, nil
+}
+
+// store caches l as the listing at key. Listings that expired more than
+// maxStaleAge ago are dropped, and then the least recently used ones until
+// at most maxListings are left.
+func (rc *RedditClient) store(key string, l *listing) {
+	now := time.Now()
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.listings == nil {
+		rc.listings = make(map[string]*listing)
+		rc.lastUsed = make(map[string]time.Time)
+	}
+	rc.listings[key] = l
+	rc.lastUsed[key] = now
+
+	for k, cached := range rc.listings {
+		if now.Sub(cached.expires) > maxStaleAge {
+			delete(rc.listings, k)
+			delete(rc.lastUsed, k)
+		}
+	}
+	for len(rc.listings) > maxListings {
+		oldest := key
+		for k, used := range rc.lastUsed {
+			if used.Before(rc.lastUsed[oldest]) {
+				oldest = k
+			}
+		}
+		delete(rc.listings, oldest)
+		delete(rc.lastUsed, oldest)
+	}
+}
+
+// noteRateLimit stops requests to Reddit for as long as resp asks: the
+// Retry-After of a 429 or 503, or the rest of the window once the
+// x-ratelimit-remaining budget is used up.
+func (rc *RedditClient) noteRateLimit(resp *http.Response) {
+	var wait time.Duration
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		wait = retryAfter(resp.Header.Get("Retry-After"))
+		if wait <= 0 {
+			wait = defaultRateLimitWait
+		}
+	}
+	// Reddit sends both as decimal seconds, e.g. "97.0" and "412".
+	remaining, err := strconv.ParseFloat(resp.Header.Get("X-Ratelimit-Remaining"), 64)
+	if err == nil && remaining < 1 {
+		if reset, err := strconv.ParseFloat(resp.Header.Get("X-Ratelimit-Reset"), 64); err == nil {
+			wait = max(wait, time.Duration(reset*float64(time.Second)))
+		}
+	}
+	if wait <= 0 {
+		return
+	}
+
+	until := time.Now().Add(min(wait, maxRateLimitWait))
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if until.After(rc.blockedUntil) {
+		rc.blockedUntil = until
+	}
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as an
+// HTTP date. It returns zero if the header is missing or invalid.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// listingTTL returns the configured TTL or the default.
+func (rc *RedditClient) listingTTL() time.Duration {
+	if rc.ttl > 0 {
+		return rc.ttl
+	}
+	return DefaultListingTTL
+}
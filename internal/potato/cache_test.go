@@ -0,0 +1,309 @@
+package potato
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const cachedListing = `{"data":{"children":[{"data":{"url":"https://i.redd.it/cached.jpg","post_hint":"image"}}]}}`
+
+// listingServer serves handler and returns a client for one subreddit that
+// talks to it, along with a count of the requests it received.
+func listingServer(t *testing.T, handler http.HandlerFunc) (*RedditClient, *atomic.Int64) {
+	t.Helper()
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	rc := &RedditClient{
+		httpClient: &http.Client{Transport: &rewriteTransport{base: srv.URL}},
+		subreddits: []Subreddit{{Name: "potato", Weight: 1}},
+	}
+	return rc, &requests
+}
+
+// endpoint returns the configured listing of sub.
+func endpoint(rc *RedditClient, sub string) string {
+	return listingURL(sub, "", rc.listingOptions(ListingOptions{}))
+}
+
+// expire makes the cached listing of sub due for a refresh.
+func expire(rc *RedditClient, sub string) {
+	key := endpoint(rc, sub)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	next := *rc.listings[key]
+	next.expires = time.Time{}
+	rc.listings[key] = &next
+}
+
+// search runs SearchRandom without a query and returns the image URL,
+// failing the test on error.
+func search(t *testing.T, rc *RedditClient) string {
+	t.Helper()
+	result, err := rc.SearchRandom(context.Background(), "", nil)
+	if err != nil {
+		t.Fatalf("SearchRandom() error: %v", err)
+	}
+	return result.URL
+}
+
+func TestCandidates_CachedWithinTTL(t *testing.T) {
+	t.Parallel()
+
+	rc, requests := listingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(cachedListing))
+	})
+
+	for range 5 {
+		if url := search(t, rc); url != "https://i.redd.it/cached.jpg" {
+			t.Fatalf("SearchRandom() = %q, want the listed image", url)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("reddit requested %d times, want 1", n)
+	}
+}
+
+func TestCandidates_ConditionalRevalidation(t *testing.T) {
+	t.Parallel()
+
+	const etag = `"v1"`
+	const modified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	var notModified atomic.Int64
+	rc, requests := listingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == modified {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified)
+		w.Write([]byte(cachedListing))
+	})
+
+	search(t, rc)
+	expire(rc, "potato")
+	if url := search(t, rc); url != "https://i.redd.it/cached.jpg" {
+		t.Fatalf("after 304: SearchRandom() = %q, want the cached image", url)
+	}
+	if notModified.Load() != 1 {
+		t.Fatalf("revalidation was not conditional")
+	}
+
+	// The 304 renewed the TTL.
+	search(t, rc)
+	if n := requests.Load(); n != 2 {
+		t.Errorf("reddit requested %d times, want 2", n)
+	}
+}
+
+func TestCandidates_StaleOnError(t *testing.T) {
+	t.Parallel()
+
+	var failing atomic.Bool
+	rc, requests := listingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(cachedListing))
+	})
+
+	search(t, rc)
+	failing.Store(true)
+	expire(rc, "potato")
+
+	for range 3 {
+		if url := search(t, rc); url != "https://i.redd.it/cached.jpg" {
+			t.Fatalf("SearchRandom() = %q, want the stale image rather than a fallback", url)
+		}
+	}
+	// The failure is remembered instead of retried on every request.
+	if n := requests.Load(); n != 2 {
+		t.Errorf("reddit requested %d times, want 2", n)
+	}
+}
+
+func TestCandidates_FallbackOnlyWhenEmpty(t *testing.T) {
+	t.Parallel()
+
+	rc, _ := listingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	if url := search(t, rc); !slices.Contains(fallbackURLs, url) {
+		t.Errorf("SearchRandom() = %q, want a fallback URL", url)
+	}
+}
+
+func TestCandidates_SharedRefreshOutlivesCanceledCaller(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	rc, requests := listingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte(cachedListing))
+	})
+
+	// The first caller starts the refresh and gives up on it.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := rc.candidates(ctx, endpoint(rc, "potato"))
+		first <- err
+	}()
+	<-started
+
+	// The second joins the refresh the first started.
+	second := make(chan error, 1)
+	go func() {
+		got, err := rc.candidates(context.Background(), endpoint(rc, "potato"))
+		if err == nil && len(got) != 1 {
+			err = fmt.Errorf("got %d candidates, want 1", len(got))
+		}
+		second <- err
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: candidates() error = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("waiting caller: candidates() error: %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("reddit requested %d times, want 1", n)
+	}
+}
+
+func TestCandidates_CacheIsBounded(t *testing.T) {
+	t.Parallel()
+
+	rc, _ := listingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(cachedListing))
+	})
+	o := rc.listingOptions(ListingOptions{})
+	configured := endpoint(rc, "potato")
+	if _, err := rc.candidates(context.Background(), configured); err != nil {
+		t.Fatalf("candidates() error: %v", err)
+	}
+
+	// Every query a client makes up is a listing of its own. The configured
+	// listing, in use all along, stays cached.
+	for i := range 2 * maxListings {
+		if _, err := rc.candidates(context.Background(), listingURL("potato", fmt.Sprintf("spud %d", i), o)); err != nil {
+			t.Fatalf("candidates() error: %v", err)
+		}
+		if _, err := rc.candidates(context.Background(), configured); err != nil {
+			t.Fatalf("candidates() error: %v", err)
+		}
+	}
+	rc.mu.Lock()
+	n, used := len(rc.listings), len(rc.lastUsed)
+	_, kept := rc.listings[configured]
+	rc.mu.Unlock()
+	if n != maxListings || used != maxListings {
+		t.Errorf("%d listings cached, %d used times; want %d", n, used, maxListings)
+	}
+	if !kept {
+		t.Error("the listing in use was evicted")
+	}
+
+	// Listings long past their expiry are dropped.
+	rc.store("stale", &listing{expires: time.Now().Add(-2 * maxStaleAge)})
+	rc.mu.Lock()
+	_, kept = rc.listings["stale"]
+	rc.mu.Unlock()
+	if kept {
+		t.Error("a listing expired for longer than maxStaleAge was kept")
+	}
+}
+
+func TestCandidates_HonorsRateLimits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		status  int
+		headers map[string]string
+	}{
+		{"429 with Retry-After", http.StatusTooManyRequests, map[string]string{"Retry-After": "120"}},
+		{"429 without Retry-After", http.StatusTooManyRequests, nil},
+		{"503 with Retry-After", http.StatusServiceUnavailable, map[string]string{"Retry-After": "120"}},
+		{"budget used up", http.StatusOK, map[string]string{"X-Ratelimit-Remaining": "0.0", "X-Ratelimit-Reset": "300"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var limited atomic.Bool
+			rc, requests := listingServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if limited.Load() {
+					for k, v := range tt.headers {
+						w.Header().Set(k, v)
+					}
+					w.WriteHeader(tt.status)
+				}
+				w.Write([]byte(cachedListing))
+			})
+			rc.subreddits = []Subreddit{{Name: "potato", Weight: 1}, {Name: "potatoes", Weight: 1}}
+
+			// Warm r/potato, then get limited while fetching r/potatoes.
+			if _, err := rc.candidates(context.Background(), endpoint(rc, "potato")); err != nil {
+				t.Fatalf("candidates(potato) error: %v", err)
+			}
+			limited.Store(true)
+			rc.candidates(context.Background(), endpoint(rc, "potatoes"))
+			expire(rc, "potato")
+
+			before := requests.Load()
+			got, err := rc.candidates(context.Background(), endpoint(rc, "potato"))
+			if err != nil || len(got) != 1 {
+				t.Fatalf("while limited: candidates(potato) = %v, %v; want the stale listing", got, err)
+			}
+			if n := requests.Load() - before; n != 0 {
+				t.Errorf("reddit requested %d times while rate limited", n)
+			}
+
+			rc.mu.Lock()
+			wait := time.Until(rc.blockedUntil)
+			rc.mu.Unlock()
+			if wait < time.Minute-time.Second || wait > maxRateLimitWait {
+				t.Errorf("blocked for %v, want between 1m and %v", wait, maxRateLimitWait)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		header   string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"30", 30 * time.Second, 30 * time.Second},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header); got < tt.min || got > tt.max {
+			t.Errorf("retryAfter(%q) = %v, want between %v and %v", tt.header, got, tt.min, tt.max)
+		}
+	}
+}
@@ -1,34 +1,101 @@
 package potato
 
-var fallbackURLs = []string{
-	"https://i.imgur.com/7UhJCiR.jpeg",
-	"https://i.imgur.com/3Y1QXWK.jpeg",
-	"https://i.imgur.com/8bFjQGV.jpeg",
-	"https://i.imgur.com/ZxJGPVo.jpeg",
-	"https://i.imgur.com/5mFGKqN.jpeg",
-	"https://i.imgur.com/Q9Xnv8r.jpeg",
-	"https://i.imgur.com/VJlMz8C.jpeg",
-	"https://i.imgur.com/kWjlGUy.jpeg",
-	"https://i.imgur.com/2HxLMhN.jpeg",
-	"https://i.imgur.com/RYqpRoL.jpeg",
-	"https://i.imgur.com/pTkG6Ck.jpeg",
-	"https://i.imgur.com/4fMjKYa.jpeg",
-	"https://i.imgur.com/WnDqSBR.jpeg",
-	"https://i.imgur.com/hXBmpBN.jpeg",
-	"https://i.imgur.com/JfKt5qA.jpeg",
-	"https://i.imgur.com/LmNOpQr.jpeg",
-	"https://i.imgur.com/StUvWxY.jpeg",
-	"https://i.imgur.com/AbCdEfG.jpeg",
-	"https://i.imgur.com/HiJkLmN.jpeg",
-	"https://i.imgur.com/OpQrStU.jpeg",
-	"https://i.imgur.com/VwXyZaB.jpeg",
-	"https://i.imgur.com/CdEfGhI.jpeg",
-	"https://i.imgur.com/JkLmNpQ.jpeg",
-	"https://i.imgur.com/RsTuVwX.jpeg",
-	"https://i.imgur.com/YzAbCdE.jpeg",
-	"https://i.imgur.com/FgHiJkL.jpeg",
-	"https://i.imgur.com/MnOpQrS.jpeg",
-	"https://i.imgur.com/TuVwXyZ.jpeg",
-	"https://i.imgur.com/1aBcDeF.jpeg",
-	"https://i.imgur.com/2gHiJkL.jpeg",
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"image"
+	"io/fs"
+	"math/rand/v2"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+)
+
+// fallbackScheme prefixes the URLs of the embedded fallback images.
+const fallbackScheme = "fallback:"
+
+//go:embed fallback/*.png
+var fallbackFS embed.FS
+
+// fallbackURLs names the embedded fallback images, sorted by file name.
+var fallbackURLs = func() []string {
+	entries, err := fs.ReadDir(fallbackFS, "fallback")
+	if err != nil {
+		panic(err)
+	}
+	urls := make([]string, len(entries))
+	for i, e := range entries {
+		urls[i] = fallbackScheme + e.Name()
+	}
+	return urls
+}()
+
+// fallbackImages decodes every fallback image on first use. The images are
+// part of the binary, so a failure is a build defect; TestFallbackImages
+// keeps it from shipping.
+var fallbackImages = sync.OnceValue(func() map[string]image.Image {
+	images := make(map[string]image.Image, len(fallbackURLs))
+	for _, u := range fallbackURLs {
+		data, err := fallbackFS.ReadFile(path.Join("fallback", strings.TrimPrefix(u, fallbackScheme)))
+		if err != nil {
+			panic(err)
+		}
+		img, _, err := decode.Decode(bytes.NewReader(data))
+		if err != nil {
+			panic(fmt.Sprintf("decoding embedded fallback %s: %v", u, err))
+		}
+		images[u] = img
+	}
+	return images
+})
+
+// fallbackResult describes the fallback image at url.
+func fallbackResult(url string) Result {
+	name := strings.TrimPrefix(url, fallbackScheme)
+	r := Result{
+		URL:    url,
+		Source: SourceFallback,
+		Title:  strings.TrimSuffix(name, path.Ext(name)),
+	}
+	if img, ok := Fallback(url); ok {
+		r.Width, r.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+	return r
+}
+
+// Fallback returns the decoded image for a fallback URL returned by a
+// Searcher. It reports false for any other URL, which has to be downloaded.
+// The image is shared and must not be modified.
+func Fallback(url string) (image.Image, bool) {
+	if !strings.HasPrefix(url, fallbackScheme) {
+		return nil, false
+	}
+	img, ok := fallbackImages()[url]
+	return img, ok
+}
+
+// FallbackSearcher picks from the embedded fallback potatoes. It never
+// fails, which makes it a last resort among the sources of an Aggregator.
+type FallbackSearcher struct{}
+
+// SearchRandom returns a fallback potato chosen with rng; a nil rng uses a
+// randomly seeded source. If some fallback names contain every word of query,
+// the potato is one of those.
+func (FallbackSearcher) SearchRandom(ctx context.Context, query string, rng *rand.Rand) (Result, error) {
+	if ctx.Err() != nil {
+		return Result{}, ctx.Err()
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	if matches := matchFiles(fallbackURLs, query); len(matches) > 0 {
+		result := fallbackResult(matches[rng.IntN(len(matches))])
+		result.Query = strings.TrimSpace(query)
+		return result, nil
+	}
+	return pickFallback(rng), nil
 }
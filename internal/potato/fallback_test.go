@@ -0,0 +1,58 @@
+package potato
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+)
+
+func TestFallbackImages(t *testing.T) {
+	t.Parallel()
+
+	files, err := fs.Glob(fallbackFS, "fallback/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 5 || len(fallbackURLs) != len(files) {
+		t.Fatalf("%d fallback URLs for %d embedded files, want at least 5 of each", len(fallbackURLs), len(files))
+	}
+
+	for _, url := range fallbackURLs {
+		img, ok := Fallback(url)
+		if !ok {
+			t.Errorf("Fallback(%q) did not return an image", url)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() < 100 || b.Dy() < 100 {
+			t.Errorf("Fallback(%q) is only %dx%d", url, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestFallback_OtherURLs(t *testing.T) {
+	t.Parallel()
+
+	for _, url := range []string{
+		"https://i.redd.it/potato.jpg",
+		"fallback:missing.png",
+		"file:///srv/potatoes/russet.png",
+		"",
+	} {
+		if _, ok := Fallback(url); ok {
+			t.Errorf("Fallback(%q) returned an image", url)
+		}
+	}
+}
+
+func TestFallbackSearcher(t *testing.T) {
+	t.Parallel()
+
+	r, err := FallbackSearcher{}.SearchRandom(context.Background(), "Red Bliss", nil)
+	if err != nil || r.URL != "fallback:red-bliss.png" || r.Query != "Red Bliss" {
+		t.Errorf("SearchRandom(Red Bliss) = %+v, %v; want the red bliss fallback", r, err)
+	}
+	r, err = FallbackSearcher{}.SearchRandom(context.Background(), "cat", nil)
+	if err != nil || r.Source != SourceFallback || r.Query != "" {
+		t.Errorf("SearchRandom(cat) = %+v, %v; want any fallback", r, err)
+	}
+}
@@ -0,0 +1,195 @@
+package potato
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// maxFeedBytes caps the size of a JSON feed.
+const maxFeedBytes = 5 << 20
+
+// FeedSearcher picks potatoes from a JSON feed of image links. The feed is
+// either an array of items or an object with an "items" array. Each item
+// needs a "url"; its "title", "author", "link", "width" and "height" are
+// reported when present.
+//
+// The feed is cached for its TTL. While refetching fails the previous items
+// keep being served, as with Reddit listings.
+type FeedSearcher struct {
+	httpClient *http.Client
+	url        string
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	items   []Result
+	expires time.Time
+	refresh singleflight.Group
+}
+
+// feedItem is one item of a JSON feed.
+type feedItem struct {
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Link   string `json:"link"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// NewFeedSearcher returns a FeedSearcher for the feed at feedURL, cached for
+// ttl. A zero ttl means DefaultListingTTL.
+func NewFeedSearcher(httpClient *http.Client, feedURL string, ttl time.Duration) *FeedSearcher {
+	if ttl <= 0 {
+		ttl = DefaultListingTTL
+	}
+	return &FeedSearcher{httpClient: httpClient, url: feedURL, ttl: ttl}
+}
+
+// SearchRandom returns a feed item chosen with rng; a nil rng uses a randomly
+// seeded source. If some item titles contain every word of query, the item is
+// one of those.
+func (fs *FeedSearcher) SearchRandom(ctx context.Context, query string, rng *rand.Rand) (Result, error) {
+	if ctx.Err() != nil {
+		return Result{}, ctx.Err()
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+
+	items, err := fs.feed(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	if matches := matchWords(items, query, func(r Result) string { return r.Title }); len(matches) > 0 {
+		result := matches[rng.IntN(len(matches))]
+		result.Query = strings.TrimSpace(query)
+		return result, nil
+	}
+	return items[rng.IntN(len(items))], nil
+}
+
+// feed returns the items of the feed, fetching it if the cached copy has
+// expired. As with Reddit listings, concurrent callers share one fetch that
+// isn't canceled with the caller that started it, and each caller stops
+// waiting when its own ctx is done. A fetch that fails or times out keeps
+// the previous items; an error means nothing usable is cached.
+func (fs *FeedSearcher) feed(ctx context.Context) ([]Result, error) {
+	fs.mu.Lock()
+	items, expires := fs.items, fs.expires
+	fs.mu.Unlock()
+	if time.Now().Before(expires) && len(items) > 0 {
+		return items, nil
+	}
+
+	refreshed := fs.refresh.DoChan("", func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+		defer cancel()
+		fresh, err := fs.fetch(ctx)
+		if err != nil {
+			if len(items) > 0 {
+				fs.store(items, listingRetryDelay)
+				return items, nil
+			}
+			return nil, err
+		}
+		fs.store(fresh, fs.ttl)
+		return fresh, nil
+	})
+	select {
+	case res := <-refreshed:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]Result), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// store caches items for ttl.
+func (fs *FeedSearcher) store(items []Result, ttl time.Duration) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.items = items
+	fs.expires = time.Now().Add(ttl)
+}
+
+// fetch downloads and decodes the feed.
+func (fs *FeedSearcher) fetch(ctx context.Context) ([]Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fs.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating feed request: %w", err)
+	}
+	req.Header.Set("User-Agent", "potato-nice-thelma/1.0")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := fs.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing feed request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading feed: %w", err)
+	}
+	if len(body) > maxFeedBytes {
+		return nil, fmt.Errorf("feed exceeds %d bytes", maxFeedBytes)
+	}
+
+	items, err := parseFeed(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("feed has no usable items")
+	}
+	return items, nil
+}
+
+// parseFeed decodes a feed, dropping items without an http or https URL.
+func parseFeed(body []byte) ([]Result, error) {
+	var feed struct {
+		Items []feedItem `json:"items"`
+	}
+	var err error
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &feed.Items)
+	} else {
+		err = json.Unmarshal(trimmed, &feed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding feed: %w", err)
+	}
+
+	var items []Result
+	for _, item := range feed.Items {
+		u, err := url.Parse(item.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		items = append(items, Result{
+			URL:       item.URL,
+			Source:    SourceFeed,
+			Permalink: item.Link,
+			Author:    item.Author,
+			Title:     item.Title,
+			Width:     item.Width,
+			Height:    item.Height,
+		})
+	}
+	return items, nil
+}
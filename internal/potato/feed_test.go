@@ -0,0 +1,179 @@
+package potato
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFeedSearcher(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		feed string
+	}{
+		{"array", `[
+			{"url": "https://example.com/russet.jpg", "title": "Big Russet", "author": "farmer", "link": "https://example.com/posts/1", "width": 640, "height": 480},
+			{"url": "https://example.com/yukon.jpg", "title": "Yukon Gold"},
+			{"url": "ftp://example.com/old.jpg", "title": "Old Russet"},
+			{"title": "No URL"}
+		]`},
+		{"object", `{"items": [
+			{"url": "https://example.com/russet.jpg", "title": "Big Russet", "author": "farmer", "link": "https://example.com/posts/1", "width": 640, "height": 480},
+			{"url": "https://example.com/yukon.jpg", "title": "Yukon Gold"}
+		]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.feed))
+			}))
+			defer srv.Close()
+			fs := NewFeedSearcher(srv.Client(), srv.URL, 0)
+
+			got, err := fs.SearchRandom(context.Background(), "russet", nil)
+			if err != nil {
+				t.Fatalf("SearchRandom() error: %v", err)
+			}
+			want := Result{
+				URL:       "https://example.com/russet.jpg",
+				Source:    SourceFeed,
+				Permalink: "https://example.com/posts/1",
+				Author:    "farmer",
+				Title:     "Big Russet",
+				Query:     "russet",
+				Width:     640,
+				Height:    480,
+			}
+			if got != want {
+				t.Errorf("SearchRandom(russet) = %+v, want %+v", got, want)
+			}
+
+			seen := map[string]bool{}
+			for seed := range uint64(20) {
+				r, err := fs.SearchRandom(context.Background(), "sweet potato", rand.New(rand.NewPCG(seed, seed)))
+				if err != nil {
+					t.Fatalf("SearchRandom() error: %v", err)
+				}
+				if r.Query != "" {
+					t.Errorf("unmatched query reported as %q", r.Query)
+				}
+				seen[r.URL] = true
+			}
+			if len(seen) != 2 {
+				t.Errorf("picked %v, want both usable items", seen)
+			}
+		})
+	}
+}
+
+func TestFeedSearcher_StaleOnError(t *testing.T) {
+	t.Parallel()
+
+	var failing atomic.Bool
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`[{"url": "https://example.com/spud.jpg"}]`))
+	}))
+	defer srv.Close()
+	fs := NewFeedSearcher(srv.Client(), srv.URL, 0)
+
+	if _, err := fs.SearchRandom(context.Background(), "", nil); err != nil {
+		t.Fatalf("SearchRandom() error: %v", err)
+	}
+	failing.Store(true)
+	fs.mu.Lock()
+	fs.expires = fs.expires.AddDate(-1, 0, 0)
+	fs.mu.Unlock()
+
+	for range 3 {
+		if r, err := fs.SearchRandom(context.Background(), "", nil); err != nil || r.URL != "https://example.com/spud.jpg" {
+			t.Fatalf("SearchRandom() = %+v, %v; want the stale item", r, err)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("feed requested %d times, want 2", n)
+	}
+}
+
+func TestFeedSearcher_SharedRefreshOutlivesCanceledCaller(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		w.Write([]byte(`[{"url": "https://example.com/spud.jpg"}]`))
+	}))
+	defer srv.Close()
+	a := newAggregator(t, HealthConfig{EjectAfter: 1, EjectFor: time.Hour},
+		WeightedSource{Name: "feed", Searcher: NewFeedSearcher(srv.Client(), srv.URL, 0), Weight: 1},
+	)
+
+	// The first caller starts the fetch and gives up on it.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := a.SearchRandom(ctx, "", nil)
+		first <- err
+	}()
+	<-started
+
+	// The second joins the fetch the first started.
+	second := make(chan error, 1)
+	go func() {
+		r, err := a.SearchRandom(context.Background(), "", nil)
+		if err == nil && r.URL != "https://example.com/spud.jpg" {
+			err = fmt.Errorf("got %+v, want the feed item", r)
+		}
+		second <- err
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: SearchRandom() error = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("waiting caller: SearchRandom() error: %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("feed requested %d times, want 1", n)
+	}
+	if st := a.Status()[0]; st.Failures != 0 || st.Successes != 1 || st.Ejected {
+		t.Errorf("feed status = %+v, want one success and no failures", st)
+	}
+}
+
+func TestFeedSearcher_Errors(t *testing.T) {
+	t.Parallel()
+
+	for _, body := range []string{`not json`, `[]`, `{"items": [{"url": "/relative.jpg"}]}`} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		_, err := NewFeedSearcher(srv.Client(), srv.URL, 0).SearchRandom(context.Background(), "", nil)
+		srv.Close()
+		if err == nil {
+			t.Errorf("feed %s: SearchRandom() succeeded, want an error", body)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package potato
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// maxImgurPage caps how much of an album page is read looking for its
+	// cover image.
+	maxImgurPage = 1 << 20
+	// imgurLookups is how many album pages are requested at once.
+	imgurLookups = 4
+)
+
+// imgurPageHosts serve imgur's HTML pages, as opposed to i.imgur.com, which
+// serves the images themselves.
+var imgurPageHosts = map[string]bool{"imgur.com": true, "www.imgur.com": true, "m.imgur.com": true}
+
+var (
+	imgurID = regexp.MustCompile(`^[A-Za-z0-9]{5,10}$`)
+	metaTag = regexp.MustCompile(`(?i)<meta\s[^>]*>`)
+	ogImage = regexp.MustCompile(`(?i)\sproperty=["']og:image["']`)
+	content = regexp.MustCompile(`(?i)\scontent=["']([^"']+)["']`)
+)
+
+// imgurImage rewrites a link to a single imgur image, such as
+// imgur.com/abc123, to the image itself. GIFV videos become their GIF. It
+// returns false for other URLs, including albums.
+func imgurImage(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case u.Host == "i.imgur.com" && strings.EqualFold(path.Ext(u.Path), ".gifv"):
+		return "https://i.imgur.com" + strings.TrimSuffix(u.Path, path.Ext(u.Path)) + ".gif", true
+	case imgurPageHosts[u.Host]:
+		// imgur serves any image under any of its extensions.
+		if id := strings.Trim(u.Path, "/"); imgurID.MatchString(id) {
+			return "https://i.imgur.com/" + id + ".jpg", true
+		}
+	}
+	return "", false
+}
+
+// isImgurAlbum reports whether raw links to an imgur album or gallery page.
+func isImgurAlbum(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || !imgurPageHosts[u.Host] {
+		return false
+	}
+	return strings.HasPrefix(u.Path, "/a/") || strings.HasPrefix(u.Path, "/gallery/")
+}
+
+// followImgur replaces the imgur album links among candidates with the
+// albums' cover images. Albums that can't be resolved are dropped. Covers
+// already known, from the previous version of the listing, aren't looked up
+// again; the covers of this listing's albums are returned to be kept with it.
+func (rc *RedditClient) followImgur(ctx context.Context, candidates []Result, known map[string]string) ([]Result, map[string]string) {
+	resolved := make([]string, len(candidates))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(imgurLookups)
+	for i, c := range candidates {
+		if !isImgurAlbum(c.URL) {
+			resolved[i] = c.URL
+			continue
+		}
+		if cover, ok := known[c.URL]; ok {
+			resolved[i] = cover
+			continue
+		}
+		g.Go(func() error {
+			// A failed album only loses its own image.
+			resolved[i], _ = rc.imgurCover(gctx, c.URL)
+			return nil
+		})
+	}
+	g.Wait()
+
+	var out []Result
+	var covers map[string]string
+	for i, c := range candidates {
+		if resolved[i] == "" {
+			continue
+		}
+		if resolved[i] != c.URL {
+			if covers == nil {
+				covers = make(map[string]string)
+			}
+			covers[c.URL] = resolved[i]
+		}
+		c.URL = resolved[i]
+		out = append(out, c)
+	}
+	return out, covers
+}
+
+// imgurCover returns the image imgur advertises for an album page in its
+// og:image tag.
+func (rc *RedditClient) imgurCover(ctx context.Context, page string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, page, nil)
+	if err != nil {
+		return "", fmt.Errorf("creating imgur request: %w", err)
+	}
+	req.Header.Set("User-Agent", "potato-nice-thelma/1.0")
+	resp, err := rc.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("executing imgur request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("imgur returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxImgurPage))
+	if err != nil {
+		return "", fmt.Errorf("reading imgur page: %w", err)
+	}
+
+	cover, err := ogImageURL(body)
+	if err != nil {
+		return "", fmt.Errorf("imgur album %s: %w", page, err)
+	}
+	return cover, nil
+}
+
+// ogImageURL finds the og:image of an imgur page. Only images on i.imgur.com
+// are accepted, without the query imgur adds for social previews.
+func ogImageURL(page []byte) (string, error) {
+	for _, tag := range metaTag.FindAll(page, -1) {
+		if !ogImage.Match(tag) {
+			continue
+		}
+		m := content.FindSubmatch(tag)
+		if m == nil {
+			continue
+		}
+		u, err := url.Parse(html.UnescapeString(string(m[1])))
+		if err != nil || u.Host != "i.imgur.com" {
+			continue
+		}
+		u.Scheme = "https"
+		u.RawQuery = ""
+		if isImageURL(u.String()) {
+			return u.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no og:image found")
+}
@@ -0,0 +1,57 @@
+package potato
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFollowImgur(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/a/XyZ98":
+			w.Write([]byte(`<html><head>
+				<meta name="twitter:image" content="https://i.imgur.com/wrong.jpg">
+				<meta property="og:image" content="https://i.imgur.com/Cover12.jpeg?fb&amp;x=1"/>
+			</head></html>`))
+		case "/gallery/empty":
+			w.Write([]byte(`<html><head><meta property="og:image" content="https://s.imgur.com/logo.png"></head></html>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	rc := &RedditClient{httpClient: &http.Client{Transport: &rewriteTransport{base: srv.URL}}}
+	candidates := []Result{
+		{URL: "https://i.redd.it/a.jpg", Title: "direct"},
+		{URL: "https://imgur.com/a/XyZ98", Title: "album"},
+		{URL: "https://imgur.com/gallery/empty", Title: "no cover"},
+		{URL: "https://imgur.com/a/missing", Title: "gone"},
+	}
+
+	var covers map[string]string
+	for range 2 {
+		var got []Result
+		got, covers = rc.followImgur(context.Background(), candidates, covers)
+		if len(got) != 2 || got[0].URL != "https://i.redd.it/a.jpg" || got[1].URL != "https://i.imgur.com/Cover12.jpeg" || got[1].Title != "album" {
+			t.Fatalf("followImgur() = %+v, want the direct image and the album cover", got)
+		}
+	}
+	// The cover was passed on; the failures were retried.
+	if n := requests.Load(); n != 5 {
+		t.Errorf("imgur requested %d times, want 5", n)
+	}
+	if len(covers) != 1 || covers["https://imgur.com/a/XyZ98"] != "https://i.imgur.com/Cover12.jpeg" {
+		t.Errorf("covers = %v, want only the album's cover", covers)
+	}
+	if candidates[1].URL != "https://imgur.com/a/XyZ98" {
+		t.Error("followImgur modified its input")
+	}
+}
@@ -0,0 +1,141 @@
+package potato
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Sorts are the listing sorts a RedditClient can draw posts from.
+var Sorts = []string{"hot", "top", "new", "rising"}
+
+// TimeWindows are the periods the top sort can cover.
+var TimeWindows = []string{"hour", "day", "week", "month", "year", "all"}
+
+const (
+	// DefaultSort is the sort used when none is configured.
+	DefaultSort = "hot"
+	// DefaultLimit is how many posts a listing asks for when no limit is
+	// configured.
+	DefaultLimit = 50
+	// MaxLimit is the most posts Reddit returns in one listing.
+	MaxLimit = 100
+)
+
+// subredditName matches the names Reddit allows, which also keeps them safe
+// to put in a URL path.
+var subredditName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_]{1,20}$`)
+
+// Subreddit is a subreddit potatoes are drawn from. Weight is its share of
+// the picks relative to the other subreddits.
+type Subreddit struct {
+	Name   string
+	Weight float64
+}
+
+// ParseSubreddits parses a comma-separated list of subreddit names, each
+// optionally followed by ":weight", as in "potato:3,potatoes". A leading
+// "r/" is ignored and the weight defaults to 1.
+func ParseSubreddits(s string) ([]Subreddit, error) {
+	var subs []Subreddit
+	for field := range strings.SplitSeq(s, ",") {
+		name, weight, hasWeight := strings.Cut(strings.TrimSpace(field), ":")
+		name = strings.TrimPrefix(name, "r/")
+		sub := Subreddit{Name: name, Weight: 1}
+		if hasWeight {
+			w, err := strconv.ParseFloat(weight, 64)
+			if err != nil || w <= 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+				return nil, fmt.Errorf("subreddit %q: weight must be a positive finite number, got %q", name, weight)
+			}
+			sub.Weight = w
+		}
+		if !subredditName.MatchString(sub.Name) {
+			return nil, fmt.Errorf("invalid subreddit name %q", sub.Name)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// ListingOptions selects the Reddit posts potatoes are drawn from. Zero
+// fields leave the client's configuration in place.
+type ListingOptions struct {
+	// Subreddits are picked from in proportion to their weights.
+	Subreddits []Subreddit
+	// Sort is one of Sorts.
+	Sort string
+	// Time is one of TimeWindows. It only applies to the top sort.
+	Time string
+	// Limit is how many posts a listing asks for, at most MaxLimit.
+	Limit int
+}
+
+// IsZero reports whether o changes nothing.
+func (o ListingOptions) IsZero() bool {
+	return len(o.Subreddits) == 0 && o.Sort == "" && o.Time == "" && o.Limit == 0
+}
+
+// Validate returns an error describing the first invalid field of o.
+func (o ListingOptions) Validate() error {
+	for _, sub := range o.Subreddits {
+		if !subredditName.MatchString(sub.Name) {
+			return fmt.Errorf("invalid subreddit name %q", sub.Name)
+		}
+		if sub.Weight <= 0 || math.IsInf(sub.Weight, 0) || math.IsNaN(sub.Weight) {
+			return fmt.Errorf("subreddit %q: weight must be positive and finite", sub.Name)
+		}
+	}
+	if o.Sort != "" && !slices.Contains(Sorts, o.Sort) {
+		return fmt.Errorf("sort must be one of %s, got %q", strings.Join(Sorts, ", "), o.Sort)
+	}
+	if o.Time != "" && !slices.Contains(TimeWindows, o.Time) {
+		return fmt.Errorf("time window must be one of %s, got %q", strings.Join(TimeWindows, ", "), o.Time)
+	}
+	if o.Limit < 0 || o.Limit > MaxLimit {
+		return fmt.Errorf("limit must be between 1 and %d, got %d", MaxLimit, o.Limit)
+	}
+	return nil
+}
+
+// merge returns o with its zero fields taken from def.
+func (o ListingOptions) merge(def ListingOptions) ListingOptions {
+	if len(o.Subreddits) == 0 {
+		o.Subreddits = def.Subreddits
+	}
+	if o.Sort == "" {
+		o.Sort = def.Sort
+	}
+	if o.Time == "" {
+		o.Time = def.Time
+	}
+	if o.Limit == 0 {
+		o.Limit = def.Limit
+	}
+	return o
+}
+
+// pickSubreddit chooses one of subs with rng, in proportion to the weights.
+func pickSubreddit(subs []Subreddit, rng *rand.Rand) string {
+	return subs[pickWeighted(len(subs), func(i int) float64 { return subs[i].Weight }, rng)].Name
+}
+
+// pickWeighted chooses an index below n with rng, in proportion to weight.
+// The weights must not all be zero.
+func pickWeighted(n int, weight func(i int) float64, rng *rand.Rand) int {
+	var total float64
+	for i := range n {
+		total += weight(i)
+	}
+	x := rng.Float64() * total
+	for i := range n {
+		if x < weight(i) {
+			return i
+		}
+		x -= weight(i)
+	}
+	return n - 1
+}
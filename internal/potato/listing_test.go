@@ -0,0 +1,81 @@
+package potato
+
+import (
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+func TestParseSubreddits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    []Subreddit
+		wantErr bool
+	}{
+		{"potato", []Subreddit{{"potato", 1}}, false},
+		{"potato:3, r/cats:0.5 ,potatoes", []Subreddit{{"potato", 3}, {"cats", 0.5}, {"potatoes", 1}}, false},
+		{"", nil, true},
+		{"potato,", nil, true},
+		{"potato:0", nil, true},
+		{"potato:lots", nil, true},
+		{"potato:NaN", nil, true},
+		{"potato:+Inf", nil, true},
+		{"potato:1e400", nil, true},
+		{"../admin", nil, true},
+		{"a", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSubreddits(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSubreddits(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSubreddits(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestListingOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		opts    ListingOptions
+		wantErr bool
+	}{
+		{"zero", ListingOptions{}, false},
+		{"all set", ListingOptions{Subreddits: []Subreddit{{"cats", 2}}, Sort: "top", Time: "month", Limit: MaxLimit}, false},
+		{"bad sort", ListingOptions{Sort: "controversial"}, true},
+		{"bad time", ListingOptions{Time: "decade"}, true},
+		{"limit too high", ListingOptions{Limit: MaxLimit + 1}, true},
+		{"negative limit", ListingOptions{Limit: -1}, true},
+		{"bad name", ListingOptions{Subreddits: []Subreddit{{"cats/../dogs", 1}}}, true},
+		{"zero weight", ListingOptions{Subreddits: []Subreddit{{"cats", 0}}}, true},
+		{"infinite weight", ListingOptions{Subreddits: []Subreddit{{"cats", math.Inf(1)}}}, true},
+		{"NaN weight", ListingOptions{Subreddits: []Subreddit{{"cats", math.NaN()}}}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPickSubreddit_Weights(t *testing.T) {
+	t.Parallel()
+
+	subs := []Subreddit{{"potato", 3}, {"cats", 1}}
+	rng := rand.New(rand.NewPCG(1, 2))
+	counts := map[string]int{}
+	for range 4000 {
+		counts[pickSubreddit(subs, rng)]++
+	}
+	// Expect about 3000 and 1000.
+	if counts["potato"] < 2800 || counts["potato"] > 3200 || counts["potato"]+counts["cats"] != 4000 {
+		t.Errorf("picks = %v, want about 3:1", counts)
+	}
+}
@@ -0,0 +1,94 @@
+package potato
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/imagedir"
+)
+
+// LocalSearcher picks potatoes from a local image directory. Its results are
+// file:// URLs, which it loads itself; they can't be fetched over HTTP.
+type LocalSearcher struct {
+	dir *imagedir.Dir
+}
+
+// NewLocalSearcher returns a LocalSearcher that picks from dir.
+func NewLocalSearcher(dir *imagedir.Dir) *LocalSearcher {
+	return &LocalSearcher{dir: dir}
+}
+
+// SearchRandom returns an image chosen with rng, titled with its file name; a
+// nil rng uses a randomly seeded source. If some file names contain every
+// word of query, the image is one of those, and otherwise any image.
+func (ls *LocalSearcher) SearchRandom(ctx context.Context, query string, rng *rand.Rand) (Result, error) {
+	if ctx.Err() != nil {
+		return Result{}, ctx.Err()
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+
+	result := Result{Source: SourceLocal}
+	files := ls.dir.Files()
+	if matches := matchFiles(files, query); len(matches) > 0 {
+		files = matches
+		result.Query = strings.TrimSpace(query)
+	}
+	path := files[rng.IntN(len(files))]
+
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	result.URL = u.String()
+	result.Title = filepath.Base(path)
+	return result, nil
+}
+
+// matchFiles returns the files whose names contain every word of query,
+// ignoring case. Dashes and underscores in names count as spaces. An empty
+// query matches nothing.
+func matchFiles(files []string, query string) []string {
+	separators := strings.NewReplacer("-", " ", "_", " ")
+	return matchWords(files, query, func(f string) string {
+		return separators.Replace(filepath.Base(f))
+	})
+}
+
+// matchWords returns the items whose text contains every word of query,
+// ignoring case. An empty query matches nothing.
+func matchWords[T any](items []T, query string, text func(T) string) []T {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return nil
+	}
+	var matches []T
+	for _, item := range items {
+		t := strings.ToLower(text(item))
+		matched := true
+		for _, w := range words {
+			if !strings.Contains(t, w) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, item)
+		}
+	}
+	return matches
+}
+
+// Load reads an image found by SearchRandom.
+func (ls *LocalSearcher) Load(ctx context.Context, rawURL string) ([]byte, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" {
+		return nil, fmt.Errorf("not a local image URL: %q", rawURL)
+	}
+	return ls.dir.Read(filepath.FromSlash(u.Path))
+}
@@ -0,0 +1,116 @@
+package potato
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/imagedir"
+)
+
+// Compile-time check: LocalSearcher must implement Searcher and Loader.
+var (
+	_ Searcher = (*LocalSearcher)(nil)
+	_ Loader   = (*LocalSearcher)(nil)
+)
+
+func TestLocalSearcher(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	for _, name := range []string{"russet.png", "yukon gold.jpg"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir, err := imagedir.New(root)
+	if err != nil {
+		t.Fatalf("imagedir.New() error: %v", err)
+	}
+	ls := NewLocalSearcher(dir)
+
+	for seed := range uint64(4) {
+		result, err := ls.SearchRandom(context.Background(), "potato", rand.New(rand.NewPCG(seed, seed)))
+		if err != nil {
+			t.Fatalf("SearchRandom() error: %v", err)
+		}
+		if result.Source != SourceLocal || result.Title == "" {
+			t.Errorf("SearchRandom() = %+v, want a titled local result", result)
+		}
+		url := result.URL
+		if !strings.HasPrefix(url, "file://") {
+			t.Fatalf("SearchRandom() = %q, want a file:// URL", url)
+		}
+		data, err := ls.Load(context.Background(), url)
+		if err != nil {
+			t.Fatalf("Load(%q) error: %v", url, err)
+		}
+		if !strings.HasSuffix(url, strings.ReplaceAll(string(data), " ", "%20")) {
+			t.Errorf("Load(%q) = %q", url, data)
+		}
+	}
+
+	for _, url := range []string{"https://i.redd.it/potato.jpg", "file:///etc/passwd"} {
+		if _, err := ls.Load(context.Background(), url); err == nil {
+			t.Errorf("Load(%q) should fail", url)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ls.SearchRandom(ctx, "potato", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("SearchRandom() with canceled context error = %v", err)
+	}
+}
+
+func TestLocalSearcher_Query(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	for _, name := range []string{"weird_potato-1.png", "Weird-Potato-2.png", "ugly potato.png", "russet.png"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir, err := imagedir.New(root)
+	if err != nil {
+		t.Fatalf("imagedir.New() error: %v", err)
+	}
+	ls := NewLocalSearcher(dir)
+
+	tests := []struct {
+		query     string
+		want      []string
+		wantQuery string
+	}{
+		{"weird potato", []string{"weird_potato-1.png", "Weird-Potato-2.png"}, "weird potato"},
+		{"UGLY", []string{"ugly potato.png"}, "UGLY"},
+		{"potato fail", []string{"weird_potato-1.png", "Weird-Potato-2.png", "ugly potato.png", "russet.png"}, ""},
+		{"", []string{"weird_potato-1.png", "Weird-Potato-2.png", "ugly potato.png", "russet.png"}, ""},
+	}
+	for _, tt := range tests {
+		seen := map[string]bool{}
+		for seed := range uint64(40) {
+			result, err := ls.SearchRandom(context.Background(), tt.query, rand.New(rand.NewPCG(seed, seed)))
+			if err != nil {
+				t.Fatalf("SearchRandom(%q) error: %v", tt.query, err)
+			}
+			if result.Query != tt.wantQuery {
+				t.Errorf("SearchRandom(%q).Query = %q, want %q", tt.query, result.Query, tt.wantQuery)
+			}
+			seen[result.Title] = true
+		}
+		if len(seen) != len(tt.want) {
+			t.Errorf("SearchRandom(%q) picked %v, want exactly %v", tt.query, seen, tt.want)
+		}
+		for _, name := range tt.want {
+			if !seen[name] {
+				t.Errorf("SearchRandom(%q) never picked %s", tt.query, name)
+			}
+		}
+	}
+}
@@ -4,128 +4,396 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
-var subreddits = []string{"potato", "PotatoesAreFunny", "potatoes"}
+// DefaultSubreddits are the subreddits potatoes are drawn from unless
+// configured otherwise.
+var DefaultSubreddits = []Subreddit{
+	{Name: "potato", Weight: 1},
+	{Name: "PotatoesAreFunny", Weight: 1},
+	{Name: "potatoes", Weight: 1},
+}
 
 // RedditClient fetches potato images from Reddit's public JSON API.
 // It requires no API key — only a descriptive User-Agent header.
+//
+// Listings are cached per subreddit, sort and search; see candidates for how
+// the cache is refreshed and store for how it is kept in bounds.
 type RedditClient struct {
 	httpClient *http.Client
-	subreddits []string
+	subreddits []Subreddit
+	// sort, timeWindow and limit select the listing of a subreddit; zero
+	// values mean DefaultSort, Reddit's default window and DefaultLimit.
+	sort       string
+	timeWindow string
+	limit      int
+	// noFallback makes searches fail instead of returning fallback images.
+	noFallback bool
+	// ttl is how long a listing is served before it is revalidated. Zero
+	// means DefaultListingTTL.
+	ttl time.Duration
+
+	mu       sync.Mutex
+	listings map[string]*listing
+	// lastUsed is when each cached listing was last stored or served.
+	lastUsed map[string]time.Time
+	// blockedUntil is when Reddit's rate limit allows the next request.
+	blockedUntil time.Time
+	refresh      singleflight.Group
+}
+
+// Option configures a RedditClient.
+type Option func(*RedditClient)
+
+// WithListingTTL sets how long a subreddit listing is cached before it is
+// revalidated with Reddit.
+func WithListingTTL(ttl time.Duration) Option {
+	return func(rc *RedditClient) {
+		rc.ttl = ttl
+	}
+}
+
+// WithListing sets the subreddits, sort, time window and limit the client
+// draws potatoes from. Zero fields of o keep their defaults. The options are
+// not validated; see ListingOptions.Validate.
+func WithListing(o ListingOptions) Option {
+	return func(rc *RedditClient) {
+		if len(o.Subreddits) > 0 {
+			rc.subreddits = o.Subreddits
+		}
+		if o.Sort != "" {
+			rc.sort = o.Sort
+		}
+		if o.Time != "" {
+			rc.timeWindow = o.Time
+		}
+		if o.Limit != 0 {
+			rc.limit = o.Limit
+		}
+	}
+}
+
+// WithFallback sets whether searches that Reddit can't serve return an
+// embedded fallback image, which is the default, or fail. An Aggregator
+// needs the failures to tell that Reddit is down.
+func WithFallback(enabled bool) Option {
+	return func(rc *RedditClient) {
+		rc.noFallback = !enabled
+	}
 }
 
 // NewRedditClient returns a RedditClient that uses the provided HTTP client
 // for all outbound requests.
-func NewRedditClient(httpClient *http.Client) *RedditClient {
-	return &RedditClient{
+func NewRedditClient(httpClient *http.Client, opts ...Option) *RedditClient {
+	rc := &RedditClient{
 		httpClient: httpClient,
-		subreddits: subreddits,
+		subreddits: DefaultSubreddits,
 	}
+	for _, opt := range opts {
+		opt(rc)
+	}
+	return rc
 }
 
 // redditListing models only the fields we need from Reddit's listing endpoint.
 type redditListing struct {
 	Data struct {
 		Children []struct {
-			Data struct {
-				URL      string `json:"url"`
-				PostHint string `json:"post_hint"`
-				IsVideo  bool   `json:"is_video"`
-				Over18   bool   `json:"over_18"`
-			} `json:"data"`
+			Data redditPost `json:"data"`
 		} `json:"children"`
 	} `json:"data"`
 }
 
-// SearchRandom returns the URL of a random potato image sourced from Reddit.
-// The query parameter is accepted for interface compatibility but ignored —
-// images come from potato-specific subreddits.
+// redditPost is one post of a listing.
+type redditPost struct {
+	URL       string `json:"url"`
+	PostHint  string `json:"post_hint"`
+	IsVideo   bool   `json:"is_video"`
+	Over18    bool   `json:"over_18"`
+	Subreddit string `json:"subreddit"`
+	Permalink string `json:"permalink"`
+	Author    string `json:"author"`
+	Title     string `json:"title"`
+	Preview   struct {
+		Images []struct {
+			Source struct {
+				URL    string `json:"url"`
+				Width  int    `json:"width"`
+				Height int    `json:"height"`
+			} `json:"source"`
+		} `json:"images"`
+	} `json:"preview"`
+	IsGallery     bool                   `json:"is_gallery"`
+	MediaMetadata map[string]redditMedia `json:"media_metadata"`
+	GalleryData   struct {
+		Items []struct {
+			MediaID string `json:"media_id"`
+		} `json:"items"`
+	} `json:"gallery_data"`
+}
+
+// redditMedia is one image of a gallery post, keyed by its media ID in
+// media_metadata.
+type redditMedia struct {
+	Status string `json:"status"`
+	// Kind is "Image" or "AnimatedImage".
+	Kind   string `json:"e"`
+	Source struct {
+		URL    string `json:"u"`
+		GIF    string `json:"gif"`
+		Width  int    `json:"x"`
+		Height int    `json:"y"`
+	} `json:"s"`
+}
+
+// result describes the post's image.
+func (p redditPost) result() Result {
+	r := Result{
+		URL:       p.URL,
+		Source:    SourceReddit,
+		Subreddit: p.Subreddit,
+		Author:    p.Author,
+		Title:     p.Title,
+	}
+	if p.Permalink != "" {
+		r.Permalink = "https://www.reddit.com" + p.Permalink
+	}
+	if len(p.Preview.Images) > 0 {
+		r.Width = p.Preview.Images[0].Source.Width
+		r.Height = p.Preview.Images[0].Source.Height
+	}
+	return r
+}
+
+// images returns the images of the post: every image of a gallery, or the
+// image the post links to, or else Reddit's preview of an image post. Links
+// to single imgur images are rewritten to the image; imgur albums are left
+// for followImgur. NSFW posts and videos have no images.
+func (p redditPost) images() []Result {
+	if p.IsVideo || p.Over18 || strings.Contains(p.PostHint, "video") {
+		return nil
+	}
+	if p.IsGallery {
+		return p.galleryImages()
+	}
+
+	r := p.result()
+	switch u, ok := imgurImage(p.URL); {
+	case ok:
+		r.URL = u
+	case isImageURL(p.URL), isImgurAlbum(p.URL):
+	case p.PostHint == "image" && p.previewURL() != "":
+		r.URL = p.previewURL()
+	default:
+		return nil
+	}
+	return []Result{r}
+}
+
+// previewURL returns the full-size preview Reddit generated for the post, or
+// "" if there is none.
+func (p redditPost) previewURL() string {
+	if len(p.Preview.Images) == 0 {
+		return ""
+	}
+	// Reddit HTML-escapes the URLs it generates, so their query strings are
+	// full of &amp;.
+	return html.UnescapeString(p.Preview.Images[0].Source.URL)
+}
+
+// galleryImages returns the images of a gallery post in gallery order,
+// skipping media that failed processing or isn't an image.
+func (p redditPost) galleryImages() []Result {
+	var images []Result
+	for _, item := range p.GalleryData.Items {
+		media, ok := p.MediaMetadata[item.MediaID]
+		if !ok || media.Status != "valid" {
+			continue
+		}
+		u := media.Source.URL
+		switch media.Kind {
+		case "Image":
+		case "AnimatedImage":
+			u = media.Source.GIF
+		default:
+			continue
+		}
+		if u == "" {
+			continue
+		}
+		r := p.result()
+		r.URL = html.UnescapeString(u) // escaped like previewURL
+		r.Width, r.Height = media.Source.Width, media.Source.Height
+		images = append(images, r)
+	}
+	return images
+}
+
+// SearchRandom returns a random potato image sourced from Reddit. A
+// non-empty query is searched for within a potato-specific subreddit; when
+// the search finds no images, or no query is given, the subreddit's listing
+// is used. The subreddit and post are chosen using rng; a nil rng uses a
+// randomly seeded source.
 //
-// On any failure other than context cancellation, a random URL from the
-// hardcoded fallback list is returned instead.
-func (rc *RedditClient) SearchRandom(ctx context.Context, _ string) (string, error) {
+// Listings are served from the cache, and from a stale cache when Reddit is
+// failing or rate limiting us. Only when nothing is cached, on any failure
+// other than context cancellation, a random embedded fallback image is
+// returned instead, unless disabled with WithFallback; see Fallback.
+func (rc *RedditClient) SearchRandom(ctx context.Context, query string, rng *rand.Rand) (Result, error) {
+	return rc.SearchListing(ctx, query, ListingOptions{}, rng)
+}
+
+// SearchListing is SearchRandom drawing from the listings selected by o
+// instead of the configured ones. Zero fields of o keep the configured
+// values.
+func (rc *RedditClient) SearchListing(ctx context.Context, query string, o ListingOptions, rng *rand.Rand) (Result, error) {
 	if ctx.Err() != nil {
-		return "", ctx.Err()
+		return Result{}, ctx.Err()
+	}
+
+	if rng == nil {
+		rng = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
 	}
 
-	url, err := rc.fetchFromReddit(ctx)
+	result, err := rc.fetchFromReddit(ctx, strings.TrimSpace(query), rc.listingOptions(o), rng)
 	if err != nil {
 		// Context cancellation/expiry: propagate, don't fall back.
 		if ctx.Err() != nil {
-			return "", ctx.Err()
+			return Result{}, ctx.Err()
+		}
+		if rc.noFallback {
+			return Result{}, err
 		}
-		return pickFallback(), nil
+		return pickFallback(rng), nil
 	}
 
-	return url, nil
+	return result, nil
+}
+
+// listingOptions returns o completed with the client's configuration and the
+// defaults.
+func (rc *RedditClient) listingOptions(o ListingOptions) ListingOptions {
+	o = o.merge(ListingOptions{
+		Subreddits: rc.subreddits,
+		Sort:       rc.sort,
+		Time:       rc.timeWindow,
+		Limit:      rc.limit,
+	})
+	return o.merge(ListingOptions{Subreddits: DefaultSubreddits, Sort: DefaultSort, Limit: DefaultLimit})
 }
 
-// fetchFromReddit picks a random subreddit, fetches its hot posts, filters
-// for qualifying image posts, and returns a random image URL.
-func (rc *RedditClient) fetchFromReddit(ctx context.Context) (string, error) {
-	sub := rc.subreddits[rand.IntN(len(rc.subreddits))]
-	endpoint := fmt.Sprintf("https://www.reddit.com/r/%s/hot.json?limit=50", sub)
+// fetchFromReddit picks a random subreddit and returns a random qualifying
+// image from the posts matching query, or from its listing.
+func (rc *RedditClient) fetchFromReddit(ctx context.Context, query string, o ListingOptions, rng *rand.Rand) (Result, error) {
+	sub := pickSubreddit(o.Subreddits, rng)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
-	if err != nil {
-		return "", fmt.Errorf("creating reddit request: %w", err)
+	if query != "" {
+		candidates, err := rc.candidates(ctx, listingURL(sub, query, o))
+		if err == nil && len(candidates) > 0 {
+			result := candidates[rng.IntN(len(candidates))]
+			result.Query = query
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
 	}
-	req.Header.Set("User-Agent", "potato-nice-thelma/1.0")
 
-	resp, err := rc.httpClient.Do(req)
+	candidates, err := rc.candidates(ctx, listingURL(sub, "", o))
 	if err != nil {
-		return "", fmt.Errorf("executing reddit request: %w", err)
+		return Result{}, err
+	}
+	if len(candidates) == 0 {
+		return Result{}, fmt.Errorf("no qualifying image posts found in r/%s", sub)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("reddit returned status %d", resp.StatusCode)
+	return candidates[rng.IntN(len(candidates))], nil
+}
+
+// listingURL returns the endpoint for the posts of sub matching query, or for
+// its listing sorted by o.Sort if query is empty. Searches have no rising
+// sort and are sorted by relevance unless o asks for top or new posts.
+func listingURL(sub, query string, o ListingOptions) string {
+	params := url.Values{"limit": {strconv.Itoa(o.Limit)}}
+	if o.Sort == "top" && o.Time != "" {
+		params.Set("t", o.Time)
+	}
+	if query == "" {
+		return fmt.Sprintf("https://www.reddit.com/r/%s/%s.json?%s", sub, o.Sort, params.Encode())
 	}
 
-	var listing redditListing
-	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
-		return "", fmt.Errorf("decoding reddit response: %w", err)
+	params.Set("q", query)
+	params.Set("restrict_sr", "1")
+	if o.Sort == "top" || o.Sort == "new" {
+		params.Set("sort", o.Sort)
 	}
+	return fmt.Sprintf("https://www.reddit.com/r/%s/search.json?%s", sub, params.Encode())
+}
 
-	var candidates []string
-	for _, child := range listing.Data.Children {
-		post := child.Data
-		if post.PostHint != "image" {
-			continue
-		}
-		if post.IsVideo {
-			continue
-		}
-		if post.Over18 {
-			continue
+// fetchListing requests the listing at endpoint, revalidating prev if it is
+// non-nil. It returns the response for the caller to close.
+func (rc *RedditClient) fetchListing(ctx context.Context, endpoint string, prev *listing) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating reddit request: %w", err)
+	}
+	req.Header.Set("User-Agent", "potato-nice-thelma/1.0")
+	if prev != nil {
+		if prev.etag != "" {
+			req.Header.Set("If-None-Match", prev.etag)
 		}
-		if !isImageURL(post.URL) {
-			continue
+		if prev.lastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.lastModified)
 		}
-		candidates = append(candidates, post.URL)
 	}
 
-	if len(candidates) == 0 {
-		return "", fmt.Errorf("no qualifying image posts found in r/%s", sub)
+	resp, err := rc.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing reddit request: %w", err)
+	}
+	return resp, nil
+}
+
+// filterListing decodes a listing and returns the images of its posts.
+func filterListing(resp *http.Response) ([]Result, error) {
+	var listing redditListing
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, fmt.Errorf("decoding reddit response: %w", err)
 	}
 
-	return candidates[rand.IntN(len(candidates))], nil
+	var candidates []Result
+	for _, child := range listing.Data.Children {
+		candidates = append(candidates, child.Data.images()...)
+	}
+	return candidates, nil
 }
 
-// isImageURL reports whether the URL ends with a common image extension.
-func isImageURL(u string) bool {
-	lower := strings.ToLower(u)
-	return strings.HasSuffix(lower, ".jpg") ||
-		strings.HasSuffix(lower, ".jpeg") ||
-		strings.HasSuffix(lower, ".png") ||
-		strings.HasSuffix(lower, ".gif")
+// isImageURL reports whether the URL's path ends with a common image
+// extension.
+func isImageURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return true
+	}
+	return false
 }
 
-// pickFallback returns a random URL from the hardcoded fallback list.
-func pickFallback() string {
-	return fallbackURLs[rand.IntN(len(fallbackURLs))]
+// pickFallback returns a random embedded fallback image.
+func pickFallback(rng *rand.Rand) Result {
+	return fallbackResult(fallbackURLs[rng.IntN(len(fallbackURLs))])
 }
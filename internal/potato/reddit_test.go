@@ -3,14 +3,19 @@ package potato
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"math/rand/v2"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
 // Compile-time check: RedditClient must implement Searcher.
 var _ Searcher = (*RedditClient)(nil)
 
+var _ ListingSearcher = (*RedditClient)(nil)
+
 func TestSearchRandom_FallsBackOnRedditFailure(t *testing.T) {
 	// Server that always returns 500.
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -20,18 +25,32 @@ func TestSearchRandom_FallsBackOnRedditFailure(t *testing.T) {
 
 	rc := &RedditClient{
 		httpClient: srv.Client(),
-		subreddits: []string{"potato"},
+		subreddits: []Subreddit{{Name: "potato", Weight: 1}},
 	}
 	// Override the subreddit fetch to hit our test server by using a transport
 	// that redirects all requests to the test server.
 	rc.httpClient.Transport = &rewriteTransport{base: srv.URL}
 
-	url, err := rc.SearchRandom(context.Background(), "potato")
+	result, err := rc.SearchRandom(context.Background(), "potato", nil)
 	if err != nil {
 		t.Fatalf("expected fallback, got error: %v", err)
 	}
-	if url == "" {
-		t.Fatal("expected non-empty fallback URL")
+	if result.URL == "" || result.Source != SourceFallback {
+		t.Fatalf("expected a fallback result, got %+v", result)
+	}
+}
+
+func TestSearchRandom_WithoutFallback(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	rc := NewRedditClient(&http.Client{Transport: &rewriteTransport{base: srv.URL}}, WithFallback(false))
+	if result, err := rc.SearchRandom(context.Background(), "", nil); err == nil {
+		t.Fatalf("SearchRandom() = %+v, want an error", result)
 	}
 }
 
@@ -40,7 +59,7 @@ func TestSearchRandom_PropagatesContextCancellation(t *testing.T) {
 	cancel() // cancel immediately
 
 	rc := NewRedditClient(&http.Client{})
-	_, err := rc.SearchRandom(ctx, "potato")
+	_, err := rc.SearchRandom(ctx, "potato", nil)
 	if err == nil {
 		t.Fatal("expected context error, got nil")
 	}
@@ -51,38 +70,15 @@ func TestSearchRandom_PropagatesContextCancellation(t *testing.T) {
 
 func TestSearchRandom_FiltersCorrectly(t *testing.T) {
 	listing := redditListing{}
-	listing.Data.Children = []struct {
-		Data struct {
-			URL      string `json:"url"`
-			PostHint string `json:"post_hint"`
-			IsVideo  bool   `json:"is_video"`
-			Over18   bool   `json:"over_18"`
-		} `json:"data"`
-	}{
-		{Data: struct {
-			URL      string `json:"url"`
-			PostHint string `json:"post_hint"`
-			IsVideo  bool   `json:"is_video"`
-			Over18   bool   `json:"over_18"`
-		}{URL: "https://i.redd.it/good.jpg", PostHint: "image", IsVideo: false, Over18: false}},
-		{Data: struct {
-			URL      string `json:"url"`
-			PostHint string `json:"post_hint"`
-			IsVideo  bool   `json:"is_video"`
-			Over18   bool   `json:"over_18"`
-		}{URL: "https://i.redd.it/nsfw.jpg", PostHint: "image", IsVideo: false, Over18: true}},
-		{Data: struct {
-			URL      string `json:"url"`
-			PostHint string `json:"post_hint"`
-			IsVideo  bool   `json:"is_video"`
-			Over18   bool   `json:"over_18"`
-		}{URL: "https://v.redd.it/video.mp4", PostHint: "hosted:video", IsVideo: true, Over18: false}},
-		{Data: struct {
-			URL      string `json:"url"`
-			PostHint string `json:"post_hint"`
-			IsVideo  bool   `json:"is_video"`
-			Over18   bool   `json:"over_18"`
-		}{URL: "https://reddit.com/gallery/abc", PostHint: "image", IsVideo: false, Over18: false}},
+	for _, post := range []redditPost{
+		{URL: "https://i.redd.it/good.jpg", PostHint: "image", IsVideo: false, Over18: false},
+		{URL: "https://i.redd.it/nsfw.jpg", PostHint: "image", IsVideo: false, Over18: true},
+		{URL: "https://v.redd.it/video.mp4", PostHint: "hosted:video", IsVideo: true, Over18: false},
+		{URL: "https://reddit.com/gallery/abc", PostHint: "image", IsVideo: false, Over18: false},
+	} {
+		listing.Data.Children = append(listing.Data.Children, struct {
+			Data redditPost `json:"data"`
+		}{post})
 	}
 
 	body, _ := json.Marshal(listing)
@@ -99,15 +95,266 @@ func TestSearchRandom_FiltersCorrectly(t *testing.T) {
 
 	rc := &RedditClient{
 		httpClient: &http.Client{Transport: &rewriteTransport{base: srv.URL}},
-		subreddits: []string{"potato"},
+		subreddits: []Subreddit{{Name: "potato", Weight: 1}},
+	}
+
+	result, err := rc.SearchRandom(context.Background(), "potato", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.URL != "https://i.redd.it/good.jpg" {
+		t.Fatalf("expected 'https://i.redd.it/good.jpg', got %q", result.URL)
+	}
+}
+
+func TestSearchRandom_Attribution(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"children":[{"data":{
+			"url": "https://i.redd.it/spud.png",
+			"post_hint": "image",
+			"subreddit": "potato",
+			"permalink": "/r/potato/comments/abc123/behold_my_spud/",
+			"author": "spudlover",
+			"title": "Behold, my spud",
+			"preview": {"images": [{"source": {"url": "https://preview.redd.it/spud.png", "width": 1024, "height": 768}}]}
+		}}]}}`))
+	}))
+	defer srv.Close()
+
+	rc := &RedditClient{
+		httpClient: &http.Client{Transport: &rewriteTransport{base: srv.URL}},
+		subreddits: []Subreddit{{Name: "potato", Weight: 1}},
 	}
 
-	url, err := rc.SearchRandom(context.Background(), "potato")
+	got, err := rc.SearchRandom(context.Background(), "", nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if url != "https://i.redd.it/good.jpg" {
-		t.Fatalf("expected 'https://i.redd.it/good.jpg', got %q", url)
+	want := Result{
+		URL:       "https://i.redd.it/spud.png",
+		Source:    SourceReddit,
+		Subreddit: "potato",
+		Permalink: "https://www.reddit.com/r/potato/comments/abc123/behold_my_spud/",
+		Author:    "spudlover",
+		Title:     "Behold, my spud",
+		Width:     1024,
+		Height:    768,
+	}
+	if got != want {
+		t.Errorf("SearchRandom() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSearchRandom_Query(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/r/potato/hot.json":
+			w.Write([]byte(`{"data":{"children":[{"data":{"url":"https://i.redd.it/hot.jpg","post_hint":"image"}}]}}`))
+		case "/r/potato/search.json":
+			if r.URL.Query().Get("restrict_sr") != "1" {
+				t.Errorf("search not restricted to the subreddit: %s", r.URL.RawQuery)
+			}
+			if r.URL.Query().Get("q") == "weird potato" {
+				w.Write([]byte(`{"data":{"children":[{"data":{"url":"https://i.redd.it/weird.jpg","post_hint":"image"}}]}}`))
+				return
+			}
+			w.Write([]byte(`{"data":{"children":[]}}`))
+		default:
+			t.Errorf("unexpected request for %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	rc := &RedditClient{
+		httpClient: &http.Client{Transport: &rewriteTransport{base: srv.URL}},
+		subreddits: []Subreddit{{Name: "potato", Weight: 1}},
+	}
+
+	tests := []struct {
+		query     string
+		wantURL   string
+		wantQuery string
+	}{
+		{"weird potato", "https://i.redd.it/weird.jpg", "weird potato"},
+		{"  weird potato ", "https://i.redd.it/weird.jpg", "weird potato"},
+		{"", "https://i.redd.it/hot.jpg", ""},
+		{"no such potato", "https://i.redd.it/hot.jpg", ""},
+	}
+	for _, tt := range tests {
+		got, err := rc.SearchRandom(context.Background(), tt.query, nil)
+		if err != nil {
+			t.Fatalf("SearchRandom(%q) error: %v", tt.query, err)
+		}
+		if got.URL != tt.wantURL || got.Query != tt.wantQuery {
+			t.Errorf("SearchRandom(%q) = %s (query %q), want %s (query %q)", tt.query, got.URL, got.Query, tt.wantURL, tt.wantQuery)
+		}
+	}
+}
+
+func TestSearchListing_Endpoints(t *testing.T) {
+	t.Parallel()
+
+	requests := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.RequestURI()
+		w.Write([]byte(`{"data":{"children":[{"data":{"url":"https://i.redd.it/a.jpg","post_hint":"image"}}]}}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		client  []Option
+		listing ListingOptions
+		query   string
+		want    string
+	}{
+		{"defaults", nil, ListingOptions{}, "", "/r/potato/hot.json?limit=50"},
+		{"configured", []Option{WithListing(ListingOptions{Sort: "top", Time: "week", Limit: 10})}, ListingOptions{}, "", "/r/potato/top.json?limit=10&t=week"},
+		{"overridden", []Option{WithListing(ListingOptions{Sort: "top", Time: "week"})}, ListingOptions{Subreddits: []Subreddit{{Name: "cats", Weight: 1}}, Sort: "new"}, "", "/r/cats/new.json?limit=50"},
+		{"time only applies to top", nil, ListingOptions{Sort: "rising", Time: "all"}, "", "/r/potato/rising.json?limit=50"},
+		{"search", nil, ListingOptions{Sort: "top", Time: "year"}, "spud", "/r/potato/search.json?limit=50&q=spud&restrict_sr=1&sort=top&t=year"},
+		{"search by relevance", nil, ListingOptions{Sort: "rising"}, "spud", "/r/potato/search.json?limit=50&q=spud&restrict_sr=1"},
+	}
+	for _, tt := range tests {
+		opts := append([]Option{WithListing(ListingOptions{Subreddits: []Subreddit{{Name: "potato", Weight: 1}}})}, tt.client...)
+		rc := NewRedditClient(&http.Client{Transport: &rewriteTransport{base: srv.URL}}, opts...)
+		if _, err := rc.SearchListing(context.Background(), tt.query, tt.listing, nil); err != nil {
+			t.Fatalf("%s: SearchListing() error: %v", tt.name, err)
+		}
+		if got := <-requests; got != tt.want {
+			t.Errorf("%s: requested %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResult_Attribution(t *testing.T) {
+	r := Result{
+		URL:       "https://i.redd.it/spud.png",
+		Source:    SourceReddit,
+		Subreddit: "potato",
+		Author:    "spudlover",
+		Title:     "Behold, my spud",
+		Width:     1024,
+		Height:    768,
+	}
+	want := "source: reddit\nsubreddit: r/potato\nauthor: u/spudlover\ntitle: Behold, my spud\nurl: https://i.redd.it/spud.png\nsize: 1024x768\n"
+	if got := r.Attribution(); got != want {
+		t.Errorf("Attribution() = %q, want %q", got, want)
+	}
+	if got := (Result{}).Attribution(); got != "" {
+		t.Errorf("empty Attribution() = %q, want empty", got)
+	}
+
+	// Local images don't reveal where the server keeps them.
+	local := Result{URL: "file:///srv/potatoes/russet.png", Source: SourceLocal}
+	if got, want := local.Attribution(), "source: local\nurl: local:russet.png\n"; got != want {
+		t.Errorf("local Attribution() = %q, want %q", got, want)
+	}
+	for raw, want := range map[string]string{
+		"file:///srv/potatoes/sub/russet.png": "local:russet.png",
+		"https://i.redd.it/spud.png":          "https://i.redd.it/spud.png",
+		"fallback:russet.png":                 "fallback:russet.png",
+	} {
+		if got := (Result{URL: raw}).PublicURL(); got != want {
+			t.Errorf("PublicURL() of %q = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestSearchRandom_SameSeedSamePick(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString(`{"data":{"children":[`)
+	for i := range 20 {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"data":{"url":"https://i.redd.it/%d.jpg","post_hint":"image"}}`, i)
+	}
+	sb.WriteString(`]}}`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(sb.String()))
+	}))
+	defer srv.Close()
+
+	rc := &RedditClient{
+		httpClient: &http.Client{Transport: &rewriteTransport{base: srv.URL}},
+		subreddits: []Subreddit{{Name: "potato", Weight: 1}, {Name: "potatoes", Weight: 1}, {Name: "PotatoesAreFunny", Weight: 1}},
+	}
+
+	for seed := range uint64(5) {
+		first, err := rc.SearchRandom(context.Background(), "potato", rand.New(rand.NewPCG(seed, seed)))
+		if err != nil {
+			t.Fatalf("seed %d: unexpected error: %v", seed, err)
+		}
+		second, err := rc.SearchRandom(context.Background(), "potato", rand.New(rand.NewPCG(seed, seed)))
+		if err != nil {
+			t.Fatalf("seed %d: unexpected error: %v", seed, err)
+		}
+		if first != second {
+			t.Errorf("seed %d: got %+v then %+v, want identical picks", seed, first, second)
+		}
+	}
+}
+
+func TestPickFallback_SameSeedSamePick(t *testing.T) {
+	for seed := range uint64(5) {
+		a := pickFallback(rand.New(rand.NewPCG(seed, seed)))
+		b := pickFallback(rand.New(rand.NewPCG(seed, seed)))
+		if a != b {
+			t.Errorf("seed %d: got %+v then %+v, want identical picks", seed, a, b)
+		}
+	}
+}
+
+func TestRedditPost_Images(t *testing.T) {
+	t.Parallel()
+
+	// A gallery of a still, a failed upload, an animation and an item
+	// missing from media_metadata.
+	const gallery = `{
+		"is_gallery": true, "url": "https://www.reddit.com/gallery/abc",
+		"gallery_data": {"items": [{"media_id": "one"}, {"media_id": "failed"}, {"media_id": "anim"}, {"media_id": "gone"}]},
+		"media_metadata": {
+			"one": {"status": "valid", "e": "Image", "s": {"u": "https://preview.redd.it/one.jpg?width=800&amp;s=x", "x": 800, "y": 600}},
+			"failed": {"status": "failed"},
+			"anim": {"status": "valid", "e": "AnimatedImage", "s": {"gif": "https://i.redd.it/anim.gif", "mp4": "https://i.redd.it/anim.mp4", "x": 320, "y": 240}}
+		}
+	}`
+	const preview = `"preview": {"images": [{"source": {"url": "https://preview.redd.it/p.jpg?auto=webp&amp;s=abc", "width": 1024, "height": 768}}]}`
+
+	tests := []struct {
+		name string
+		post string
+		want []string
+	}{
+		{"gallery", gallery, []string{"https://preview.redd.it/one.jpg?width=800&s=x", "https://i.redd.it/anim.gif"}},
+		{"nsfw gallery", strings.Replace(gallery, `"is_gallery": true`, `"is_gallery": true, "over_18": true`, 1), nil},
+		{"direct image", `{"url": "https://i.redd.it/a.png", "post_hint": "image"}`, []string{"https://i.redd.it/a.png"}},
+		{"image without extension", `{"url": "https://i.redd.it/a", "post_hint": "image", ` + preview + `}`, []string{"https://preview.redd.it/p.jpg?auto=webp&s=abc"}},
+		{"link preview", `{"url": "https://example.com/potato-news", "post_hint": "link", ` + preview + `}`, nil},
+		{"imgur image", `{"url": "https://imgur.com/AbCdE12", "post_hint": "link"}`, []string{"https://i.imgur.com/AbCdE12.jpg"}},
+		{"imgur gifv", `{"url": "https://i.imgur.com/AbCdE12.gifv", "post_hint": "link"}`, []string{"https://i.imgur.com/AbCdE12.gif"}},
+		{"imgur album", `{"url": "https://imgur.com/a/XyZ98", "post_hint": "link"}`, []string{"https://imgur.com/a/XyZ98"}},
+		{"video", `{"url": "https://v.redd.it/v", "post_hint": "hosted:video", "is_video": true, ` + preview + `}`, nil},
+		{"text post", `{"url": "https://www.reddit.com/r/potato/comments/x/spud/", "post_hint": "self"}`, nil},
+	}
+	for _, tt := range tests {
+		var post redditPost
+		if err := json.Unmarshal([]byte(tt.post), &post); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, r := range post.images() {
+			got = append(got, r.URL)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: images() = %q, want %q", tt.name, got, tt.want)
+		}
 	}
 }
 
@@ -123,6 +370,9 @@ func TestIsImageURL(t *testing.T) {
 		{"https://i.redd.it/abc.mp4", false},
 		{"https://reddit.com/gallery/abc", false},
 		{"https://i.redd.it/abc.JPG", true},
+		{"https://i.redd.it/abc.webp", true},
+		{"https://preview.redd.it/abc.jpg?width=640&auto=webp", true},
+		{"https://preview.redd.it/abc?format=png", false},
 	}
 	for _, tt := range tests {
 		if got := isImageURL(tt.url); got != tt.want {
@@ -1,8 +1,104 @@
 package potato
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"path"
+	"strings"
+)
 
-// Searcher finds potato images on the internet.
+// Searcher finds potato images on the internet. A non-empty query narrows the
+// search; a Searcher that finds nothing for it picks from everything it has
+// instead, and leaves Result.Query empty. Every random choice a Searcher
+// makes is drawn from rng so that callers can reproduce a search.
 type Searcher interface {
-	SearchRandom(ctx context.Context, query string) (string, error)
+	SearchRandom(ctx context.Context, query string, rng *rand.Rand) (Result, error)
+}
+
+// Loader is implemented by Searchers whose results are not plain web URLs.
+// Callers load such results through the Searcher instead of fetching them.
+type Loader interface {
+	Load(ctx context.Context, url string) ([]byte, error)
+}
+
+// ListingSearcher is implemented by Searchers that can draw from Reddit
+// listings other than their configured ones, such as RedditClient.
+type ListingSearcher interface {
+	SearchListing(ctx context.Context, query string, o ListingOptions, rng *rand.Rand) (Result, error)
+}
+
+// Sources of a Result.
+const (
+	SourceReddit   = "reddit"
+	SourceLocal    = "local"
+	SourceFallback = "fallback"
+	SourceFeed     = "feed"
+)
+
+// Result describes a potato image found by a Searcher, with what is known
+// about where it came from. Only URL and Source are always set.
+type Result struct {
+	// URL locates the image. Besides web URLs it may be a fallback URL, see
+	// Fallback, or a URL only the Searcher can load, see Loader.
+	URL string
+	// Source names where the image was found, such as SourceReddit.
+	Source string
+	// Subreddit is the subreddit of the post, without the "r/" prefix.
+	Subreddit string
+	// Permalink is the URL of the post the image was found in.
+	Permalink string
+	// Author is the username of whoever posted the image.
+	Author string
+	// Title is the title of the post, or a name for the image.
+	Title string
+	// Query is the search query the image matched, or empty if the image was
+	// picked without one.
+	Query string
+	// Width and Height are the image dimensions reported by the source, or
+	// zero if unknown.
+	Width, Height int
+
+	// aggregated is one more than the index of the Aggregator source that
+	// found the image, or zero if no Aggregator was involved; see
+	// Aggregator.Report.
+	aggregated int
+}
+
+// PublicURL returns r.URL as it may be shown to clients. A local image is
+// named by its file name alone, as in "local:russet.png", so that the
+// server's directory layout isn't revealed.
+func (r Result) PublicURL() string {
+	u, err := url.Parse(r.URL)
+	if err != nil || u.Scheme != "file" {
+		return r.URL
+	}
+	return "local:" + path.Base(u.Path)
+}
+
+// Attribution returns the known fields of r as "key: value" lines, suitable
+// for crediting a repost. The URL is r.PublicURL.
+func (r Result) Attribution() string {
+	var b strings.Builder
+	line := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", key, value)
+		}
+	}
+	line("source", r.Source)
+	if r.Subreddit != "" {
+		line("subreddit", "r/"+r.Subreddit)
+	}
+	if r.Author != "" {
+		line("author", "u/"+r.Author)
+	}
+	line("title", r.Title)
+	line("query", r.Query)
+	line("permalink", r.Permalink)
+	line("url", r.PublicURL())
+	if r.Width > 0 && r.Height > 0 {
+		line("size", fmt.Sprintf("%dx%d", r.Width, r.Height))
+	}
+	return b.String()
 }
@@ -0,0 +1,245 @@
+// Package resilient wraps upstream HTTP calls with retries and per-host
+// circuit breakers.
+package resilient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, wrapped with the host, for requests to a host
+// whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+const (
+	// DefaultMaxRetries is how many times a request is retried by default.
+	DefaultMaxRetries = 2
+	// DefaultBaseDelay is the backoff before the first retry.
+	DefaultBaseDelay = 100 * time.Millisecond
+	// DefaultMaxDelay caps the backoff, and the Retry-After we wait for.
+	DefaultMaxDelay = 2 * time.Second
+	// DefaultFailureThreshold is how many consecutive failures open a
+	// host's circuit.
+	DefaultFailureThreshold = 5
+	// DefaultCooldown is how long an open circuit fails fast.
+	DefaultCooldown = 30 * time.Second
+	// maxHosts bounds how many failing hosts are tracked; URLs can come from
+	// clients, so the set of hosts is not ours to choose.
+	maxHosts = 1024
+	// maxDrain is how much of a discarded response body is read so that its
+	// connection can be reused.
+	maxDrain = 64 << 10
+)
+
+// Config controls a Transport. Zero fields take their defaults; a negative
+// MaxRetries disables retries.
+type Config struct {
+	// MaxRetries is how many times a failed GET or HEAD is retried.
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry. It doubles for each
+	// further retry, with jitter.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff. A Retry-After longer than MaxDelay is not
+	// waited for; the response is returned instead.
+	MaxDelay time.Duration
+	// FailureThreshold is how many consecutive failures open a host's
+	// circuit. Failures are 5xx responses and transport errors.
+	FailureThreshold int
+	// Cooldown is how long an open circuit fails fast before one request is
+	// let through to probe the host.
+	Cooldown time.Duration
+}
+
+// Transport is an http.RoundTripper that retries idempotent requests
+// answered with 5xx or 429, backing off exponentially with jitter and
+// honoring Retry-After. Each host has a circuit breaker: after
+// Config.FailureThreshold consecutive failures, requests to the host fail
+// with ErrCircuitOpen for Config.Cooldown, after which a single probe decides
+// whether the circuit closes again.
+type Transport struct {
+	base http.RoundTripper
+	cfg  Config
+
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+// breaker is the circuit breaker state of a host with recent failures. Hosts
+// without failures have none.
+type breaker struct {
+	// failures counts consecutive failures.
+	failures  int
+	openUntil time.Time
+	// probing is set while the single request of a half-open circuit is in
+	// flight.
+	probing bool
+}
+
+// New returns a Transport that sends requests through base. A nil base means
+// http.DefaultTransport.
+func New(base http.RoundTripper, cfg Config) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultBaseDelay
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = DefaultMaxDelay
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultFailureThreshold
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = DefaultCooldown
+	}
+	return &Transport{base: base, cfg: cfg, hosts: make(map[string]*breaker)}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host := req.URL.Host
+	idempotent := (req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		(req.Body == nil || req.Body == http.NoBody)
+
+	for attempt := 0; ; attempt++ {
+		if err := t.allow(host); err != nil {
+			return nil, err
+		}
+		resp, err := t.base.RoundTrip(req)
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about the host.
+			t.release(host)
+		} else {
+			t.record(host, err != nil || resp.StatusCode >= 500)
+		}
+
+		if err != nil || !idempotent || attempt >= t.cfg.MaxRetries || !retryable(resp.StatusCode) {
+			return resp, err
+		}
+		delay, ok := t.delay(attempt, resp)
+		if deadline, set := ctx.Deadline(); set && time.Now().Add(delay).After(deadline) {
+			ok = false
+		}
+		if !ok {
+			return resp, nil
+		}
+
+		io.CopyN(io.Discard, resp.Body, maxDrain)
+		resp.Body.Close()
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether a response with status is worth retrying.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// delay returns how long to wait before retrying after resp, the response to
+// attempt number attempt, counted from zero. It reports false if resp asks
+// for a longer wait than MaxDelay.
+func (t *Transport) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if wait, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+		return wait, wait <= t.cfg.MaxDelay
+	}
+	backoff := min(t.cfg.BaseDelay<<attempt, t.cfg.MaxDelay)
+	// Equal jitter: at least half the backoff, so retries still back off,
+	// spread over the other half so that clients don't retry in lockstep.
+	half := backoff / 2
+	return half + rand.N(half+1), true
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as an
+// HTTP date. It reports false if the header is missing or invalid.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// allow returns an error if host's circuit is open. When the cooldown of an
+// open circuit has passed, one caller is let through as a probe.
+func (t *Transport) allow(host string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.hosts[host]
+	if b == nil || b.failures < t.cfg.FailureThreshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return fmt.Errorf("%s: %w", host, ErrCircuitOpen)
+	}
+	b.probing = true
+	return nil
+}
+
+// record notes the outcome of a request to host. A success forgets the
+// host's failures, closing its circuit.
+func (t *Transport) record(host string, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !failed {
+		delete(t.hosts, host)
+		return
+	}
+
+	b := t.hosts[host]
+	if b == nil {
+		if len(t.hosts) >= maxHosts {
+			t.evict()
+		}
+		b = &breaker{}
+		t.hosts[host] = b
+	}
+	b.failures++
+	b.probing = false
+	if b.failures >= t.cfg.FailureThreshold {
+		b.openUntil = time.Now().Add(t.cfg.Cooldown)
+	}
+}
+
+// release ends a probe of host without an outcome.
+func (t *Transport) release(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b := t.hosts[host]; b != nil {
+		b.probing = false
+	}
+}
+
+// evict forgets a host whose circuit is closed, or any host if all circuits
+// are open.
+func (t *Transport) evict() {
+	var victim string
+	for host, b := range t.hosts {
+		victim = host
+		if b.failures < t.cfg.FailureThreshold {
+			break
+		}
+	}
+	delete(t.hosts, victim)
+}
@@ -0,0 +1,294 @@
+package resilient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/cataas"
+	"github.com/jefflinse/potato-nice-thelma/internal/potato"
+)
+
+// fastConfig retries quickly so that tests don't wait on backoff.
+var fastConfig = Config{BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, FailureThreshold: 100}
+
+// flakyServer fails its first failures requests with status, then serves
+// body. It counts the requests it received.
+func flakyServer(t *testing.T, failures int64, status int, body []byte) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+// hostRouter sends requests for each host to a test server, keeping the
+// path and query.
+type hostRouter map[string]string
+
+func (h hostRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, ok := h[req.URL.Host]
+	if !ok {
+		return nil, errors.New("no route to " + req.URL.Host)
+	}
+	u, _ := url.Parse(target)
+	req = req.Clone(req.Context())
+	req.URL.Scheme = u.Scheme
+	req.URL.Host = u.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func get(t *testing.T, client *http.Client, target string) (*http.Response, error) {
+	t.Helper()
+	resp, err := client.Get(target)
+	if err == nil {
+		t.Cleanup(func() { resp.Body.Close() })
+	}
+	return resp, err
+}
+
+func TestTransport_RetriesServerErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, status := range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		srv, requests := flakyServer(t, 2, status, []byte("ok"))
+		client := &http.Client{Transport: New(nil, fastConfig)}
+
+		resp, err := get(t, client, srv.URL)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d: got %v, %v; want 200 after retrying", status, resp, err)
+		}
+		if n := requests.Load(); n != 3 {
+			t.Errorf("status %d: server got %d requests, want 3", status, n)
+		}
+	}
+}
+
+func TestTransport_GivesUp(t *testing.T) {
+	t.Parallel()
+
+	srv, requests := flakyServer(t, 100, http.StatusServiceUnavailable, nil)
+	client := &http.Client{Transport: New(nil, fastConfig)}
+
+	resp, err := get(t, client, srv.URL)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, %v; want the last 503", resp, err)
+	}
+	if n := requests.Load(); n != DefaultMaxRetries+1 {
+		t.Errorf("server got %d requests, want %d", n, DefaultMaxRetries+1)
+	}
+}
+
+func TestTransport_OnlyRetriesIdempotentServerErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		method string
+		status int
+	}{
+		{"post", http.MethodPost, http.StatusServiceUnavailable},
+		{"not found", http.MethodGet, http.StatusNotFound},
+		{"bad request", http.MethodGet, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		srv, requests := flakyServer(t, 1, tt.status, nil)
+		client := &http.Client{Transport: New(nil, fastConfig)}
+
+		req, _ := http.NewRequest(tt.method, srv.URL, strings.NewReader("body"))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || requests.Load() != 1 {
+			t.Errorf("%s: got %d after %d requests, want %d after 1", tt.name, resp.StatusCode, requests.Load(), tt.status)
+		}
+	}
+}
+
+func TestTransport_RetryAfter(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: New(nil, Config{MaxDelay: 5 * time.Second})}
+
+	start := time.Now()
+	resp, err := get(t, client, srv.URL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %v, %v; want 200", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want Retry-After honored", elapsed)
+	}
+}
+
+func TestTransport_RetryAfterTooLong(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: New(nil, fastConfig)}
+
+	start := time.Now()
+	resp, err := get(t, client, srv.URL)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got %v, %v; want the 429 for the caller to handle", resp, err)
+	}
+	if n := requests.Load(); n != 1 || time.Since(start) > time.Second {
+		t.Errorf("server got %d requests, want 1 without waiting", n)
+	}
+}
+
+func TestTransport_Delay(t *testing.T) {
+	t.Parallel()
+
+	tr := New(nil, Config{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+	noHeader := &http.Response{Header: http.Header{}}
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for range 20 {
+			got, ok := tr.delay(attempt, noHeader)
+			if !ok || got < want/2 || got > want {
+				t.Fatalf("delay(%d) = %v, %v; want between %v and %v", attempt, got, ok, want/2, want)
+			}
+		}
+	}
+
+	date := time.Now().Add(500 * time.Millisecond).UTC().Format(http.TimeFormat)
+	for header, wantOK := range map[string]bool{"0": true, "1": true, "2": false, date: true} {
+		resp := &http.Response{Header: http.Header{"Retry-After": {header}}}
+		if got, ok := tr.delay(0, resp); ok != wantOK || got > time.Second && ok {
+			t.Errorf("delay with Retry-After %q = %v, %v; want ok=%v", header, got, ok, wantOK)
+		}
+	}
+}
+
+func TestTransport_CircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	var healthy atomic.Bool
+	var requests atomic.Int64
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer down.Close()
+	up, _ := flakyServer(t, 0, 0, []byte("ok"))
+
+	tr := New(nil, Config{MaxRetries: -1, FailureThreshold: 3, Cooldown: 50 * time.Millisecond})
+	client := &http.Client{Transport: tr}
+
+	for range 3 {
+		get(t, client, down.URL)
+	}
+	if _, err := get(t, client, down.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after 3 failures: err = %v, want ErrCircuitOpen", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("open circuit still sent requests: %d, want 3", n)
+	}
+	if resp, err := get(t, client, up.URL); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("other host: got %v, %v; want it unaffected", resp, err)
+	}
+
+	// A failed probe after the cooldown opens the circuit again.
+	time.Sleep(60 * time.Millisecond)
+	get(t, client, down.URL)
+	if _, err := get(t, client, down.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after a failed probe: err = %v, want ErrCircuitOpen", err)
+	}
+
+	// A successful probe closes it.
+	healthy.Store(true)
+	time.Sleep(60 * time.Millisecond)
+	for range 3 {
+		if resp, err := get(t, client, down.URL); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("after recovery: got %v, %v; want 200", resp, err)
+		}
+	}
+}
+
+func TestTransport_CanceledDuringBackoff(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := flakyServer(t, 100, http.StatusServiceUnavailable, nil)
+	client := &http.Client{Transport: New(nil, Config{BaseDelay: time.Hour, MaxDelay: time.Hour})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	if _, err := client.Do(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("backoff ignored cancellation")
+	}
+}
+
+func TestTransport_RedditAndCATAAS(t *testing.T) {
+	t.Parallel()
+
+	listing := []byte(`{"data":{"children":[{"data":{"url":"https://i.redd.it/spud.jpg","post_hint":"image"}}]}}`)
+	reddit, redditRequests := flakyServer(t, 2, http.StatusServiceUnavailable, listing)
+
+	var cat bytes.Buffer
+	png.Encode(&cat, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	catSrv, catRequests := flakyServer(t, 1, http.StatusBadGateway, cat.Bytes())
+
+	client := &http.Client{Transport: New(hostRouter{
+		"www.reddit.com": reddit.URL,
+		"cataas.com":     catSrv.URL,
+	}, fastConfig)}
+
+	// Without retries, Reddit's 503s would have produced a fallback potato.
+	rc := potato.NewRedditClient(client, potato.WithFallback(false))
+	result, err := rc.SearchRandom(context.Background(), "", nil)
+	if err != nil || result.URL != "https://i.redd.it/spud.jpg" {
+		t.Errorf("SearchRandom() = %+v, %v; want the listed potato", result, err)
+	}
+	if n := redditRequests.Load(); n != 3 {
+		t.Errorf("reddit got %d requests, want 3", n)
+	}
+
+	img, err := cataas.NewClient(client).FetchRandomCat(context.Background(), nil)
+	if err != nil || img.Bounds().Dx() != 4 {
+		t.Errorf("FetchRandomCat() = %v, %v; want the cat", img, err)
+	}
+	if n := catRequests.Load(); n != 2 {
+		t.Errorf("cataas got %d requests, want 2", n)
+	}
+}
@@ -1,27 +1,30 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
-	"image/gif"
 	"log/slog"
+	"math"
 	"math/rand/v2"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jefflinse/potato-nice-thelma/internal/cataas"
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+	"github.com/jefflinse/potato-nice-thelma/internal/fetch"
+	"github.com/jefflinse/potato-nice-thelma/internal/format"
 	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+	"github.com/jefflinse/potato-nice-thelma/internal/pool"
 	"github.com/jefflinse/potato-nice-thelma/internal/potato"
 	"golang.org/x/sync/errgroup"
-
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
-
-	_ "golang.org/x/image/webp"
 )
 
 //go:embed index.html
@@ -29,30 +32,109 @@ var indexHTML []byte
 
 // Server is the HTTP server for the potato-cat meme service.
 type Server struct {
-	potato     potato.Searcher
-	cataas     cataas.Fetcher
-	meme       meme.Generator
-	httpClient *http.Client
-	router     *http.ServeMux
+	potato potato.Searcher
+	cataas cataas.Fetcher
+	meme   meme.Generator
+	images *fetch.Fetcher
+	limits decode.Limits
+	router *http.ServeMux
+
+	// poolConfig, when set, enables the potato and cat pools.
+	poolConfig *pool.Config
+	potatoes   *pool.Pool[foundPotato]
+	cats       *pool.Pool[image.Image]
+}
+
+// foundPotato is a potato image with what is known about where it came from.
+type foundPotato struct {
+	img    image.Image
+	result potato.Result
+}
+
+// Sources of potatoes the client supplied, in addition to the potato.Source*
+// values reported by searchers.
+const (
+	sourceUpload = "upload"
+	sourceURL    = "url"
+)
+
+// potatoQueries are the searches a random potato is drawn from.
+var potatoQueries = []string{"weird potato", "funny potato", "potato fail", "potato meme", "ugly potato", "potato face"}
+
+// maxQueryLength is the longest search query accepted in the q parameter.
+const maxQueryLength = 200
+
+// listingsUnsupported rejects sub, sort and t when no potato source takes
+// them.
+const listingsUnsupported = "sub, sort and t are only supported when potatoes come from Reddit"
+
+// catOptionsUnsupported rejects the cat_* options when cats don't come from
+// CATAAS.
+const catOptionsUnsupported = "cat_tag, cat_id, cat_says, cat_filter, cat_tint, cat_width and cat_height are only supported when cats come from CATAAS"
+
+// fetchTimeout bounds the upstream work for one meme, or for one pool refill.
+const fetchTimeout = 15 * time.Second
+
+// Option configures optional Server behavior.
+type Option func(*Server)
+
+// WithImageFetcher sets the fetcher used to download potato images and
+// user-supplied image URLs. By default the server builds one on top of the
+// HTTP client passed to NewServer.
+func WithImageFetcher(f *fetch.Fetcher) Option {
+	return func(s *Server) { s.images = f }
+}
+
+// WithDecodeLimits bounds the downloaded and uploaded images the server will
+// decode. Uploads are additionally capped at maxUploadImageBytes.
+func WithDecodeLimits(l decode.Limits) Option {
+	return func(s *Server) { s.limits = l }
+}
+
+// WithPool keeps decoded potatoes and cats ready ahead of requests, using the
+// same searcher and fetcher as live requests. Requests fall back to live
+// fetches when a pool is empty. The pools only fill once Start is called.
+func WithPool(cfg pool.Config) Option {
+	return func(s *Server) { s.poolConfig = &cfg }
 }
 
 // NewServer creates a Server wired with the given dependencies and routes.
-func NewServer(potatoClient potato.Searcher, cataasClient cataas.Fetcher, memeGen meme.Generator, httpClient *http.Client) *Server {
+func NewServer(potatoClient potato.Searcher, cataasClient cataas.Fetcher, memeGen meme.Generator, httpClient *http.Client, opts ...Option) *Server {
 	s := &Server{
-		potato:     potatoClient,
-		cataas:     cataasClient,
-		meme:       memeGen,
-		httpClient: httpClient,
-		router:     http.NewServeMux(),
+		potato: potatoClient,
+		cataas: cataasClient,
+		meme:   memeGen,
+		router: http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.images == nil {
+		s.images = fetch.New(httpClient)
+	}
+	if s.poolConfig != nil {
+		s.potatoes = pool.New("potato", s.randomPotato, *s.poolConfig)
+		s.cats = pool.New("cat", s.randomCat, *s.poolConfig)
 	}
 
 	s.router.HandleFunc("GET /{$}", s.handleIndex)
 	s.router.HandleFunc("GET /meme", s.handleMeme)
+	s.router.HandleFunc("POST /meme", s.handleMeme)
 	s.router.HandleFunc("GET /health", s.handleHealth)
+	s.router.HandleFunc("GET /status", s.handleStatus)
 
 	return s
 }
 
+// Start runs the server's background work, such as filling image pools,
+// until ctx is canceled. It returns immediately.
+func (s *Server) Start(ctx context.Context) {
+	if s.potatoes != nil {
+		go s.potatoes.Run(ctx)
+		go s.cats.Run(ctx)
+	}
+}
+
 // ServeHTTP delegates to the internal mux so Server implements http.Handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
@@ -69,72 +151,275 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
-func (s *Server) handleMeme(w http.ResponseWriter, r *http.Request) {
-	topText := r.URL.Query().Get("top")
-	bottomText := r.URL.Query().Get("bottom")
+// statusResponse is the body of GET /status.
+type statusResponse struct {
+	Pools         []pool.Status         `json:"pools"`
+	PotatoSources []potato.SourceStatus `json:"potato_sources,omitempty"`
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
-	defer cancel()
+// sourceReporter is implemented by searchers that track the health of their
+// sources, such as potato.Aggregator.
+type sourceReporter interface {
+	Status() []potato.SourceStatus
+}
 
-	queries := []string{"weird potato", "funny potato", "potato fail", "potato meme", "ugly potato", "potato face"}
-	query := queries[rand.IntN(len(queries))]
+// loadReporter is implemented by searchers that want to know whether the
+// images they found could be loaded, such as potato.Aggregator.
+type loadReporter interface {
+	Report(potato.Result, error)
+}
 
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	resp := statusResponse{Pools: []pool.Status{}}
+	if s.potatoes != nil {
+		resp.Pools = append(resp.Pools, s.potatoes.Status(), s.cats.Status())
+	}
+	if sr, ok := s.potato.(sourceReporter); ok {
+		resp.PotatoSources = sr.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleMeme serves GET /meme and POST /meme. The potato_url and cat_url
+// parameters replace the searched potato and fetched cat with downloaded
+// images. A POST may carry the same parameters as form fields, plus "potato"
+// and "cat" image uploads, which take precedence over both. The q parameter
+// replaces the random search query, and sub, sort and t choose the Reddit
+// listings it is drawn from; see potato.ListingOptions. Where the potato came
+// from is reported in X-Potato-* headers and, with comment=true, in a GIF
+// comment. How the meme is drawn is read by renderOptions.
+func (s *Server) handleMeme(w http.ResponseWriter, r *http.Request) {
 	var potatoImg, catImg image.Image
+	var potatoInfo potato.Result
+	var catInfo cataas.Cat
+	if r.Method == http.MethodPost {
+		if err := parseUpload(w, r); err != nil {
+			writeError(w, errorStatus(err), err.Error())
+			return
+		}
+		var err error
+		if potatoImg, err = s.uploadedImage(r, "potato"); err == nil {
+			catImg, err = s.uploadedImage(r, "cat")
+		}
+		if err != nil {
+			writeError(w, errorStatus(err), err.Error())
+			return
+		}
+		if potatoImg != nil {
+			potatoInfo = potato.Result{Source: sourceUpload}
+		}
+	}
 
-	g, gctx := errgroup.WithContext(ctx)
+	topText := r.FormValue("top")
+	bottomText := r.FormValue("bottom")
+	potatoURL := r.FormValue("potato_url")
+	catURL := r.FormValue("cat_url")
+	userQuery := strings.TrimSpace(r.FormValue("q"))
+	if len(userQuery) > maxQueryLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("q must be at most %d bytes", maxQueryLength))
+		return
+	}
+	listing, err := listingOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := s.potato.(potato.ListingSearcher); !ok && !listing.IsZero() {
+		writeError(w, http.StatusBadRequest, listingsUnsupported)
+		return
+	}
+	catOpts, err := catOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := s.cataas.(cataas.OptionsFetcher); !ok && !catOpts.IsZero() {
+		writeError(w, http.StatusBadRequest, catOptionsUnsupported)
+		return
+	}
 
-	g.Go(func() error {
-		potatoURL, err := s.potato.SearchRandom(gctx, query)
+	// Pooled images were not picked by the seed, so a request for a
+	// specific seed always fetches live.
+	seed := rand.Uint64()
+	usePool := s.potatoes != nil
+	if v := r.FormValue("seed"); v != "" {
+		usePool = false
+		parsed, err := strconv.ParseUint(v, 10, 64)
 		if err != nil {
-			return fmt.Errorf("searching for potato image: %w", err)
+			writeError(w, http.StatusBadRequest, "seed must be a non-negative integer")
+			return
 		}
+		seed = parsed
+	}
 
-		req, err := http.NewRequestWithContext(gctx, http.MethodGet, potatoURL, nil)
-		if err != nil {
-			return fmt.Errorf("creating potato image request: %w", err)
+	enc := format.Negotiate(r.Header.Get("Accept"))
+	if v := r.FormValue("format"); v != "" {
+		e, ok := format.Lookup(v)
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported format %q (supported: %s)", v, strings.Join(format.Names(), ", ")))
+			return
 		}
+		enc = e
+	}
 
-		resp, err := s.httpClient.Do(req)
-		if err != nil {
-			return fmt.Errorf("downloading potato image: %w", err)
+	frame := 0
+	if v := r.FormValue("frame"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, "frame must be a non-negative integer")
+			return
 		}
-		defer resp.Body.Close()
+		frame = parsed
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("potato image download returned status %d", resp.StatusCode)
+	comment := false
+	if v := r.FormValue("comment"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "comment must be a boolean")
+			return
 		}
+		comment = parsed
+	}
 
-		img, _, err := image.Decode(resp.Body)
+	var quality format.Quality
+	if v := r.FormValue("quality"); v != "" {
+		q, err := format.ParseQuality(v)
 		if err != nil {
-			return fmt.Errorf("decoding potato image: %w", err)
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
 		}
+		quality = q
+	}
 
-		potatoImg = img
-		return nil
-	})
+	opts, err := renderOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Check the frame against the requested length before anything is
+	// fetched or rendered.
+	frames := opts.Frames
+	if frames == 0 {
+		frames = meme.DefaultFrames
+	}
+	if !enc.Animated() && frame >= frames {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("frame must be less than %d", frames))
+		return
+	}
 
-	g.Go(func() error {
-		img, err := s.cataas.FetchRandomCat(gctx)
-		if err != nil {
-			return fmt.Errorf("fetching cat image: %w", err)
+	// Every random choice in this request derives from the seed. The searcher,
+	// generator and cat fetcher get their own streams so that their draws
+	// don't depend on how much randomness the others consumed.
+	rng := newRand(seed)
+	potatoRNG := newRand(rng.Uint64())
+	memeRNG := newRand(rng.Uint64())
+
+	ctx, cancel := context.WithTimeout(r.Context(), fetchTimeout)
+	defer cancel()
+
+	// The random query is drawn even when q replaces it, so that q doesn't
+	// shift the rest of the seed's choices.
+	query := potatoQueries[rng.IntN(len(potatoQueries))]
+	if userQuery != "" {
+		query = userQuery
+	}
+	catRNG := newRand(rng.Uint64())
+
+	// pooled is set when a pooled image is used, which the seed can't
+	// reproduce.
+	pooled := false
+	if usePool {
+		// Pooled potatoes weren't searched for the client's query or
+		// listings.
+		if potatoImg == nil && potatoURL == "" && userQuery == "" && listing.IsZero() {
+			if found, ok := s.potatoes.Get(); ok {
+				potatoImg, potatoInfo = found.img, found.result
+				pooled = true
+			}
+		}
+		if catImg == nil && catURL == "" && catOpts.IsZero() {
+			if img, ok := s.cats.Get(); ok {
+				catImg = img
+				pooled = true
+			}
 		}
-		catImg = img
-		return nil
-	})
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	if potatoImg == nil {
+		g.Go(func() error {
+			if potatoURL != "" {
+				img, err := s.downloadImage(gctx, potatoURL)
+				if err != nil {
+					return userURLError("potato_url", err)
+				}
+				potatoImg = img
+				potatoInfo = potato.Result{URL: potatoURL, Source: sourceURL}
+				return nil
+			}
+
+			found, err := s.searchPotato(gctx, query, listing, potatoRNG)
+			if err != nil {
+				return err
+			}
+			potatoImg, potatoInfo = found.img, found.result
+			return nil
+		})
+	}
+
+	if catImg == nil {
+		g.Go(func() error {
+			if catURL != "" {
+				img, err := s.downloadImage(gctx, catURL)
+				if err != nil {
+					return userURLError("cat_url", err)
+				}
+				catImg = img
+				return nil
+			}
+
+			if !catOpts.IsZero() {
+				cat, err := s.cataas.(cataas.OptionsFetcher).FetchCat(gctx, catOpts)
+				if errors.Is(err, cataas.ErrNoCat) {
+					return &statusError{http.StatusNotFound, "no cat matches the cat_tag or cat_id"}
+				}
+				if err != nil {
+					return fmt.Errorf("fetching cat image: %w", err)
+				}
+				catImg, catInfo = cat.Image, cat
+				return nil
+			}
+
+			img, err := s.cataas.FetchRandomCat(gctx, catRNG)
+			if err != nil {
+				return fmt.Errorf("fetching cat image: %w", err)
+			}
+			catImg = img
+			return nil
+		})
+	}
 
 	if err := g.Wait(); err != nil {
 		slog.Error("failed to fetch images", "error", err)
-		writeError(w, http.StatusBadGateway, err.Error())
+		status := http.StatusBadGateway
+		var se *statusError
+		if errors.As(err, &se) {
+			status = se.status
+		}
+		writeError(w, status, err.Error())
 		return
 	}
 
-	var result *gif.GIF
-	var err error
+	var result *meme.Animation
 
+	opts.Rand = memeRNG
 	if topText != "" && bottomText != "" {
-		result, err = s.meme.Generate(potatoImg, catImg, topText, bottomText)
+		result, err = s.meme.Generate(potatoImg, catImg, topText, bottomText, opts)
 	} else {
-		result, err = s.meme.GenerateRandom(potatoImg, catImg)
+		result, err = s.meme.GenerateRandom(potatoImg, catImg, opts)
 	}
 
 	if err != nil {
@@ -143,10 +428,260 @@ func (s *Server) handleMeme(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/gif")
-	if err := gif.EncodeAll(w, result); err != nil {
-		slog.Error("failed to encode meme as GIF", "error", err)
+	if !enc.Animated() && frame >= len(result.Frames) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("frame must be less than %d", len(result.Frames)))
+		return
+	}
+
+	if potatoInfo.Width == 0 || potatoInfo.Height == 0 {
+		potatoInfo.Width, potatoInfo.Height = potatoImg.Bounds().Dx(), potatoImg.Bounds().Dy()
+	}
+	encOpts := format.Options{Frame: frame, Quality: quality}
+	if comment {
+		encOpts.Comment = potatoInfo.Attribution()
+	}
+
+	w.Header().Set("Content-Type", enc.ContentType())
+	w.Header().Set("Vary", "Accept")
+	if !pooled {
+		w.Header().Set("X-Meme-Seed", strconv.FormatUint(seed, 10))
+	}
+	setPotatoHeaders(w.Header(), potatoInfo)
+	if catInfo.ID != "" {
+		w.Header().Set("X-Cat-Id", catInfo.ID)
+	}
+	if len(catInfo.Tags) > 0 {
+		w.Header().Set("X-Cat-Tags", mime.QEncoding.Encode("utf-8", strings.Join(catInfo.Tags, ",")))
+	}
+	if err := enc.Encode(w, result, encOpts); err != nil {
+		slog.Error("failed to encode meme", "format", enc.Name(), "error", err)
+	}
+}
+
+// searchPotato searches for a potato and downloads it, or has the searcher
+// load it if it isn't a web URL and the searcher is a potato.Loader. The
+// outcome is reported back to searchers that are loadReporters.
+// Embedded fallback potatoes are used as they are. A non-zero listing
+// requires a potato.ListingSearcher.
+func (s *Server) searchPotato(ctx context.Context, query string, listing potato.ListingOptions, rng *rand.Rand) (foundPotato, error) {
+	var result potato.Result
+	var err error
+	if ls, ok := s.potato.(potato.ListingSearcher); ok && !listing.IsZero() {
+		result, err = ls.SearchListing(ctx, query, listing, rng)
+	} else {
+		result, err = s.potato.SearchRandom(ctx, query, rng)
+	}
+	if errors.Is(err, potato.ErrListingsUnsupported) {
+		return foundPotato{}, &statusError{http.StatusBadRequest, listingsUnsupported}
+	}
+	if err != nil {
+		return foundPotato{}, fmt.Errorf("searching for potato image: %w", err)
+	}
+	if img, ok := potato.Fallback(result.URL); ok {
+		return foundPotato{img, result}, nil
+	}
+	load := s.images.Fetch
+	if loader, ok := s.potato.(potato.Loader); ok && !isWebURL(result.URL) {
+		load = loader.Load
+	}
+	img, err := s.loadImage(ctx, result.URL, load)
+	if lr, ok := s.potato.(loadReporter); ok && ctx.Err() == nil {
+		// Running out of time isn't the image's fault.
+		lr.Report(result, err)
+	}
+	if err != nil {
+		return foundPotato{}, fmt.Errorf("downloading potato image from %s: %w", result.Source, err)
+	}
+	return foundPotato{img, result}, nil
+}
+
+// isWebURL reports whether raw is an http or https URL.
+func isWebURL(raw string) bool {
+	return strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://")
+}
+
+// listingOptions reads the sub, sort and t parameters of r.
+func listingOptions(r *http.Request) (potato.ListingOptions, error) {
+	var o potato.ListingOptions
+	if v := r.FormValue("sub"); v != "" {
+		subs, err := potato.ParseSubreddits(v)
+		if err != nil {
+			return o, fmt.Errorf("sub: %w", err)
+		}
+		o.Subreddits = subs
+	}
+	o.Sort = r.FormValue("sort")
+	o.Time = r.FormValue("t")
+	if err := o.Validate(); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// catOptions reads the cat_tag, cat_id, cat_says, cat_filter, cat_tint,
+// cat_width and cat_height parameters of r. cat_tint is "r,g,b".
+func catOptions(r *http.Request) (cataas.CatOptions, error) {
+	o := cataas.CatOptions{
+		ID:     r.FormValue("cat_id"),
+		Says:   r.FormValue("cat_says"),
+		Filter: r.FormValue("cat_filter"),
+	}
+	if v := r.FormValue("cat_tag"); v != "" {
+		tags, err := cataas.ParseTags(v)
+		if err != nil {
+			return o, fmt.Errorf("cat_tag: %w", err)
+		}
+		o.Tags = tags
+	}
+	if v := r.FormValue("cat_tint"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 3 {
+			return o, fmt.Errorf("cat_tint must be three comma-separated values from 0 to 255, got %q", v)
+		}
+		for i, part := range parts {
+			n, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+			if err != nil {
+				return o, fmt.Errorf("cat_tint must be three comma-separated values from 0 to 255, got %q", v)
+			}
+			o.Tint[i] = uint8(n)
+		}
+	}
+	for _, dim := range []struct {
+		param string
+		dst   *int
+	}{{"cat_width", &o.Width}, {"cat_height", &o.Height}} {
+		if v := r.FormValue(dim.param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return o, fmt.Errorf("%s must be a positive integer", dim.param)
+			}
+			*dim.dst = n
+		}
+	}
+	if err := o.Validate(); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// renderOptions reads the preset, effects, width, height, frames, fps and
+// fit parameters of r. The preset chooses how chaotic the meme is, and effects
+// changes what it draws; see meme.ParseEffects.
+func renderOptions(r *http.Request) (meme.Options, error) {
+	var o meme.Options
+	preset := meme.DefaultPreset()
+	if v := r.FormValue("preset"); v != "" {
+		p, ok := meme.LookupPreset(v)
+		if !ok {
+			return o, fmt.Errorf("unknown preset %q (supported: %s)", v, strings.Join(meme.PresetNames(), ", "))
+		}
+		preset = p
+	}
+	effects, err := meme.ParseEffects(r.FormValue("effects"), preset.Effects)
+	if err != nil {
+		return o, err
+	}
+	o.Effects, o.Chaos = effects, &preset.Chaos
+	o.Fit = strings.ToLower(r.FormValue("fit"))
+
+	for _, n := range []struct {
+		param string
+		dst   *int
+	}{{"width", &o.Width}, {"height", &o.Height}, {"frames", &o.Frames}} {
+		if v := r.FormValue(n.param); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed <= 0 {
+				return o, fmt.Errorf("%s must be a positive integer", n.param)
+			}
+			*n.dst = parsed
+		}
+	}
+	if v := r.FormValue("fps"); v != "" {
+		minFPS, maxFPS := 100.0/meme.MaxFrameDelay, 100.0/meme.MinFrameDelay
+		fps, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(fps >= minFPS && fps <= maxFPS) {
+			return o, fmt.Errorf("fps must be a number from %g to %g", minFPS, maxFPS)
+		}
+		o.Delay = int(math.Round(100 / fps))
+	}
+	if err := o.Validate(); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// randomPotato is the potato pool's source: a search with a random query.
+func (s *Server) randomPotato(ctx context.Context) (foundPotato, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	rng := newRand(rand.Uint64())
+	return s.searchPotato(ctx, potatoQueries[rng.IntN(len(potatoQueries))], potato.ListingOptions{}, rng)
+}
+
+// randomCat is the cat pool's source.
+func (s *Server) randomCat(ctx context.Context) (image.Image, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	return s.cataas.FetchRandomCat(ctx, nil)
+}
+
+// downloadImage fetches and decodes the image at url.
+func (s *Server) downloadImage(ctx context.Context, url string) (image.Image, error) {
+	return s.loadImage(ctx, url, s.images.Fetch)
+}
+
+// loadImage reads the image at url with load and decodes it. Animated GIFs
+// come back as a *decode.Animation.
+func (s *Server) loadImage(ctx context.Context, url string, load func(context.Context, string) ([]byte, error)) (image.Image, error) {
+	data, err := load(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := s.limits.DecodeAnimated(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
 	}
+	return img, nil
+}
+
+// userURLError describes a failure to download a URL the client supplied in
+// param. URLs the fetcher refuses to visit are the client's fault; anything
+// else is an upstream failure.
+func userURLError(param string, err error) error {
+	if errors.Is(err, fetch.ErrInvalidURL) || errors.Is(err, fetch.ErrBlocked) {
+		return &statusError{http.StatusBadRequest, fmt.Sprintf("%s: %v", param, err)}
+	}
+	return fmt.Errorf("downloading %s: %w", param, err)
+}
+
+// setPotatoHeaders reports the known fields of r as X-Potato-* headers.
+// Text from the source is encoded as RFC 2047 words where it isn't plain
+// ASCII.
+func setPotatoHeaders(h http.Header, r potato.Result) {
+	for _, field := range []struct{ name, value string }{
+		{"X-Potato-Source", r.Source},
+		{"X-Potato-Url", r.PublicURL()},
+		{"X-Potato-Subreddit", r.Subreddit},
+		{"X-Potato-Permalink", r.Permalink},
+		{"X-Potato-Author", r.Author},
+		{"X-Potato-Title", r.Title},
+		{"X-Potato-Query", r.Query},
+	} {
+		if field.value != "" {
+			h.Set(field.name, mime.QEncoding.Encode("utf-8", field.value))
+		}
+	}
+	if r.Width > 0 && r.Height > 0 {
+		h.Set("X-Potato-Width", strconv.Itoa(r.Width))
+		h.Set("X-Potato-Height", strconv.Itoa(r.Height))
+	}
+}
+
+// newRand returns a PCG-backed random source seeded from seed.
+func newRand(seed uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(seed, seed))
 }
 
 func writeError(w http.ResponseWriter, status int, message string) {
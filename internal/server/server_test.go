@@ -1,18 +1,36 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"image"
 	"image/color"
-	"image/color/palette"
 	"image/gif"
+	"image/jpeg"
 	"image/png"
+	"io"
+	"math/rand/v2"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/cataas"
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+	"github.com/jefflinse/potato-nice-thelma/internal/fetch"
+	"github.com/jefflinse/potato-nice-thelma/internal/imagedir"
+	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+	"github.com/jefflinse/potato-nice-thelma/internal/pool"
+	"github.com/jefflinse/potato-nice-thelma/internal/potato"
 )
 
 // ---------------------------------------------------------------------------
@@ -20,38 +38,91 @@ import (
 // ---------------------------------------------------------------------------
 
 type mockSearcher struct {
-	url string
-	err error
+	url       string
+	result    potato.Result // used as is if set, instead of url
+	err       error
+	lastQuery string
+	lastDraw  uint64
 }
 
-func (m *mockSearcher) SearchRandom(_ context.Context, _ string) (string, error) {
-	return m.url, m.err
+func (m *mockSearcher) SearchRandom(_ context.Context, query string, rng *rand.Rand) (potato.Result, error) {
+	m.lastQuery = query
+	m.lastDraw = rng.Uint64()
+	if m.result.URL != "" {
+		return m.result, m.err
+	}
+	return potato.Result{URL: m.url, Source: "mock"}, m.err
+}
+
+// listingSearcher is a mockSearcher that also takes Reddit listing options.
+type listingSearcher struct {
+	mockSearcher
+	lastListing potato.ListingOptions
+}
+
+func (m *listingSearcher) SearchListing(ctx context.Context, query string, o potato.ListingOptions, rng *rand.Rand) (potato.Result, error) {
+	m.lastListing = o
+	return m.SearchRandom(ctx, query, rng)
+}
+
+// blockingSearcher finds no potato, only returning once the request is
+// canceled, so that it can't fail a request before a cat download does.
+type blockingSearcher struct{}
+
+func (blockingSearcher) SearchRandom(ctx context.Context, _ string, _ *rand.Rand) (potato.Result, error) {
+	<-ctx.Done()
+	return potato.Result{}, ctx.Err()
 }
 
 type mockFetcher struct {
-	img image.Image
-	err error
+	img      image.Image
+	err      error
+	called   bool
+	lastDraw uint64
 }
 
-func (m *mockFetcher) FetchRandomCat(_ context.Context) (image.Image, error) {
+func (m *mockFetcher) FetchRandomCat(_ context.Context, rng *rand.Rand) (image.Image, error) {
+	m.called = true
+	m.lastDraw = rng.Uint64()
 	return m.img, m.err
 }
 
+// catOptionsFetcher is a mockFetcher that also takes cat options.
+type catOptionsFetcher struct {
+	mockFetcher
+	lastOpts cataas.CatOptions
+	cat      cataas.Cat
+}
+
+func (m *catOptionsFetcher) FetchCat(_ context.Context, o cataas.CatOptions) (cataas.Cat, error) {
+	m.lastOpts = o
+	m.called = true
+	return m.cat, m.err
+}
+
 type mockGenerator struct {
-	gif            *gif.GIF
+	anim           *meme.Animation
 	err            error
 	generateCalled bool
 	randomCalled   bool
+	lastDraw       uint64
+	lastPotato     image.Image
+	lastOpts       meme.Options
 }
 
-func (m *mockGenerator) Generate(_, _ image.Image, _, _ string) (*gif.GIF, error) {
+func (m *mockGenerator) Generate(_, _ image.Image, _, _ string, opts meme.Options) (*meme.Animation, error) {
 	m.generateCalled = true
-	return m.gif, m.err
+	m.lastDraw = opts.Rand.Uint64()
+	m.lastOpts = opts
+	return m.anim, m.err
 }
 
-func (m *mockGenerator) GenerateRandom(_, _ image.Image) (*gif.GIF, error) {
+func (m *mockGenerator) GenerateRandom(potatoImg, _ image.Image, opts meme.Options) (*meme.Animation, error) {
 	m.randomCalled = true
-	return m.gif, m.err
+	m.lastPotato = potatoImg
+	m.lastDraw = opts.Rand.Uint64()
+	m.lastOpts = opts
+	return m.anim, m.err
 }
 
 // ---------------------------------------------------------------------------
@@ -65,13 +136,18 @@ func testImage() image.Image {
 	return img
 }
 
-// testGIF creates a minimal 1-frame GIF for use in tests.
-func testGIF() *gif.GIF {
-	frame := image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9)
-	return &gif.GIF{
-		Image: []*image.Paletted{frame},
-		Delay: []int{8},
+// testAnimation creates a minimal 2-frame animation for use in tests.
+func testAnimation() *meme.Animation {
+	anim := &meme.Animation{}
+	for _, c := range []color.RGBA{{R: 255, A: 255}, {B: 255, A: 255}} {
+		frame := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		for i := 0; i < len(frame.Pix); i += 4 {
+			frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2], frame.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+		anim.Frames = append(anim.Frames, frame)
+		anim.Delays = append(anim.Delays, 8)
 	}
+	return anim
 }
 
 // pngServer returns an httptest.Server that serves a valid PNG at any path.
@@ -85,6 +161,13 @@ func pngServer(t *testing.T) *httptest.Server {
 	}))
 }
 
+// testFetcher returns an option that lets the server download from httptest
+// servers, which listen on a loopback address the default fetcher refuses to
+// dial.
+func testFetcher(client *http.Client) Option {
+	return WithImageFetcher(fetch.New(client, fetch.WithAllowedPrefixes(netip.MustParsePrefix("127.0.0.1/32"))))
+}
+
 // errorServer returns an httptest.Server that always responds with the given status code.
 func errorServer(status int) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
@@ -131,12 +214,13 @@ func TestHandleMeme_RandomText(t *testing.T) {
 	imgSrv := pngServer(t)
 	defer imgSrv.Close()
 
-	gen := &mockGenerator{gif: testGIF()}
+	gen := &mockGenerator{anim: testAnimation()}
 	srv := NewServer(
 		&mockSearcher{url: imgSrv.URL + "/potato.png"},
 		&mockFetcher{img: testImage()},
 		gen,
 		imgSrv.Client(),
+		testFetcher(imgSrv.Client()),
 	)
 
 	req := httptest.NewRequest(http.MethodGet, "/meme", nil)
@@ -172,12 +256,13 @@ func TestHandleMeme_CustomText(t *testing.T) {
 	imgSrv := pngServer(t)
 	defer imgSrv.Close()
 
-	gen := &mockGenerator{gif: testGIF()}
+	gen := &mockGenerator{anim: testAnimation()}
 	srv := NewServer(
 		&mockSearcher{url: imgSrv.URL + "/potato.png"},
 		&mockFetcher{img: testImage()},
 		gen,
 		imgSrv.Client(),
+		testFetcher(imgSrv.Client()),
 	)
 
 	req := httptest.NewRequest(http.MethodGet, "/meme?top=hello&bottom=world", nil)
@@ -213,12 +298,13 @@ func TestHandleMeme_PartialCustomText_UsesRandom(t *testing.T) {
 	imgSrv := pngServer(t)
 	defer imgSrv.Close()
 
-	gen := &mockGenerator{gif: testGIF()}
+	gen := &mockGenerator{anim: testAnimation()}
 	srv := NewServer(
 		&mockSearcher{url: imgSrv.URL + "/potato.png"},
 		&mockFetcher{img: testImage()},
 		gen,
 		imgSrv.Client(),
+		testFetcher(imgSrv.Client()),
 	)
 
 	req := httptest.NewRequest(http.MethodGet, "/meme?top=hello", nil)
@@ -244,7 +330,7 @@ func TestHandleMeme_GiphyFailure(t *testing.T) {
 	srv := NewServer(
 		&mockSearcher{err: errors.New("potato search failed")},
 		&mockFetcher{img: testImage()},
-		&mockGenerator{gif: testGIF()},
+		&mockGenerator{anim: testAnimation()},
 		http.DefaultClient,
 	)
 
@@ -281,8 +367,9 @@ func TestHandleMeme_CataasFailure(t *testing.T) {
 	srv := NewServer(
 		&mockSearcher{url: imgSrv.URL + "/potato.png"},
 		&mockFetcher{err: errors.New("cataas is down")},
-		&mockGenerator{gif: testGIF()},
+		&mockGenerator{anim: testAnimation()},
 		imgSrv.Client(),
+		testFetcher(imgSrv.Client()),
 	)
 
 	req := httptest.NewRequest(http.MethodGet, "/meme", nil)
@@ -320,6 +407,7 @@ func TestHandleMeme_MemeGenerationFailure(t *testing.T) {
 		&mockFetcher{img: testImage()},
 		&mockGenerator{err: errors.New("meme generation failed")},
 		imgSrv.Client(),
+		testFetcher(imgSrv.Client()),
 	)
 
 	req := httptest.NewRequest(http.MethodGet, "/meme", nil)
@@ -355,8 +443,9 @@ func TestHandleMeme_PotatoImageDownloadFailure(t *testing.T) {
 	srv := NewServer(
 		&mockSearcher{url: errSrv.URL + "/potato.png"},
 		&mockFetcher{img: testImage()},
-		&mockGenerator{gif: testGIF()},
+		&mockGenerator{anim: testAnimation()},
 		errSrv.Client(),
+		testFetcher(errSrv.Client()),
 	)
 
 	req := httptest.NewRequest(http.MethodGet, "/meme", nil)
@@ -383,6 +472,1010 @@ func TestHandleMeme_PotatoImageDownloadFailure(t *testing.T) {
 	}
 }
 
+func TestHandleMeme_ImageURLs(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	t.Cleanup(imgSrv.Close)
+	errSrv := errorServer(http.StatusNotFound)
+	t.Cleanup(errSrv.Close)
+
+	tests := []struct {
+		name      string
+		target    string
+		fetchOpts []Option
+		// blockSearch holds the potato search until the request fails, so
+		// that a blocked cat_url isn't raced by a blocked potato download.
+		blockSearch bool
+		wantStatus  int
+		wantSearch  bool
+		wantFetch   bool
+	}{
+		{
+			name:       "both urls",
+			target:     "/meme?potato_url=" + imgSrv.URL + "/p.png&cat_url=" + imgSrv.URL + "/c.png",
+			fetchOpts:  []Option{testFetcher(imgSrv.Client())},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "potato url only",
+			target:     "/meme?potato_url=" + imgSrv.URL + "/p.png",
+			fetchOpts:  []Option{testFetcher(imgSrv.Client())},
+			wantStatus: http.StatusOK,
+			wantFetch:  true,
+		},
+		{
+			name:       "cat url only",
+			target:     "/meme?cat_url=" + imgSrv.URL + "/c.png",
+			fetchOpts:  []Option{testFetcher(imgSrv.Client())},
+			wantStatus: http.StatusOK,
+			wantSearch: true,
+		},
+		{
+			name:       "loopback url blocked",
+			target:     "/meme?potato_url=" + imgSrv.URL + "/p.png",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "metadata service blocked",
+			target:      "/meme?cat_url=http://169.254.169.254/latest/meta-data/",
+			blockSearch: true,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "non-http scheme",
+			target:      "/meme?cat_url=file:///etc/passwd",
+			blockSearch: true,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:       "upstream failure",
+			target:     "/meme?cat_url=" + errSrv.URL + "/c.png",
+			fetchOpts:  []Option{testFetcher(errSrv.Client())},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			// Searcher results are untrusted too, but a bad one is not the
+			// client's fault.
+			name:       "searched potato on loopback",
+			target:     "/meme",
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			searcher := &mockSearcher{url: imgSrv.URL + "/potato.png"}
+			var potatoSearcher potato.Searcher = searcher
+			if tt.blockSearch {
+				potatoSearcher = blockingSearcher{}
+			}
+			fetcher := &mockFetcher{img: testImage()}
+			srv := NewServer(potatoSearcher, fetcher, &mockGenerator{anim: testAnimation()}, imgSrv.Client(), tt.fetchOpts...)
+
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d; body: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			// Whether the other image was fetched before a failing download
+			// canceled the request is a race, so only check successes.
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if searched := searcher.lastQuery != ""; searched != tt.wantSearch {
+				t.Errorf("searcher called = %v, want %v", searched, tt.wantSearch)
+			}
+			if fetcher.called != tt.wantFetch {
+				t.Errorf("fetcher called = %v, want %v", fetcher.called, tt.wantFetch)
+			}
+		})
+	}
+}
+
+func TestHandleMeme_OversizedPotatoImage(t *testing.T) {
+	t.Parallel()
+
+	// A 4x4 potato against an 8-pixel budget stands in for a decompression
+	// bomb against the real one.
+	imgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	}))
+	defer imgSrv.Close()
+
+	gen := &mockGenerator{anim: testAnimation()}
+	srv := NewServer(
+		&mockSearcher{url: imgSrv.URL + "/potato.png"},
+		&mockFetcher{img: testImage()},
+		gen,
+		imgSrv.Client(),
+		testFetcher(imgSrv.Client()),
+		WithDecodeLimits(decode.Limits{MaxPixels: 8}),
+	)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme", nil))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected status 502, got %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "image dimensions too large") {
+		t.Errorf("expected a dimension error, got %s", rec.Body.String())
+	}
+	if gen.randomCalled || gen.generateCalled {
+		t.Error("generator should not run without a potato")
+	}
+}
+
+// offlineTransport fails every request, standing in for a machine with no
+// network.
+type offlineTransport struct{ used atomic.Bool }
+
+func (o *offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	o.used.Store(true)
+	return nil, errors.New("network unavailable")
+}
+
+func TestHandleMeme_LocalDirectories(t *testing.T) {
+	t.Parallel()
+
+	dirs := map[string]*imagedir.Dir{}
+	for _, name := range []string{"potatoes", "cats"} {
+		root := t.TempDir()
+		f, err := os.Create(filepath.Join(root, name+".png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, testImage()); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if dirs[name], err = imagedir.New(root); err != nil {
+			t.Fatalf("imagedir.New() error: %v", err)
+		}
+	}
+
+	offline := &offlineTransport{}
+	client := &http.Client{Transport: offline}
+	srv := NewServer(
+		potato.NewLocalSearcher(dirs["potatoes"]),
+		cataas.NewLocalClient(dirs["cats"], decode.Limits{}),
+		&mockGenerator{anim: testAnimation()},
+		client,
+	)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if offline.used.Load() {
+		t.Error("the server used the network despite local image directories")
+	}
+	if got := rec.Header().Get("X-Potato-Url"); got != "local:potatoes.png" {
+		t.Errorf("X-Potato-Url = %q, want the file name without its directory", got)
+	}
+}
+
+func TestHandleMeme_FallbackPotatoIsNotDownloaded(t *testing.T) {
+	t.Parallel()
+
+	offline := &offlineTransport{}
+	srv := NewServer(
+		&mockSearcher{url: "fallback:russet.png"},
+		&mockFetcher{img: testImage()},
+		&mockGenerator{anim: testAnimation()},
+		&http.Client{Transport: offline},
+	)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if offline.used.Load() {
+		t.Error("the embedded fallback potato was downloaded")
+	}
+}
+
+func TestHandleMeme_SeedIsReproducible(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+
+	run := func(target string) (*mockSearcher, *mockFetcher, *mockGenerator, *httptest.ResponseRecorder) {
+		searcher := &mockSearcher{url: imgSrv.URL + "/potato.png"}
+		fetcher := &mockFetcher{img: testImage()}
+		gen := &mockGenerator{anim: testAnimation()}
+		srv := NewServer(searcher, fetcher, gen, imgSrv.Client(), testFetcher(imgSrv.Client()))
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d; body: %s", target, rec.Code, rec.Body.String())
+		}
+		return searcher, fetcher, gen, rec
+	}
+
+	s1, f1, g1, rec := run("/meme?seed=42")
+	s2, f2, g2, _ := run("/meme?seed=42")
+
+	if got := rec.Header().Get("X-Meme-Seed"); got != "42" {
+		t.Errorf("expected X-Meme-Seed 42, got %q", got)
+	}
+	if s1.lastQuery != s2.lastQuery {
+		t.Errorf("same seed picked different queries: %q vs %q", s1.lastQuery, s2.lastQuery)
+	}
+	if s1.lastDraw != s2.lastDraw {
+		t.Error("same seed gave the searcher different random streams")
+	}
+	if g1.lastDraw != g2.lastDraw {
+		t.Error("same seed gave the generator different random streams")
+	}
+	if f1.lastDraw != f2.lastDraw {
+		t.Error("same seed gave the cat fetcher different random streams")
+	}
+
+	s3, f3, g3, _ := run("/meme?seed=43")
+	if s1.lastDraw == s3.lastDraw && f1.lastDraw == f3.lastDraw && g1.lastDraw == g3.lastDraw {
+		t.Error("different seeds gave identical random streams")
+	}
+}
+
+func TestHandleMeme_QueryParam(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+
+	run := func(target string) (*mockSearcher, *mockGenerator, *httptest.ResponseRecorder) {
+		searcher := &mockSearcher{result: potato.Result{URL: imgSrv.URL + "/potato.png", Source: "mock", Query: "purple potato"}}
+		gen := &mockGenerator{anim: testAnimation()}
+		srv := NewServer(searcher, &mockFetcher{img: testImage()}, gen, imgSrv.Client(), testFetcher(imgSrv.Client()))
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d; body: %s", target, rec.Code, rec.Body.String())
+		}
+		return searcher, gen, rec
+	}
+
+	plain, plainGen, _ := run("/meme?seed=42")
+	searched, searchedGen, rec := run("/meme?seed=42&q=+purple+potato+")
+
+	if searched.lastQuery != "purple potato" {
+		t.Errorf("searcher got query %q, want %q", searched.lastQuery, "purple potato")
+	}
+	if plain.lastQuery == "purple potato" {
+		t.Error("without q the searcher should get a random query")
+	}
+	if plain.lastDraw != searched.lastDraw || plainGen.lastDraw != searchedGen.lastDraw {
+		t.Error("q changed the random streams of the seed")
+	}
+	if got := rec.Header().Get("X-Potato-Query"); got != "purple potato" {
+		t.Errorf("X-Potato-Query = %q, want %q", got, "purple potato")
+	}
+}
+
+func TestHandleMeme_ListingParams(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       potato.ListingOptions
+	}{
+		{"/meme", http.StatusOK, potato.ListingOptions{}},
+		{"/meme?sub=cats:2,r/potato&sort=top&t=week", http.StatusOK, potato.ListingOptions{
+			Subreddits: []potato.Subreddit{{Name: "cats", Weight: 2}, {Name: "potato", Weight: 1}},
+			Sort:       "top",
+			Time:       "week",
+		}},
+		{"/meme?sort=new", http.StatusOK, potato.ListingOptions{Sort: "new"}},
+		{"/meme?sub=../../api", http.StatusBadRequest, potato.ListingOptions{}},
+		{"/meme?sub=cats:-1", http.StatusBadRequest, potato.ListingOptions{}},
+		{"/meme?sort=controversial", http.StatusBadRequest, potato.ListingOptions{}},
+		{"/meme?t=forever", http.StatusBadRequest, potato.ListingOptions{}},
+	}
+	for _, tt := range tests {
+		searcher := &listingSearcher{mockSearcher: mockSearcher{url: imgSrv.URL + "/potato.png"}}
+		srv := NewServer(searcher, &mockFetcher{img: testImage()}, &mockGenerator{anim: testAnimation()}, imgSrv.Client(), testFetcher(imgSrv.Client()))
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("GET %s: expected status %d, got %d; body: %s", tt.target, tt.wantStatus, rec.Code, rec.Body.String())
+			continue
+		}
+		if !reflect.DeepEqual(searcher.lastListing, tt.want) {
+			t.Errorf("GET %s: searcher got listing %+v, want %+v", tt.target, searcher.lastListing, tt.want)
+		}
+	}
+}
+
+func TestHandleMeme_CatParams(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       cataas.CatOptions
+	}{
+		{"/meme?cat_tag=orange,cute&cat_says=hi%20there&cat_width=300", http.StatusOK, cataas.CatOptions{
+			Tags:  []string{"orange", "cute"},
+			Says:  "hi there",
+			Width: 300,
+		}},
+		{"/meme?cat_id=abc123&cat_filter=custom&cat_tint=255,0,10&cat_height=200", http.StatusOK, cataas.CatOptions{
+			ID:     "abc123",
+			Filter: "custom",
+			Tint:   [3]uint8{255, 0, 10},
+			Height: 200,
+		}},
+		{"/meme?cat_filter=mono", http.StatusOK, cataas.CatOptions{Filter: "mono"}},
+		{"/meme?cat_tag=../admin", http.StatusBadRequest, cataas.CatOptions{}},
+		{"/meme?cat_id=a/b", http.StatusBadRequest, cataas.CatOptions{}},
+		{"/meme?cat_filter=sparkle", http.StatusBadRequest, cataas.CatOptions{}},
+		{"/meme?cat_tint=1,2", http.StatusBadRequest, cataas.CatOptions{}},
+		{"/meme?cat_tint=1,2,256&cat_filter=custom", http.StatusBadRequest, cataas.CatOptions{}},
+		{"/meme?cat_tint=1,2,3", http.StatusBadRequest, cataas.CatOptions{}},
+		{"/meme?cat_width=0", http.StatusBadRequest, cataas.CatOptions{}},
+		{"/meme?cat_height=99999", http.StatusBadRequest, cataas.CatOptions{}},
+	}
+	for _, tt := range tests {
+		fetcher := &catOptionsFetcher{cat: cataas.Cat{Image: testImage(), ID: "abc123", Tags: []string{"orange", "cute"}}}
+		srv := NewServer(&mockSearcher{url: imgSrv.URL + "/potato.png"}, fetcher, &mockGenerator{anim: testAnimation()}, imgSrv.Client(), testFetcher(imgSrv.Client()))
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("GET %s: expected status %d, got %d; body: %s", tt.target, tt.wantStatus, rec.Code, rec.Body.String())
+			continue
+		}
+		if !reflect.DeepEqual(fetcher.lastOpts, tt.want) {
+			t.Errorf("GET %s: fetcher got options %+v, want %+v", tt.target, fetcher.lastOpts, tt.want)
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		if got := rec.Header().Get("X-Cat-Id"); got != "abc123" {
+			t.Errorf("GET %s: X-Cat-Id = %q, want abc123", tt.target, got)
+		}
+		if got := rec.Header().Get("X-Cat-Tags"); got != "orange,cute" {
+			t.Errorf("GET %s: X-Cat-Tags = %q, want orange,cute", tt.target, got)
+		}
+	}
+}
+
+func TestHandleMeme_CatParamsErrors(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+	searcher := &mockSearcher{url: imgSrv.URL + "/potato.png"}
+
+	// A cat fetcher without options, such as the local directory.
+	srv := NewServer(searcher, &mockFetcher{img: testImage()}, &mockGenerator{anim: testAnimation()}, imgSrv.Client(), testFetcher(imgSrv.Client()))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme?cat_tag=orange", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("cat_tag without CATAAS: expected status 400, got %d", rec.Code)
+	}
+
+	fetcher := &catOptionsFetcher{mockFetcher: mockFetcher{err: cataas.ErrNoCat}}
+	srv = NewServer(searcher, fetcher, &mockGenerator{anim: testAnimation()}, imgSrv.Client(), testFetcher(imgSrv.Client()))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme?cat_tag=purple", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown cat_tag: expected status 404, got %d", rec.Code)
+	}
+}
+
+func TestHandleMeme_PresetAndEffects(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+	searcher := &mockSearcher{url: imgSrv.URL + "/potato.png"}
+
+	tests := []struct {
+		query  string
+		status int
+		want   []string
+	}{
+		{"", http.StatusOK, meme.EffectNames()},
+		{"?effects=glow,sparkles,text", http.StatusOK, []string{"cat", "glow", "potato", "sparkles", "text"}},
+		{"?effects=-shake,-ticker", http.StatusOK, []string{"zoom", "cat", "hypno", "glow", "potato", "clones", "bursts", "sparkles", "text"}},
+		{"?effects=glitter", http.StatusBadRequest, nil},
+		{"?effects=glow,-ticker", http.StatusBadRequest, nil},
+		{"?preset=classic", http.StatusOK, []string{"cat", "potato", "text"}},
+		{"?preset=mild&effects=-glow", http.StatusOK, []string{"zoom", "cat", "potato", "sparkles", "text"}},
+		{"?preset=spicy", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		gen := &mockGenerator{anim: testAnimation()}
+		srv := NewServer(searcher, &mockFetcher{img: testImage()}, gen, imgSrv.Client(), testFetcher(imgSrv.Client()))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.status, rec.Code)
+			continue
+		}
+		var got []string
+		for _, e := range gen.lastOpts.Effects {
+			got = append(got, e.Name())
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: generator got effects %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestHandleMeme_Size(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+	searcher := &mockSearcher{url: imgSrv.URL + "/potato.png"}
+
+	tests := []struct {
+		query  string
+		status int
+		want   [4]int // width, height, frames, delay
+	}{
+		{"", http.StatusOK, [4]int{}},
+		{"?width=1080&height=1080", http.StatusOK, [4]int{1080, 1080, 0, 0}},
+		{"?width=320&height=240&frames=32&fps=25", http.StatusOK, [4]int{320, 240, 32, 4}},
+		{"?fps=12.5", http.StatusOK, [4]int{0, 0, 0, 8}},
+		{"?width=10000&height=10000", http.StatusBadRequest, [4]int{}},
+		{"?width=32", http.StatusBadRequest, [4]int{}},
+		{"?width=-640", http.StatusBadRequest, [4]int{}},
+		{"?width=wide", http.StatusBadRequest, [4]int{}},
+		{"?frames=1000", http.StatusBadRequest, [4]int{}},
+		{"?width=1920&height=1920&frames=120", http.StatusBadRequest, [4]int{}},
+		{"?fps=0", http.StatusBadRequest, [4]int{}},
+		{"?fps=100", http.StatusBadRequest, [4]int{}},
+		{"?fps=NaN", http.StatusBadRequest, [4]int{}},
+	}
+	for _, tt := range tests {
+		gen := &mockGenerator{anim: testAnimation()}
+		srv := NewServer(searcher, &mockFetcher{img: testImage()}, gen, imgSrv.Client(), testFetcher(imgSrv.Client()))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.status, rec.Code)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			if gen.randomCalled || gen.generateCalled {
+				t.Errorf("%q: generator called for a rejected request", tt.query)
+			}
+			continue
+		}
+		o := gen.lastOpts
+		if got := [4]int{o.Width, o.Height, o.Frames, o.Delay}; got != tt.want {
+			t.Errorf("%q: generator got size %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestHandleMeme_Fit(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+	searcher := &mockSearcher{url: imgSrv.URL + "/potato.png"}
+
+	tests := []struct {
+		query  string
+		status int
+		want   string
+	}{
+		{"", http.StatusOK, ""},
+		{"?fit=contain", http.StatusOK, meme.FitContain},
+		{"?fit=Blur-Fill", http.StatusOK, meme.FitBlurFill},
+		{"?fit=stretch", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		gen := &mockGenerator{anim: testAnimation()}
+		srv := NewServer(searcher, &mockFetcher{img: testImage()}, gen, imgSrv.Client(), testFetcher(imgSrv.Client()))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.status, rec.Code)
+			continue
+		}
+		if got := gen.lastOpts.Fit; got != tt.want {
+			t.Errorf("%q: generator got fit %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestHandleMeme_AnimatedPotato(t *testing.T) {
+	t.Parallel()
+
+	g := &gif.GIF{Delay: []int{10, 10, 10}}
+	for _, c := range []color.Color{color.White, color.Black, color.White} {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 8, 6), color.Palette{c}))
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatalf("encoding test GIF: %v", err)
+	}
+	gifSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/gif")
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(gifSrv.Close)
+
+	for _, target := range []string{"/meme", "/meme?potato_url=" + gifSrv.URL + "/p.gif"} {
+		gen := &mockGenerator{anim: testAnimation()}
+		srv := NewServer(&mockSearcher{url: gifSrv.URL + "/spud.gif"}, &mockFetcher{img: testImage()}, gen, gifSrv.Client(), testFetcher(gifSrv.Client()))
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d; body: %s", target, rec.Code, rec.Body.String())
+		}
+		if a, ok := gen.lastPotato.(*decode.Animation); !ok || a.Len() != 3 {
+			t.Errorf("GET %s: generator got a %T potato, want the 3-frame *decode.Animation", target, gen.lastPotato)
+		}
+	}
+}
+
+func TestHandleMeme_SeedHeaderWithoutSeedParam(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+
+	srv := NewServer(
+		&mockSearcher{url: imgSrv.URL + "/potato.png"},
+		&mockFetcher{img: testImage()},
+		&mockGenerator{anim: testAnimation()},
+		imgSrv.Client(),
+		testFetcher(imgSrv.Client()),
+	)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Meme-Seed") == "" {
+		t.Error("expected X-Meme-Seed header so the meme can be reproduced")
+	}
+}
+
+func TestHandleMeme_InvalidSeed(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(&mockSearcher{}, &mockFetcher{}, &mockGenerator{}, http.DefaultClient)
+
+	for _, seed := range []string{"abc", "-1", "1.5"} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme?seed="+seed, nil))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("seed=%s: expected status 400, got %d", seed, rec.Code)
+		}
+	}
+}
+
+func TestHandleMeme_Formats(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	t.Cleanup(imgSrv.Close)
+
+	tests := []struct {
+		name        string
+		target      string
+		accept      string
+		contentType string
+		decode      func(io.Reader) error
+	}{
+		{
+			name:        "default is gif",
+			target:      "/meme",
+			contentType: "image/gif",
+			decode:      func(r io.Reader) error { _, err := gif.DecodeAll(r); return err },
+		},
+		{
+			name:        "format param",
+			target:      "/meme?format=png&frame=1",
+			contentType: "image/png",
+			decode:      func(r io.Reader) error { _, err := png.Decode(r); return err },
+		},
+		{
+			name:        "jpg alias",
+			target:      "/meme?format=jpg",
+			contentType: "image/jpeg",
+			decode:      func(r io.Reader) error { _, err := jpeg.Decode(r); return err },
+		},
+		{
+			name:        "apng via accept",
+			target:      "/meme",
+			accept:      "image/apng",
+			contentType: "image/apng",
+			decode:      func(r io.Reader) error { _, err := png.Decode(r); return err },
+		},
+		{
+			name:        "format param beats accept",
+			target:      "/meme?format=gif",
+			accept:      "image/webp",
+			contentType: "image/gif",
+			decode:      func(r io.Reader) error { _, err := gif.DecodeAll(r); return err },
+		},
+		{
+			name:        "quality param",
+			target:      "/meme?quality=high",
+			contentType: "image/gif",
+			decode:      func(r io.Reader) error { _, err := gif.DecodeAll(r); return err },
+		},
+		{
+			name:        "webp",
+			target:      "/meme?format=webp",
+			contentType: "image/webp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(
+				&mockSearcher{url: imgSrv.URL + "/potato.png"},
+				&mockFetcher{img: testImage()},
+				&mockGenerator{anim: testAnimation()},
+				imgSrv.Client(),
+				testFetcher(imgSrv.Client()),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d; body: %s", rec.Code, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("expected Content-Type %s, got %q", tt.contentType, ct)
+			}
+			if tt.decode != nil {
+				if err := tt.decode(rec.Body); err != nil {
+					t.Errorf("response body does not decode as %s: %v", tt.contentType, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMeme_PotatoAttribution(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+
+	newServer := func() *Server {
+		return NewServer(
+			&mockSearcher{result: potato.Result{
+				URL:       imgSrv.URL + "/potato.png",
+				Source:    potato.SourceReddit,
+				Subreddit: "potato",
+				Permalink: "https://www.reddit.com/r/potato/comments/abc123/spud/",
+				Author:    "spudlover",
+				Title:     "Behold, my spüd",
+			}},
+			&mockFetcher{img: testImage()},
+			&mockGenerator{anim: testAnimation()},
+			imgSrv.Client(),
+			testFetcher(imgSrv.Client()),
+		)
+	}
+
+	rec := httptest.NewRecorder()
+	newServer().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d; body: %s", rec.Code, rec.Body.String())
+	}
+
+	for header, want := range map[string]string{
+		"X-Potato-Source":    "reddit",
+		"X-Potato-Url":       imgSrv.URL + "/potato.png",
+		"X-Potato-Subreddit": "potato",
+		"X-Potato-Permalink": "https://www.reddit.com/r/potato/comments/abc123/spud/",
+		"X-Potato-Author":    "spudlover",
+		"X-Potato-Title":     "=?utf-8?q?Behold,_my_sp=C3=BCd?=",
+		"X-Potato-Width":     "1", // from the decoded image, as the searcher didn't say
+		"X-Potato-Height":    "1",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if strings.Contains(rec.Body.String(), "spudlover") {
+		t.Error("attribution embedded in the GIF without comment=true")
+	}
+
+	rec = httptest.NewRecorder()
+	newServer().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme?comment=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("comment=true: expected status 200, got %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "author: u/spudlover\ntitle: Behold, my spüd\n") {
+		t.Error("comment=true: GIF does not carry the attribution comment")
+	}
+	if _, err := gif.DecodeAll(rec.Body); err != nil {
+		t.Errorf("comment=true: response is not a valid GIF: %v", err)
+	}
+}
+
+func TestHandleMeme_InvalidFormatParams(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+
+	for _, target := range []string{
+		"/meme?format=bmp",
+		"/meme?format=png&frame=-1",
+		"/meme?format=png&frame=x",
+		"/meme?format=png&frame=2", // the test animation has two frames
+		"/meme?quality=ultra",
+		"/meme?comment=maybe",
+		"/meme?q=" + strings.Repeat("potato", 34),
+		"/meme?sub=potato", // the mock searcher doesn't take listings
+	} {
+		srv := NewServer(
+			&mockSearcher{url: imgSrv.URL + "/potato.png"},
+			&mockFetcher{img: testImage()},
+			&mockGenerator{anim: testAnimation()},
+			imgSrv.Client(),
+			testFetcher(imgSrv.Client()),
+		)
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected status 400, got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestHandleMeme_FrameOutOfRangeSkipsRender(t *testing.T) {
+	t.Parallel()
+
+	for _, target := range []string{
+		"/meme?format=png&frame=16", // the default length
+		"/meme?format=png&frames=4&frame=4",
+	} {
+		searcher := &mockSearcher{url: "http://unused.invalid/potato.png"}
+		fetcher := &mockFetcher{img: testImage()}
+		gen := &mockGenerator{anim: testAnimation()}
+		srv := NewServer(searcher, fetcher, gen, http.DefaultClient)
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected status 400, got %d", target, rec.Code)
+		}
+		if searcher.lastQuery != "" || fetcher.called || gen.generateCalled || gen.randomCalled {
+			t.Errorf("GET %s: images were fetched or rendered for an out of range frame", target)
+		}
+	}
+}
+
+// countingSearcher and countingFetcher are safe for the concurrent use the
+// image pools make of them.
+type countingSearcher struct {
+	url   string
+	calls atomic.Int64
+}
+
+func (m *countingSearcher) SearchRandom(context.Context, string, *rand.Rand) (potato.Result, error) {
+	m.calls.Add(1)
+	return potato.Result{URL: m.url, Source: "mock"}, nil
+}
+
+type countingFetcher struct {
+	calls atomic.Int64
+}
+
+func (m *countingFetcher) FetchRandomCat(context.Context, *rand.Rand) (image.Image, error) {
+	m.calls.Add(1)
+	return testImage(), nil
+}
+
+// getStatus fetches and decodes GET /status.
+func getStatus(t *testing.T, srv *Server) statusResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /status: expected status 200, got %d", rec.Code)
+	}
+	var status statusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("decoding /status: %v", err)
+	}
+	return status
+}
+
+func TestHandleMeme_ServesFromPool(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+
+	searcher := &countingSearcher{url: imgSrv.URL + "/potato.png"}
+	fetcher := &countingFetcher{}
+	srv := NewServer(searcher, fetcher, &mockGenerator{anim: testAnimation()}, imgSrv.Client(),
+		testFetcher(imgSrv.Client()),
+		WithPool(pool.Config{Depth: 2, Workers: 1, RefillInterval: time.Hour}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.Start(ctx)
+
+	// The first fetch is immediate; the hourly refill interval keeps the
+	// pools from refilling during the test.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := getStatus(t, srv)
+		if st.Pools[0].Ready == 1 && st.Pools[1].Ready == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pools did not fill: %+v", st.Pools)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	searches, fetches := searcher.calls.Load(), fetcher.calls.Load()
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if searcher.calls.Load() != searches || fetcher.calls.Load() != fetches {
+		t.Error("pooled request should not search or fetch")
+	}
+	if got := rec.Header().Get("X-Potato-Source"); got != "mock" {
+		t.Errorf("pooled potato X-Potato-Source = %q, want the searcher's source", got)
+	}
+	// The seed didn't pick the pooled images, so it can't reproduce them.
+	if got := rec.Header().Get("X-Meme-Seed"); got != "" {
+		t.Errorf("pooled request X-Meme-Seed = %q, want none", got)
+	}
+
+	// Pools are empty now, and a seeded request skips them anyway.
+	for _, target := range []string{"/meme", "/meme?seed=7"} {
+		rec = httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d; body: %s", target, rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("X-Meme-Seed") == "" {
+			t.Errorf("GET %s: live request has no X-Meme-Seed", target)
+		}
+	}
+	if got := searcher.calls.Load(); got != searches+2 {
+		t.Errorf("searches = %d, want %d live searches", got-searches, 2)
+	}
+
+	for _, ps := range getStatus(t, srv).Pools {
+		if ps.Hits != 1 || ps.Misses != 1 {
+			t.Errorf("%s pool hits/misses = %d/%d, want 1/1", ps.Name, ps.Hits, ps.Misses)
+		}
+	}
+}
+
+func TestHandleStatus_NoPools(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(&mockSearcher{}, &mockFetcher{}, &mockGenerator{}, http.DefaultClient)
+	if pools := getStatus(t, srv).Pools; len(pools) != 0 {
+		t.Errorf("expected no pools, got %+v", pools)
+	}
+}
+
+func TestHandleMeme_AggregatedSources(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+
+	agg, err := potato.NewAggregator([]potato.WeightedSource{
+		{Name: "down", Searcher: &mockSearcher{err: errors.New("down")}, Weight: 1e9},
+		{Name: "mock", Searcher: &mockSearcher{url: imgSrv.URL + "/potato.png"}, Weight: 1},
+	}, potato.HealthConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(agg, &mockFetcher{img: testImage()}, &mockGenerator{anim: testAnimation()}, imgSrv.Client(), testFetcher(imgSrv.Client()))
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Potato-Source"); got != "mock" {
+		t.Errorf("X-Potato-Source = %q, want the working source", got)
+	}
+
+	// None of the sources takes Reddit listings.
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme?sort=new", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /meme?sort=new: expected status 400, got %d", rec.Code)
+	}
+
+	sources := getStatus(t, srv).PotatoSources
+	if len(sources) != 2 || sources[0].Failures != 1 || sources[1].Successes != 1 {
+		t.Errorf("potato sources = %+v, want one failure of down and one success of mock", sources)
+	}
+}
+
+func TestHandleMeme_BrokenSourceIsEjected(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	defer imgSrv.Close()
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("not a png"))
+	}))
+	defer garbage.Close()
+
+	agg, err := potato.NewAggregator([]potato.WeightedSource{
+		{Name: "broken", Searcher: &mockSearcher{result: potato.Result{URL: garbage.URL + "/potato.png", Source: "broken"}}, Weight: 1e9},
+		{Name: "mock", Searcher: &mockSearcher{url: imgSrv.URL + "/potato.png"}, Weight: 1},
+	}, potato.HealthConfig{EjectAfter: 2, EjectFor: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(agg, &mockFetcher{img: testImage()}, &mockGenerator{anim: testAnimation()}, http.DefaultClient, testFetcher(http.DefaultClient))
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme", nil))
+		if rec.Code != http.StatusBadGateway {
+			t.Fatalf("broken image: expected status 502, got %d; body: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/meme", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Potato-Source") != "mock" {
+		t.Errorf("after ejection: got status %d from %q, want 200 from mock", rec.Code, rec.Header().Get("X-Potato-Source"))
+	}
+	if sources := getStatus(t, srv).PotatoSources; !sources[0].Ejected || sources[0].Failures != 2 {
+		t.Errorf("potato sources = %+v, want broken ejected after 2 failures", sources)
+	}
+}
+
 func TestWriteError(t *testing.T) {
 	t.Parallel()
 
@@ -439,6 +1532,12 @@ func TestHandleIndex(t *testing.T) {
 	if !strings.Contains(body, "Generate") {
 		t.Error("expected HTML to contain 'Generate' button")
 	}
+
+	for _, name := range meme.PresetNames() {
+		if !strings.Contains(body, `<option value="`+name+`"`) {
+			t.Errorf("expected HTML to offer the %s preset", name)
+		}
+	}
 }
 
 func TestNewServer_RoutesRegistered(t *testing.T) {
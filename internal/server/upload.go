@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+)
+
+const (
+	// maxUploadBytes caps the whole body of a POST /meme request.
+	maxUploadBytes = 20 << 20
+	// maxUploadImageBytes caps each uploaded image.
+	maxUploadImageBytes = 10 << 20
+	// maxUploadMemory is how much of a multipart form is held in memory;
+	// larger parts are spooled to temporary files.
+	maxUploadMemory = 8 << 20
+)
+
+// uploadContentTypes are the sniffed content types accepted for uploaded
+// images.
+var uploadContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// statusError is an error that maps to a specific HTTP status.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// errorStatus returns the HTTP status carried by err, or 500 if it has none.
+func errorStatus(err error) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.status
+	}
+	return http.StatusInternalServerError
+}
+
+// parseUpload parses the body of a POST /meme request so that its text fields
+// are available through r.FormValue. Bodies that are not multipart are
+// parsed as plain forms.
+func parseUpload(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	err := r.ParseMultipartForm(maxUploadMemory)
+	if err == nil || errors.Is(err, http.ErrNotMultipart) {
+		return nil
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return &statusError{http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxUploadBytes)}
+	}
+	return &statusError{http.StatusBadRequest, fmt.Sprintf("parsing multipart form: %v", err)}
+}
+
+// uploadedImage decodes the image uploaded in the multipart file part named
+// field, keeping an animated GIF animated. It returns a nil image and no error
+// when there is no such part.
+func (s *Server) uploadedImage(r *http.Request, field string) (image.Image, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.File[field]) == 0 {
+		return nil, nil
+	}
+	header := r.MultipartForm.File[field][0]
+	if header.Size > maxUploadImageBytes {
+		return nil, &statusError{http.StatusRequestEntityTooLarge, fmt.Sprintf("%s image exceeds %d bytes", field, maxUploadImageBytes)}
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening %s upload: %w", field, err)
+	}
+	defer f.Close()
+
+	// Trust the bytes, not the client's declared Content-Type.
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(f, sniff)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, &statusError{http.StatusBadRequest, fmt.Sprintf("reading %s upload: %v", field, err)}
+	}
+	sniff = sniff[:n]
+	if ct := http.DetectContentType(sniff); !slices.Contains(uploadContentTypes, ct) {
+		return nil, &statusError{http.StatusUnsupportedMediaType, fmt.Sprintf("%s upload has unsupported type %s", field, ct)}
+	}
+
+	limits := s.limits
+	if limits.MaxBytes <= 0 || limits.MaxBytes > maxUploadImageBytes {
+		limits.MaxBytes = maxUploadImageBytes
+	}
+	img, _, err := limits.DecodeAnimated(io.MultiReader(bytes.NewReader(sniff), f))
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, decode.ErrTooManyPixels), errors.Is(err, decode.ErrTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, decode.ErrFormat):
+			status = http.StatusUnsupportedMediaType
+		}
+		return nil, &statusError{status, fmt.Sprintf("decoding %s upload: %v", field, err)}
+	}
+	return img, nil
+}
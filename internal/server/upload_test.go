@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jefflinse/potato-nice-thelma/internal/decode"
+	"github.com/jefflinse/potato-nice-thelma/internal/meme"
+)
+
+// recordingGenerator is a mockGenerator that also keeps the images it was
+// given.
+type recordingGenerator struct {
+	mockGenerator
+	potato, cat image.Image
+}
+
+func (g *recordingGenerator) Generate(potato, cat image.Image, top, bottom string, opts meme.Options) (*meme.Animation, error) {
+	g.potato, g.cat = potato, cat
+	return g.mockGenerator.Generate(potato, cat, top, bottom, opts)
+}
+
+func (g *recordingGenerator) GenerateRandom(potato, cat image.Image, opts meme.Options) (*meme.Animation, error) {
+	g.potato, g.cat = potato, cat
+	return g.mockGenerator.GenerateRandom(potato, cat, opts)
+}
+
+// multipartBody builds a multipart form from text fields and file parts.
+func multipartBody(t *testing.T, fields map[string]string, files map[string][]byte) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatalf("WriteField(%q): %v", name, err)
+		}
+	}
+	for name, data := range files {
+		fw, err := mw.CreateFormFile(name, name+".bin")
+		if err != nil {
+			t.Fatalf("CreateFormFile(%q): %v", name, err)
+		}
+		fw.Write(data)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("closing multipart writer: %v", err)
+	}
+	return &buf, mw.FormDataContentType()
+}
+
+// pngBytes encodes a w x h PNG.
+func pngBytes(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestHandleMeme_PostUploads(t *testing.T) {
+	t.Parallel()
+
+	imgSrv := pngServer(t)
+	t.Cleanup(imgSrv.Close)
+
+	tests := []struct {
+		name         string
+		files        map[string][]byte
+		wantSearch   bool
+		wantFetch    bool
+		wantPotatoDx int
+		wantCatDx    int
+		wantSource   string
+	}{
+		{"both uploaded", map[string][]byte{"potato": pngBytes(t, 3, 3), "cat": pngBytes(t, 4, 4)}, false, false, 3, 4, "upload"},
+		{"potato only", map[string][]byte{"potato": pngBytes(t, 3, 3)}, false, true, 3, 1, "upload"},
+		{"cat only", map[string][]byte{"cat": pngBytes(t, 4, 4)}, true, false, 1, 4, "mock"},
+		{"no uploads", nil, true, true, 1, 1, "mock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			searcher := &mockSearcher{url: imgSrv.URL + "/potato.png"}
+			fetcher := &mockFetcher{img: testImage()}
+			gen := &recordingGenerator{mockGenerator: mockGenerator{anim: testAnimation()}}
+			srv := NewServer(searcher, fetcher, gen, imgSrv.Client(), testFetcher(imgSrv.Client()))
+
+			body, contentType := multipartBody(t, map[string]string{"top": "hello", "bottom": "world", "format": "png"}, tt.files)
+			req := httptest.NewRequest(http.MethodPost, "/meme", body)
+			req.Header.Set("Content-Type", contentType)
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d; body: %s", rec.Code, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+				t.Errorf("expected format field to select image/png, got %q", ct)
+			}
+			if !gen.generateCalled {
+				t.Error("expected text fields to select Generate")
+			}
+			if searched := searcher.lastQuery != ""; searched != tt.wantSearch {
+				t.Errorf("searcher called = %v, want %v", searched, tt.wantSearch)
+			}
+			if fetcher.called != tt.wantFetch {
+				t.Errorf("fetcher called = %v, want %v", fetcher.called, tt.wantFetch)
+			}
+			if dx := gen.potato.Bounds().Dx(); dx != tt.wantPotatoDx {
+				t.Errorf("potato width = %d, want %d", dx, tt.wantPotatoDx)
+			}
+			if dx := gen.cat.Bounds().Dx(); dx != tt.wantCatDx {
+				t.Errorf("cat width = %d, want %d", dx, tt.wantCatDx)
+			}
+			if src := rec.Header().Get("X-Potato-Source"); src != tt.wantSource {
+				t.Errorf("X-Potato-Source = %q, want %q", src, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestHandleMeme_PostUploadErrors(t *testing.T) {
+	t.Parallel()
+
+	// A valid PNG signature followed by garbage sniffs as PNG but fails to
+	// decode.
+	corruptPNG := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0xff}, 64)...)
+
+	tests := []struct {
+		name       string
+		files      map[string][]byte
+		opts       []Option
+		wantStatus int
+	}{
+		{"not an image", map[string][]byte{"potato": []byte("just some text, honest")}, nil, http.StatusUnsupportedMediaType},
+		{"corrupt image", map[string][]byte{"cat": corruptPNG}, nil, http.StatusBadRequest},
+		{"image too large", map[string][]byte{"potato": make([]byte, maxUploadImageBytes+1)}, nil, http.StatusRequestEntityTooLarge},
+		{"body too large", map[string][]byte{"potato": make([]byte, maxUploadBytes/2), "cat": make([]byte, maxUploadBytes/2)}, nil, http.StatusRequestEntityTooLarge},
+		{"too many pixels", map[string][]byte{"cat": pngBytes(t, 3, 3)}, []Option{WithDecodeLimits(decode.Limits{MaxPixels: 8})}, http.StatusRequestEntityTooLarge},
+		{"format not allowed", map[string][]byte{"cat": pngBytes(t, 3, 3)}, []Option{WithDecodeLimits(decode.Limits{Formats: []string{"jpeg"}})}, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fetcher := &mockFetcher{img: testImage()}
+			srv := NewServer(&mockSearcher{}, fetcher, &mockGenerator{anim: testAnimation()}, http.DefaultClient, tt.opts...)
+
+			body, contentType := multipartBody(t, nil, tt.files)
+			req := httptest.NewRequest(http.MethodPost, "/meme", body)
+			req.Header.Set("Content-Type", contentType)
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d; body: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if fetcher.called {
+				t.Error("rejected upload should not reach the fetchers")
+			}
+		})
+	}
+}